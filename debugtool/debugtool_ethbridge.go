@@ -14,11 +14,20 @@ import (
 	"github.com/thanhn-inc/debugtool/common"
 	"github.com/thanhn-inc/debugtool/metadata"
 	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
 	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
 	"math/big"
 	"strconv"
 )
 
+const (
+	ethDecimals       = 18
+	incBridgeDecimals = 9
+
+	//erc20DecimalsSelector is the selector of the ERC20 function `decimals()`.
+	erc20DecimalsSelector = "0x313ce567"
+)
+
 type ETHDepositProof struct {
 	blockNumber uint
 	blockHash rCommon.Hash
@@ -54,169 +63,180 @@ func NewETHDepositProof(blockNumber uint, blockHash rCommon.Hash, txIdx uint, no
 }
 
 func GetETHTxByHash(url string, txHash string) (map[string]interface{}, error) {
-	responseInBytes, err := rpc.GetETHTransactionByHash(url, txHash)
+	response, err := sendETHRequestWithRetry(func() ([]byte, error) {
+		return rpc.GetETHTransactionByHash(url, txHash)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := rpchandler.ParseResponse(responseInBytes)
+	var res map[string]interface{}
+	err = json.Unmarshal(response.Result, &res)
 	if err != nil {
 		return nil, err
 	}
 
-	var res map[string]interface{}
-	err = json.Unmarshal(response.Result, &res)
-
 	return res, nil
 }
 
 func GetETHBlockByHash(url string, blockHash string) (map[string]interface{}, error) {
-	responseInBytes, err := rpc.GetETHBlockByHash(url, blockHash)
+	response, err := sendETHRequestWithRetry(func() ([]byte, error) {
+		return rpc.GetETHBlockByHash(url, blockHash)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := rpchandler.ParseResponse(responseInBytes)
+	var res map[string]interface{}
+	err = json.Unmarshal(response.Result, &res)
 	if err != nil {
 		return nil, err
 	}
 
-	var res map[string]interface{}
-	err = json.Unmarshal(response.Result, &res)
-
 	return res, nil
 }
 
 func GetETHTxReceipt(url string, txHash string) (*types.Receipt, error) {
-	responseInBytes, err := rpc.GetETHTransactionReceipt(url, txHash)
-	if err != nil {
-		return nil, err
-	}
-
-	response, err := rpchandler.ParseResponse(responseInBytes)
+	response, err := sendETHRequestWithRetry(func() ([]byte, error) {
+		return rpc.GetETHTransactionReceipt(url, txHash)
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	var res types.Receipt
 	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse the receipt of %v: %v", txHash, err))
+	}
 
 	return &res, nil
 }
 
-func GetETHDepositProof(url string, txHash string) (*ETHDepositProof, uint64, error) {
+//GetETHDepositProof returns the proof of an ETH or ERC20 deposit to the Incognito vault, together with the content of the deposit.
+func GetETHDepositProof(url string, txHash string) (*ETHDepositProof, *ETHDepositInfo, error) {
 	// Get tx content
 	txContent, err := GetETHTxByHash(url, txHash)
 	if err != nil {
 		fmt.Println("cannot get eth by hash", err)
-		return nil, 0, err
+		return nil, nil, err
 	}
 
-	_, ok := txContent["value"]
-	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot find value in %v", txContent))
-	}
-	valueStr, ok := txContent["value"].(string)
-	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot parse value in %v", txContent))
-	}
-	amtBigInt, ok := new(big.Int).SetString(valueStr, 16)
-	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot set bigInt value in %v", txContent))
+	depositInfo, err := GetETHDepositInfo(url, txHash)
+	if err != nil {
+		return nil, nil, err
 	}
-	var amount uint64
-	//If ETH, divide ETH to 10^9
-	amount = big.NewInt(0).Div(amtBigInt, big.NewInt(1000000000)).Uint64()
 
-	_, ok = txContent["blockHash"]
+	_, ok := txContent["blockHash"]
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot find blockHash in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot find blockHash in %v", txContent))
 	}
 	blockHashStr, ok := txContent["blockHash"].(string)
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot parse blockHash in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot parse blockHash in %v", txContent))
 	}
 	blockHash := rCommon.HexToHash(blockHashStr)
 
 	_, ok = txContent["transactionIndex"]
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot find transactionIndex in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot find transactionIndex in %v", txContent))
 	}
 	txIndexStr, ok := txContent["transactionIndex"].(string)
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot parse transactionIndex in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot parse transactionIndex in %v", txContent))
 	}
 
 	txIndex, err := strconv.ParseUint(txIndexStr[2:], 16, 64)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 
 	// Get tx's block for constructing receipt trie
 	_, ok = txContent["blockNumber"]
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot find blockNumber in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot find blockNumber in %v", txContent))
 	}
 	blockNumString, ok := txContent["blockNumber"].(string)
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot parse blockNumber in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot parse blockNumber in %v", txContent))
 	}
 	blockNumber, err := strconv.ParseInt(blockNumString[2:], 16, 64)
 	if err != nil {
-		return nil, 0, errors.New("cannot convert blockNumber into integer")
+		return nil, nil, errors.New("cannot convert blockNumber into integer")
 	}
 
 	blockHeader, err := GetETHBlockByHash(url, blockHashStr)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 
 	// Get all sibling Txs
 	_, ok = blockHeader["transactions"]
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot find transactions in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot find transactions in %v", txContent))
 	}
 	siblingTxs, ok := blockHeader["transactions"].([]interface{})
 	if !ok {
-		return nil, 0, errors.New(fmt.Sprintf("cannot parse transactions in %v", txContent))
+		return nil, nil, errors.New(fmt.Sprintf("cannot parse transactions in %v", txContent))
 	}
 
 	fmt.Println("length of transactions in block", len(siblingTxs))
 
+	txHashes := make([]string, 0)
+	for _, tx := range siblingTxs {
+		siblingTxHash, ok := tx.(string)
+		if !ok {
+			return nil, nil, errors.New(fmt.Sprintf("cannot parse transaction %v", tx))
+		}
+		txHashes = append(txHashes, siblingTxHash)
+	}
+
+	fmt.Println("Start getting receipts...")
+	siblingReceipts, err := GetETHBlockReceipts(url, blockHashStr, txHashes, DefaultETHReceiptConcurrency)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Constructing the receipt trie (source: go-ethereum/core/types/derive_sha.go)
 	keyBuf := new(bytes.Buffer)
 	receiptTrie := new(trie.Trie)
 	fmt.Println("Start creating receipt trie...")
-	for i, tx := range siblingTxs {
-		siblingReceipt, err := GetETHTxReceipt(url, tx.(string))
-		if err != nil {
-			return nil, 0, err
-		}
+	for i, siblingReceipt := range siblingReceipts {
 		keyBuf.Reset()
 		err = rlp.Encode(keyBuf, uint(i))
 		if err != nil {
-			return nil, 0, errors.New(fmt.Sprintf("rlp encode returns an error: %v", err))
+			return nil, nil, errors.New(fmt.Sprintf("rlp encode returns an error: %v", err))
 		}
 		encodedReceipt, err := rlp.EncodeToBytes(siblingReceipt)
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, err
 		}
 		receiptTrie.Update(keyBuf.Bytes(), encodedReceipt)
 	}
 
 	fmt.Println("Finish creating receipt trie.")
 
+	receiptsRoot, err := getETHReceiptsRoot(blockHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if receiptTrie.Hash() != receiptsRoot {
+		removeETHReceipts(blockHashStr)
+		return nil, nil, errors.New(fmt.Sprintf("rebuilt receipt trie root %v does not match receiptsRoot %v of block %v",
+			receiptTrie.Hash().String(), receiptsRoot.String(), blockHashStr))
+	}
+
 	// Constructing the proof for the current receipt (source: go-ethereum/trie/proof.go)
 	proof := light.NewNodeSet()
 	keyBuf.Reset()
 	err = rlp.Encode(keyBuf, uint(txIndex))
 	if err != nil {
-		return nil, 0, errors.New(fmt.Sprintf("rlp encode returns an error: %v", err))
+		return nil, nil, errors.New(fmt.Sprintf("rlp encode returns an error: %v", err))
 	}
 	fmt.Println("Start proving receipt trie...")
 	err = receiptTrie.Prove(keyBuf.Bytes(), 0, proof)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 	fmt.Println("Finish proving receipt trie.")
 
@@ -227,16 +247,34 @@ func GetETHDepositProof(url string, txHash string) (*ETHDepositProof, uint64, er
 		encNodeList = append(encNodeList, str)
 	}
 
-	return NewETHDepositProof(uint(blockNumber), blockHash, uint(txIndex), encNodeList), amount, nil
+	return NewETHDepositProof(uint(blockNumber), blockHash, uint(txIndex), encNodeList), depositInfo, nil
 }
 
+//CreateIssuingETHRequestTransaction creates a shielding request for an ETH or ERC20 deposit. If tokenIDStr is empty,
+//the Incognito token ID is looked up from the address of the deposited token. The proof is verified locally before the request is created.
 func CreateIssuingETHRequestTransaction(privateKey string, ethTxHash string, tokenIDStr string) ([]byte, string, error) {
 	fmt.Println("Start getting ETHDepositProof...")
-	proof, amount, err := GetETHDepositProof("", ethTxHash)
+	proof, depositInfo, err := GetETHDepositProof("", ethTxHash)
 	if err != nil {
 		return nil, "", err
 	}
 	fmt.Println("Finish getting ETHDepositProof:", *proof)
+	fmt.Println(depositInfo)
+
+	confirmations, err := VerifyETHDepositProof("", ethTxHash, proof, DefaultETHConfirmations)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("proof verification failed: %v", err))
+	}
+	fmt.Printf("Proof verified, %v confirmations.\n", confirmations)
+
+	if len(tokenIDStr) == 0 {
+		if len(depositInfo.TokenID) == 0 {
+			return nil, "", errors.New(fmt.Sprintf("token %v has never been shielded, a tokenID must be provided", depositInfo.ExternalTokenID.String()))
+		}
+		tokenIDStr = depositInfo.TokenID
+	} else if len(depositInfo.TokenID) != 0 && depositInfo.TokenID != tokenIDStr {
+		return nil, "", errors.New(fmt.Sprintf("token %v is bridged to tokenID %v, got %v", depositInfo.ExternalTokenID.String(), depositInfo.TokenID, tokenIDStr))
+	}
 
 	tokenID, err := new(common.Hash).NewHashFromStr(tokenIDStr)
 	if err != nil {
@@ -246,7 +284,7 @@ func CreateIssuingETHRequestTransaction(privateKey string, ethTxHash string, tok
 	var issuingETHRequestMeta *metadata.IssuingETHRequest
 	issuingETHRequestMeta, err = metadata.NewIssuingETHRequest(proof.BlockHash(), proof.TxIdx(), proof.NodeList(), *tokenID, metadata.IssuingETHRequestMeta)
 	if err != nil {
-		return nil, "", errors.New(fmt.Sprintf("cannot init issue eth request for %v, tokenID %v with amount %v: %v", *proof, tokenIDStr, amount, err))
+		return nil, "", errors.New(fmt.Sprintf("cannot init issue eth request for %v, tokenID %v with amount %v: %v", *proof, tokenIDStr, depositInfo.ShieldAmount, err))
 	}
 
 	txParam := NewTxParam(privateKey, []string{}, []uint64{}, common.PRVIDStr, 1, issuingETHRequestMeta)
@@ -261,7 +299,7 @@ func CreateAndSendIssuingETHRequestTransaction(privateKey string, ethTxHash stri
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTx(string(encodedTx))
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
 	if err != nil {
 		return "", err
 	}
@@ -273,3 +311,171 @@ func CreateAndSendIssuingETHRequestTransaction(privateKey string, ethTxHash stri
 
 	return txHash, nil
 }
+
+//ETHDepositInfo is the content of a deposit to the Incognito vault, parsed from the Deposit log of its receipt.
+type ETHDepositInfo struct {
+	//ExternalTokenID is the address of the deposited ERC20 token, the zero address for ETH.
+	ExternalTokenID  rCommon.Address
+	IncognitoAddress string
+	//Amount is the amount of the Deposit log: in wei for ETH, already scaled down to at most 9 decimals by the vault for ERC20 tokens.
+	Amount   *big.Int
+	Decimals uint8
+	//ShieldAmount is the amount minted on the Incognito chain.
+	ShieldAmount uint64
+	//TokenID is the Incognito token ID of the deposited token, empty if the token has never been shielded.
+	TokenID string
+}
+
+//IsETH checks if the deposit is a native ETH deposit.
+func (info ETHDepositInfo) IsETH() bool {
+	return info.ExternalTokenID == rCommon.Address{}
+}
+
+func (info ETHDepositInfo) String() string {
+	tokenID := info.TokenID
+	if len(tokenID) == 0 {
+		tokenID = "not shielded yet"
+	}
+
+	return fmt.Sprintf("Deposit of %v (decimals %v, tokenID %v): amount %v, shield amount %v, receiver %v",
+		info.ExternalTokenID.String(), info.Decimals, tokenID, info.Amount, info.ShieldAmount, info.IncognitoAddress)
+}
+
+//GetETHDepositInfo parses the Deposit log of an ETH transaction to the Incognito vault. The decimals of ERC20 tokens are read from
+//their contract, and the Incognito token ID is looked up in the bridge tokens.
+func GetETHDepositInfo(url string, txHash string) (*ETHDepositInfo, error) {
+	receipt, err := GetETHTxReceipt(url, txHash)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, errors.New(fmt.Sprintf("eth tx %v failed", txHash))
+	}
+
+	logMap, err := metadata.PickAndParseLogMapFromReceipt(receipt, common.EthContractAddressStr)
+	if err != nil {
+		return nil, err
+	}
+	if logMap == nil {
+		return nil, errors.New(fmt.Sprintf("cannot find a deposit to %v in eth tx %v", common.EthContractAddressStr, txHash))
+	}
+
+	token, ok := logMap["token"].(rCommon.Address)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("cannot parse token in %v", logMap))
+	}
+	incAddr, ok := logMap["incognitoAddress"].(string)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("cannot parse incognitoAddress in %v", logMap))
+	}
+	amount, ok := logMap["amount"].(*big.Int)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("cannot parse amount in %v", logMap))
+	}
+
+	res := &ETHDepositInfo{
+		ExternalTokenID:  token,
+		IncognitoAddress: incAddr,
+		Amount:           amount,
+		Decimals:         ethDecimals,
+	}
+	if !res.IsETH() {
+		res.Decimals, err = GetERC20Decimals(url, token.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res.ShieldAmount, err = getShieldAmount(res)
+	if err != nil {
+		return nil, err
+	}
+
+	res.TokenID, err = GetBridgeTokenID(token.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+//GetERC20Decimals returns the decimals of an ERC20 token, read from its contract.
+func GetERC20Decimals(url string, tokenAddr string) (uint8, error) {
+	responseInBytes, err := rpc.ETHCall(url, tokenAddr, erc20DecimalsSelector)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	var res string
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return 0, err
+	}
+	if len(res) <= 2 {
+		return 0, errors.New(fmt.Sprintf("cannot get the decimals of %v: is it an ERC20 contract?", tokenAddr))
+	}
+
+	decimals, ok := new(big.Int).SetString(res[2:], 16)
+	if !ok || !decimals.IsUint64() || decimals.Uint64() > 255 {
+		return 0, errors.New(fmt.Sprintf("cannot parse the decimals of %v: %v", tokenAddr, res))
+	}
+
+	return uint8(decimals.Uint64()), nil
+}
+
+//GetAllBridgeTokens returns the tokens of the Incognito bridges.
+func GetAllBridgeTokens() ([]jsonresult.BridgeTokenInfo, error) {
+	responseInBytes, err := rpc.ListBridgeTokenByRPC()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []jsonresult.BridgeTokenInfo
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+//GetBridgeTokenID returns the Incognito token ID of an ETH/ERC20 token address, or an empty string if the token has never been shielded.
+func GetBridgeTokenID(externalTokenAddr string) (string, error) {
+	bridgeTokens, err := GetAllBridgeTokens()
+	if err != nil {
+		return "", err
+	}
+
+	externalTokenID := rCommon.HexToAddress(externalTokenAddr).Bytes()
+	for _, token := range bridgeTokens {
+		if !token.IsCentralized && bytes.Equal(token.ExternalTokenID, externalTokenID) {
+			return token.TokenID, nil
+		}
+	}
+
+	return "", nil
+}
+
+//getShieldAmount returns the amount minted on the Incognito chain for a deposit. Only ETH amounts are logged in wei and scaled down
+//to 9 decimals here, the vault has already scaled the amounts of ERC20 tokens.
+func getShieldAmount(info *ETHDepositInfo) (uint64, error) {
+	res := new(big.Int).Set(info.Amount)
+	if info.IsETH() {
+		res.Div(res, new(big.Int).Exp(big.NewInt(10), big.NewInt(ethDecimals-incBridgeDecimals), nil))
+	}
+	if !res.IsUint64() {
+		return 0, errors.New(fmt.Sprintf("shield amount of %v (%v) is out of range", info.Amount, info.ExternalTokenID.String()))
+	}
+
+	return res.Uint64(), nil
+}
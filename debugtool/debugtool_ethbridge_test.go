@@ -0,0 +1,58 @@
+package debugtool
+
+import (
+	"math/big"
+	"testing"
+
+	rCommon "github.com/ethereum/go-ethereum/common"
+)
+
+func TestGetShieldAmount(t *testing.T) {
+	usdt := rCommon.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7")
+	dai := rCommon.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")
+
+	tests := []struct {
+		name     string
+		token    rCommon.Address
+		decimals uint8
+		amount   *big.Int
+		want     uint64
+	}{
+		{
+			//1.5 ETH is logged in wei.
+			name:     "eth",
+			decimals: ethDecimals,
+			amount:   big.NewInt(1500000000000000000),
+			want:     1500000000,
+		},
+		{
+			//2.5 USDT, 6 decimals are kept as is.
+			name:     "6-decimal token",
+			token:    usdt,
+			decimals: 6,
+			amount:   big.NewInt(2500000),
+			want:     2500000,
+		},
+		{
+			//2.5 DAI, logged by the vault with 9 decimals.
+			name:     "18-decimal token",
+			token:    dai,
+			decimals: 18,
+			amount:   big.NewInt(2500000000),
+			want:     2500000000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &ETHDepositInfo{ExternalTokenID: tt.token, Amount: tt.amount, Decimals: tt.decimals}
+			got, err := getShieldAmount(info)
+			if err != nil {
+				t.Fatalf("getShieldAmount: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("getShieldAmount = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package debugtool
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	rCommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/light"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/metadata"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+)
+
+//DefaultETHConfirmations is the number of blocks a deposit needs before it is shielded.
+const DefaultETHConfirmations = 15
+
+//VerifyETHDepositProof checks a deposit proof locally before it is submitted:
+//	- the proof verifies the receipt of ethTxHash against the receiptsRoot of its block;
+//	- the receipt has a deposit log from common.EthContractAddressStr;
+//	- the block has at least minConfirmations confirmations;
+//	- the deposit has not been shielded yet.
+//It returns the number of confirmations of the block.
+func VerifyETHDepositProof(url string, ethTxHash string, proof *ETHDepositProof, minConfirmations uint64) (uint64, error) {
+	blockHeader, err := GetETHBlockByHash(url, proof.BlockHash().String())
+	if err != nil {
+		return 0, err
+	}
+	receiptsRoot, err := getETHReceiptsRoot(blockHeader)
+	if err != nil {
+		return 0, err
+	}
+
+	proofDB := light.NewNodeSet()
+	for _, encodedNode := range proof.NodeList() {
+		node, err := base64.StdEncoding.DecodeString(encodedNode)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("cannot decode proof node %v: %v", encodedNode, err))
+		}
+		err = proofDB.Put(crypto.Keccak256(node), node)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	key, err := rlp.EncodeToBytes(proof.TxIdx())
+	if err != nil {
+		return 0, err
+	}
+	provedReceipt, err := trie.VerifyProof(receiptsRoot, key, proofDB)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid proof against receiptsRoot %v: %v", receiptsRoot.String(), err))
+	}
+	if provedReceipt == nil {
+		return 0, errors.New(fmt.Sprintf("the proof does not contain receipt %v", proof.TxIdx()))
+	}
+
+	receipt, err := GetETHTxReceipt(url, ethTxHash)
+	if err != nil {
+		return 0, err
+	}
+	if receipt.BlockHash != proof.BlockHash() || receipt.TransactionIndex != proof.TxIdx() {
+		return 0, errors.New(fmt.Sprintf("eth tx %v is at index %v of block %v, the proof is for index %v of block %v",
+			ethTxHash, receipt.TransactionIndex, receipt.BlockHash.String(), proof.TxIdx(), proof.BlockHash().String()))
+	}
+	encodedReceipt, err := rlp.EncodeToBytes(receipt)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(provedReceipt, encodedReceipt) {
+		return 0, errors.New(fmt.Sprintf("the proved receipt is not the receipt of %v", ethTxHash))
+	}
+
+	var decodedReceipt types.Receipt
+	err = rlp.DecodeBytes(provedReceipt, &decodedReceipt)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("cannot decode the proved receipt: %v", err))
+	}
+	logMap, err := metadata.PickAndParseLogMapFromReceipt(&decodedReceipt, common.EthContractAddressStr)
+	if err != nil {
+		return 0, err
+	}
+	if logMap == nil {
+		return 0, errors.New(fmt.Sprintf("the proved receipt has no deposit log from %v", common.EthContractAddressStr))
+	}
+
+	latestBlock, err := GetETHBlockNumber(url)
+	if err != nil {
+		return 0, err
+	}
+	confirmations := uint64(0)
+	if latestBlock >= uint64(proof.BlockNumber()) {
+		confirmations = latestBlock - uint64(proof.BlockNumber()) + 1
+	}
+	if confirmations < minConfirmations {
+		return confirmations, errors.New(fmt.Sprintf("block %v has %v confirmations, %v required", proof.BlockNumber(), confirmations, minConfirmations))
+	}
+
+	issued, err := CheckETHHashIssued(proof.BlockHash().String(), proof.TxIdx())
+	if err != nil {
+		return confirmations, err
+	}
+	if issued {
+		return confirmations, errors.New(fmt.Sprintf("deposit %v has already been shielded", ethTxHash))
+	}
+
+	return confirmations, nil
+}
+
+//GetETHBlockNumber returns the number of the latest ETH block.
+func GetETHBlockNumber(url string) (uint64, error) {
+	response, err := sendETHRequestWithRetry(func() ([]byte, error) {
+		return rpc.GetETHBlockNumber(url)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var res string
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return 0, err
+	}
+	if len(res) <= 2 {
+		return 0, errors.New(fmt.Sprintf("cannot parse block number %v", res))
+	}
+
+	blockNumber, ok := new(big.Int).SetString(res[2:], 16)
+	if !ok || !blockNumber.IsUint64() {
+		return 0, errors.New(fmt.Sprintf("cannot parse block number %v", res))
+	}
+
+	return blockNumber.Uint64(), nil
+}
+
+//CheckETHHashIssued checks if the ETH deposit at txIdx of block blockHash has already been shielded.
+func CheckETHHashIssued(blockHash string, txIdx uint) (bool, error) {
+	responseInBytes, err := rpc.CheckETHHashIssued(blockHash, txIdx)
+	if err != nil {
+		return false, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return false, err
+	}
+
+	var res bool
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return false, err
+	}
+
+	return res, nil
+}
+
+func getETHReceiptsRoot(blockHeader map[string]interface{}) (rCommon.Hash, error) {
+	receiptsRootStr, ok := blockHeader["receiptsRoot"].(string)
+	if !ok {
+		return rCommon.Hash{}, errors.New(fmt.Sprintf("cannot parse receiptsRoot in %v", blockHeader))
+	}
+
+	return rCommon.HexToHash(receiptsRootStr), nil
+}
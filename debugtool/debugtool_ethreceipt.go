@@ -0,0 +1,248 @@
+package debugtool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+)
+
+const (
+	DefaultETHReceiptCacheDir    = "ethreceipts"
+	DefaultETHReceiptConcurrency = 8
+
+	ethMaxAttempts = 5
+	ethRetryDelay  = 500 * time.Millisecond
+
+	//ethMethodNotFoundCode is the JSON-RPC error code of an unsupported method.
+	ethMethodNotFoundCode = -32601
+)
+
+var (
+	errETHMethodNotFound = errors.New("method not supported by the eth endpoint")
+
+	ethReceiptCacheDir string
+
+	//ethBlockReceiptsUnsupported records the endpoints without eth_getBlockReceipts.
+	ethBlockReceiptsUnsupported    = make(map[string]bool)
+	ethBlockReceiptsUnsupportedMtx sync.Mutex
+)
+
+//EnableETHReceiptCache stores the receipts fetched for deposit proofs in dir, one file per block hash.
+func EnableETHReceiptCache(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	ethReceiptCacheDir = dir
+
+	return nil
+}
+
+//GetETHBlockReceipts returns the receipts of txHashes, the transactions of a block in order. The receipts are read from the cache if
+//enabled, otherwise fetched with eth_getBlockReceipts if the endpoint supports it, or one by one with at most concurrency requests
+//at a time. Failed requests are retried.
+func GetETHBlockReceipts(url string, blockHash string, txHashes []string, concurrency int) ([]*types.Receipt, error) {
+	if len(url) != 0 {
+		rpchandler.EthServer.InitToURL(url)
+	}
+
+	receipts, err := loadETHReceipts(blockHash, txHashes)
+	if err == nil {
+		return receipts, nil
+	}
+
+	endpoint := rpchandler.EthServer.GetURL()
+	ethBlockReceiptsUnsupportedMtx.Lock()
+	unsupported := ethBlockReceiptsUnsupported[endpoint]
+	ethBlockReceiptsUnsupportedMtx.Unlock()
+
+	receipts = nil
+	if !unsupported {
+		receipts, err = getETHBlockReceiptsByBlock(blockHash, txHashes)
+		if err == errETHMethodNotFound {
+			ethBlockReceiptsUnsupportedMtx.Lock()
+			ethBlockReceiptsUnsupported[endpoint] = true
+			ethBlockReceiptsUnsupportedMtx.Unlock()
+		} else if err != nil {
+			fmt.Printf("eth_getBlockReceipts of %v failed, fetching receipts one by one: %v\n", blockHash, err)
+		}
+	}
+	if receipts == nil {
+		receipts, err = getETHBlockReceiptsByTx(txHashes, concurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = saveETHReceipts(blockHash, receipts)
+	if err != nil {
+		fmt.Printf("cannot cache the receipts of %v: %v\n", blockHash, err)
+	}
+
+	return receipts, nil
+}
+
+func getETHBlockReceiptsByBlock(blockHash string, txHashes []string) ([]*types.Receipt, error) {
+	response, err := sendETHRequestWithRetry(func() ([]byte, error) {
+		return rpc.GetETHBlockReceipts("", blockHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var receipts []*types.Receipt
+	err = json.Unmarshal(response.Result, &receipts)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse the receipts of %v: %v", blockHash, err))
+	}
+
+	err = checkETHReceipts(receipts, txHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+func getETHBlockReceiptsByTx(txHashes []string, concurrency int) ([]*types.Receipt, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultETHReceiptConcurrency
+	}
+
+	receipts := make([]*types.Receipt, len(txHashes))
+	errs := make([]error, len(txHashes))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, txHash := range txHashes {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, txHash string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			receipts[i], errs[i] = GetETHTxReceipt("", txHash)
+		}(i, txHash)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot get the receipt of %v: %v", txHashes[i], err))
+		}
+	}
+
+	return receipts, nil
+}
+
+//sendETHRequestWithRetry sends an ETH RPC request, retrying with an exponential backoff while the request or its response fails.
+//An unsupported method is not retried.
+func sendETHRequestWithRetry(request func() ([]byte, error)) (*rpchandler.JsonResponse, error) {
+	var err error
+	delay := ethRetryDelay
+	for attempt := 1; attempt <= ethMaxAttempts; attempt++ {
+		var responseInBytes []byte
+		responseInBytes, err = request()
+		if err == nil {
+			var response rpchandler.JsonResponse
+			err = json.Unmarshal(responseInBytes, &response)
+			if err == nil {
+				if response.Error == nil {
+					return &response, nil
+				}
+				if response.Error.Code == ethMethodNotFoundCode {
+					return nil, errETHMethodNotFound
+				}
+				err = errors.New(fmt.Sprintf("RPC returns an error: %v", response.Error))
+			}
+		}
+
+		if attempt < ethMaxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("failed after %v attempts: %v", ethMaxAttempts, err))
+}
+
+//checkETHReceipts checks that receipts are those of txHashes, in the same order.
+func checkETHReceipts(receipts []*types.Receipt, txHashes []string) error {
+	if len(receipts) != len(txHashes) {
+		return errors.New(fmt.Sprintf("expect %v receipts, got %v", len(txHashes), len(receipts)))
+	}
+	for i, receipt := range receipts {
+		if receipt == nil || !strings.EqualFold(receipt.TxHash.String(), txHashes[i]) {
+			return errors.New(fmt.Sprintf("receipt %v does not match tx %v", i, txHashes[i]))
+		}
+	}
+
+	return nil
+}
+
+func ethReceiptCacheFile(blockHash string) string {
+	return filepath.Join(ethReceiptCacheDir, strings.ToLower(blockHash)+".json")
+}
+
+func loadETHReceipts(blockHash string, txHashes []string) ([]*types.Receipt, error) {
+	if len(ethReceiptCacheDir) == 0 {
+		return nil, errors.New("receipt cache not enabled")
+	}
+
+	data, err := ioutil.ReadFile(ethReceiptCacheFile(blockHash))
+	if err != nil {
+		return nil, err
+	}
+
+	var receipts []*types.Receipt
+	err = json.Unmarshal(data, &receipts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkETHReceipts(receipts, txHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+func saveETHReceipts(blockHash string, receipts []*types.Receipt) error {
+	if len(ethReceiptCacheDir) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(receipts)
+	if err != nil {
+		return err
+	}
+
+	fileName := ethReceiptCacheFile(blockHash)
+	tmpFile := fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, fileName)
+}
+
+//removeETHReceipts drops the cached receipts of a block, e.g. when they do not match the block.
+func removeETHReceipts(blockHash string) {
+	if len(ethReceiptCacheDir) == 0 {
+		return
+	}
+
+	_ = os.Remove(ethReceiptCacheFile(blockHash))
+}
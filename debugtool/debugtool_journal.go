@@ -0,0 +1,464 @@
+package debugtool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+const (
+	DefaultTxJournalFile  = "txjournal.json"
+	DefaultStuckTxTimeout = 10 * time.Minute
+
+	JournalStatusSent      = "sent"
+	JournalStatusConfirmed = "confirmed"
+	JournalStatusReplaced  = "replaced"
+)
+
+//TxRebuildInfo holds what is needed to rebuild a plain PRV transfer with the same inputs and a different fee.
+type TxRebuildInfo struct {
+	Receivers      []string
+	Amounts        []uint64
+	Memos          []string `json:",omitempty"`
+	Fee            uint64
+	InputKeyImages []string
+}
+
+//TxJournalEntry is a transaction recorded in the journal.
+type TxJournalEntry struct {
+	TxHash            string
+	EncodedTx         string
+	IsTokenTx         bool
+	SentAt            time.Time
+	Status            string
+	Rebroadcasts      int
+	LastRebroadcastAt time.Time      `json:",omitempty"`
+	ReplaceOf         string         `json:",omitempty"`
+	ReplacedBy        string         `json:",omitempty"`
+	Rebuild           *TxRebuildInfo `json:",omitempty"`
+}
+
+func (entry TxJournalEntry) String() string {
+	res := fmt.Sprintf("%v: status %v, token %v, sent at %v, rebroadcasts %v", entry.TxHash, entry.Status, entry.IsTokenTx,
+		entry.SentAt.Format(time.RFC3339), entry.Rebroadcasts)
+	if entry.Rebuild != nil {
+		res += fmt.Sprintf(", fee %v", entry.Rebuild.Fee)
+	}
+	if len(entry.ReplaceOf) != 0 {
+		res += fmt.Sprintf(", replaces %v", entry.ReplaceOf)
+	}
+	if len(entry.ReplacedBy) != 0 {
+		res += fmt.Sprintf(", replaced by %v", entry.ReplacedBy)
+	}
+	return res
+}
+
+//TxJournal stores the raw encoded transactions sent by the tool in a local file, so that they can be rebroadcast later.
+type TxJournal struct {
+	fileName string
+
+	mtx     sync.Mutex
+	entries map[string]*TxJournalEntry
+}
+
+var (
+	txJournal        *TxJournal
+	rebroadcastNodes []*rpchandler.RPCServer
+)
+
+//LoadTxJournal loads a journal from a file. An empty journal is returned if the file does not exist.
+func LoadTxJournal(fileName string) (*TxJournal, error) {
+	journal := &TxJournal{fileName: fileName, entries: make(map[string]*TxJournalEntry)}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return journal, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &journal.entries)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse tx journal %v: %v", fileName, err))
+	}
+
+	return journal, nil
+}
+
+//EnableTxJournal makes every transaction sent by the tool recorded in the given journal file.
+func EnableTxJournal(fileName string) error {
+	journal, err := LoadTxJournal(fileName)
+	if err != nil {
+		return err
+	}
+
+	txJournal = journal
+	return nil
+}
+
+//GetTxJournal returns the journal in use, or nil if the journal is disabled.
+func GetTxJournal() *TxJournal {
+	return txJournal
+}
+
+//SetRebroadcastNodes sets the additional nodes to which stuck transactions are resubmitted, besides the current server.
+func SetRebroadcastNodes(urls []string) {
+	rebroadcastNodes = make([]*rpchandler.RPCServer, 0)
+	for _, url := range urls {
+		rebroadcastNodes = append(rebroadcastNodes, new(rpchandler.RPCServer).InitToURL(url))
+	}
+}
+
+//GetRebroadcastNodes returns the urls of the additional rebroadcast nodes.
+func GetRebroadcastNodes() []string {
+	res := make([]string, 0)
+	for _, server := range rebroadcastNodes {
+		res = append(res, server.GetURL())
+	}
+	return res
+}
+
+func allNodes() []*rpchandler.RPCServer {
+	return append([]*rpchandler.RPCServer{rpchandler.Server}, rebroadcastNodes...)
+}
+
+//Record adds or replaces an entry and persists the journal.
+func (journal *TxJournal) Record(entry *TxJournalEntry) error {
+	journal.mtx.Lock()
+	defer journal.mtx.Unlock()
+
+	journal.entries[entry.TxHash] = entry
+	return journal.save()
+}
+
+//Update applies a change to an entry and persists the journal.
+func (journal *TxJournal) Update(txHash string, f func(entry *TxJournalEntry)) error {
+	journal.mtx.Lock()
+	defer journal.mtx.Unlock()
+
+	entry, ok := journal.entries[txHash]
+	if !ok {
+		return errors.New(fmt.Sprintf("tx %v not found in the journal", txHash))
+	}
+	f(entry)
+	return journal.save()
+}
+
+//Get returns a copy of the entry of a transaction.
+func (journal *TxJournal) Get(txHash string) (*TxJournalEntry, bool) {
+	journal.mtx.Lock()
+	defer journal.mtx.Unlock()
+
+	entry, ok := journal.entries[txHash]
+	if !ok {
+		return nil, false
+	}
+	res := *entry
+	return &res, true
+}
+
+//Entries returns copies of all entries, sorted by sending time.
+func (journal *TxJournal) Entries() []*TxJournalEntry {
+	journal.mtx.Lock()
+	defer journal.mtx.Unlock()
+
+	res := make([]*TxJournalEntry, 0)
+	for _, entry := range journal.entries {
+		tmp := *entry
+		res = append(res, &tmp)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].SentAt.Before(res[j].SentAt)
+	})
+	return res
+}
+
+func (journal *TxJournal) save() error {
+	data, err := json.MarshalIndent(journal.entries, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := journal.fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, journal.fileName)
+}
+
+func sendRawTx(encodedTx []byte, txHash string) ([]byte, error) {
+	return sendAndRecordTx(encodedTx, txHash, false, nil, "")
+}
+
+func sendRawTokenTx(encodedTx []byte, txHash string) ([]byte, error) {
+	return sendAndRecordTx(encodedTx, txHash, true, nil, "")
+}
+
+//sendAndRecordTx sends a transaction to the current server and records it in the journal if the server accepted it.
+func sendAndRecordTx(encodedTx []byte, txHash string, isTokenTx bool, rebuild *TxRebuildInfo, replaceOf string) ([]byte, error) {
+	var responseInBytes []byte
+	var err error
+	if isTokenTx {
+		responseInBytes, err = rpc.SendRawTokenTx(string(encodedTx))
+	} else {
+		responseInBytes, err = rpc.SendRawTx(string(encodedTx))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if txJournal != nil {
+		if _, parseErr := rpchandler.ParseResponse(responseInBytes); parseErr == nil {
+			err = txJournal.Record(&TxJournalEntry{
+				TxHash:    txHash,
+				EncodedTx: string(encodedTx),
+				IsTokenTx: isTokenTx,
+				SentAt:    time.Now(),
+				Status:    JournalStatusSent,
+				ReplaceOf: replaceOf,
+				Rebuild:   rebuild,
+			})
+			if err != nil {
+				fmt.Printf("cannot record tx %v in the journal: %v\n", txHash, err)
+			}
+		}
+	}
+
+	return responseInBytes, nil
+}
+
+//CheckStuckTxs updates the status of the journal entries and returns those not in a block after the given timeout.
+func CheckStuckTxs(timeout time.Duration) ([]*TxJournalEntry, error) {
+	if txJournal == nil {
+		return nil, errors.New("tx journal is not enabled")
+	}
+
+	res := make([]*TxJournalEntry, 0)
+	for _, entry := range txJournal.Entries() {
+		if entry.Status != JournalStatusSent {
+			continue
+		}
+
+		txDetail, found, err := getTxDetail(entry.TxHash)
+		if err != nil {
+			return nil, err
+		}
+		if found && txDetail.IsInBlock {
+			err = txJournal.Update(entry.TxHash, func(entry *TxJournalEntry) {
+				entry.Status = JournalStatusConfirmed
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if time.Since(entry.SentAt) >= timeout {
+			res = append(res, entry)
+		}
+	}
+
+	return res, nil
+}
+
+//RebroadcastTx resubmits the identical raw bytes of a journaled transaction to the current server and every rebroadcast node.
+//
+//It succeeds if at least one node accepted the transaction.
+func RebroadcastTx(txHash string) error {
+	if txJournal == nil {
+		return errors.New("tx journal is not enabled")
+	}
+
+	entry, ok := txJournal.Get(txHash)
+	if !ok {
+		return errors.New(fmt.Sprintf("tx %v not found in the journal", txHash))
+	}
+
+	accepted := 0
+	errList := make([]string, 0)
+	for _, server := range allNodes() {
+		var responseInBytes []byte
+		var err error
+		if entry.IsTokenTx {
+			responseInBytes, err = rpc.SendRawTokenTxToServer(server, entry.EncodedTx)
+		} else {
+			responseInBytes, err = rpc.SendRawTxToServer(server, entry.EncodedTx)
+		}
+		if err == nil {
+			_, err = rpchandler.ParseResponse(responseInBytes)
+		}
+		if err != nil {
+			errList = append(errList, fmt.Sprintf("%v: %v", server.GetURL(), err))
+			continue
+		}
+		fmt.Printf("tx %v rebroadcast to %v\n", txHash, server.GetURL())
+		accepted++
+	}
+
+	err := txJournal.Update(txHash, func(entry *TxJournalEntry) {
+		entry.Rebroadcasts++
+		entry.LastRebroadcastAt = time.Now()
+	})
+	if err != nil {
+		return err
+	}
+
+	if accepted == 0 {
+		return errors.New(fmt.Sprintf("no node accepted tx %v: %v", txHash, strings.Join(errList, "; ")))
+	}
+	for _, errStr := range errList {
+		fmt.Println("rebroadcast error:", errStr)
+	}
+
+	return nil
+}
+
+//RebroadcastStuckTxs rebroadcasts every journaled transaction not in a block after the given timeout.
+func RebroadcastStuckTxs(timeout time.Duration) ([]string, error) {
+	stuckTxs, err := CheckStuckTxs(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0)
+	for _, entry := range stuckTxs {
+		fmt.Printf("tx %v has not been in a block for %v, rebroadcasting...\n", entry.TxHash, time.Since(entry.SentAt).Round(time.Second))
+		err = RebroadcastTx(entry.TxHash)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		res = append(res, entry.TxHash)
+	}
+
+	return res, nil
+}
+
+//IsTxAbsentFromAllNodes returns true if neither the current server nor any rebroadcast node knows the transaction,
+//either in its mempool or in a block.
+func IsTxAbsentFromAllNodes(txHash string) (bool, error) {
+	for _, server := range allNodes() {
+		_, found, err := getTxDetailFromServer(server, txHash)
+		if err != nil {
+			return false, errors.New(fmt.Sprintf("cannot check tx %v on %v: %v", txHash, server.GetURL(), err))
+		}
+		if found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+//isTxDropped checks that a transaction of privateKey is missing from every known node and that none of its inputs, given per token ID, has been spent.
+func isTxDropped(privateKey, txHash string, inputs map[string][]string) (bool, error) {
+	if len(inputs) == 0 {
+		return false, errors.New(fmt.Sprintf("inputs of tx %v unknown", txHash))
+	}
+
+	isAbsent, err := IsTxAbsentFromAllNodes(txHash)
+	if err != nil || !isAbsent {
+		return false, err
+	}
+
+	keyWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	if err != nil {
+		return false, err
+	}
+	shardID := common.GetShardIDFromLastByte(keyWallet.KeySet.PaymentAddress.Pk[len(keyWallet.KeySet.PaymentAddress.Pk)-1])
+
+	for tokenID, keyImages := range inputs {
+		if len(keyImages) == 0 {
+			continue
+		}
+		spentList, err := CheckCoinsSpent(shardID, tokenID, keyImages)
+		if err != nil {
+			return false, err
+		}
+		for _, spent := range spentList {
+			if spent {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+//BumpTxFee rebuilds a journaled PRV transfer with a higher fee, spending the same inputs so that at most one of them can be
+//accepted by the network, and sends it.
+//
+//The original transaction must have been dropped from the mempool of every known node.
+func BumpTxFee(privateKey, txHash string, newFee uint64) (string, error) {
+	if txJournal == nil {
+		return "", errors.New("tx journal is not enabled")
+	}
+
+	entry, ok := txJournal.Get(txHash)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("tx %v not found in the journal", txHash))
+	}
+	if entry.Status != JournalStatusSent {
+		return "", errors.New(fmt.Sprintf("tx %v is %v", txHash, entry.Status))
+	}
+	if entry.Rebuild == nil {
+		return "", errors.New(fmt.Sprintf("tx %v cannot be rebuilt: only PRV transfers without metadata are supported", txHash))
+	}
+	if newFee <= entry.Rebuild.Fee {
+		return "", errors.New(fmt.Sprintf("new fee %v must be greater than the current fee %v", newFee, entry.Rebuild.Fee))
+	}
+
+	isAbsent, err := IsTxAbsentFromAllNodes(txHash)
+	if err != nil {
+		return "", err
+	}
+	if !isAbsent {
+		return "", errors.New(fmt.Sprintf("tx %v is still known by at least one node, try rebroadcasting it instead", txHash))
+	}
+
+	txParam := NewTxParam(privateKey, entry.Rebuild.Receivers, entry.Rebuild.Amounts, common.PRVIDStr, 0, nil)
+	txParam.SetMemoList(entry.Rebuild.Memos)
+	txParam.SetFee(newFee)
+	txParam.SetInputKeyImages(entry.Rebuild.InputKeyImages)
+
+	encodedTx, newTxHash, err := CreateRawTransaction(txParam, -1)
+	if err != nil {
+		return "", err
+	}
+
+	rebuild := *entry.Rebuild
+	rebuild.Fee = newFee
+	responseInBytes, err := sendAndRecordTx(encodedTx, newTxHash, false, &rebuild, txHash)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return "", err
+	}
+
+	err = txJournal.Update(txHash, func(entry *TxJournalEntry) {
+		entry.Status = JournalStatusReplaced
+		entry.ReplacedBy = newTxHash
+	})
+	if err != nil {
+		return newTxHash, err
+	}
+
+	return newTxHash, nil
+}
@@ -0,0 +1,326 @@
+package debugtool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/common/base58"
+	"github.com/thanhn-inc/debugtool/privacy"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+const (
+	LocalNetRoleBeacon = "beacon"
+	LocalNetRoleShard  = "shard"
+
+	DefaultLocalNetSeed     = "localnet"
+	DefaultLocalNetBasePort = 9334
+	DefaultLocalNetBootnode = "127.0.0.1:9330"
+	DefaultLocalNetBinary   = "./incognito"
+)
+
+//LocalNetConfig describes a local network to generate.
+type LocalNetConfig struct {
+	NumShards           int
+	ShardCommitteeSize  int
+	BeaconCommitteeSize int
+	//BasePort is the P2P port of the first node. Node i listens on BasePort+2i and serves RPC on BasePort+2i+1.
+	BasePort int
+
+	//Seed makes the generated keys reproducible: the same seed always gives the same network.
+	Seed string
+	//AccountsPerShard is the number of test accounts generated in each shard. They are not funded: the genesis PRV of a
+	//node comes from its compiled-in initial transactions, so they must receive PRV from a funded key.
+	AccountsPerShard int
+
+	Bootnode string
+	Binary   string
+}
+
+//NewLocalNetConfig returns a configuration with default values for everything but the sizes of the network.
+func NewLocalNetConfig(numShards, shardCommitteeSize, beaconCommitteeSize, basePort int) *LocalNetConfig {
+	return &LocalNetConfig{
+		NumShards:           numShards,
+		ShardCommitteeSize:  shardCommitteeSize,
+		BeaconCommitteeSize: beaconCommitteeSize,
+		BasePort:            basePort,
+		Seed:                DefaultLocalNetSeed,
+		AccountsPerShard:    1,
+		Bootnode:            DefaultLocalNetBootnode,
+		Binary:              DefaultLocalNetBinary,
+	}
+}
+
+func (config *LocalNetConfig) validate() error {
+	if config.NumShards <= 0 || config.NumShards > common.MaxShardNumber {
+		return errors.New(fmt.Sprintf("the number of shards must be between 1 and %v", common.MaxShardNumber))
+	}
+	if config.ShardCommitteeSize <= 0 || config.BeaconCommitteeSize <= 0 {
+		return errors.New("committee sizes must be positive")
+	}
+	numNodes := config.BeaconCommitteeSize + config.NumShards*config.ShardCommitteeSize
+	if config.BasePort <= 0 || config.BasePort+2*numNodes > 65535 {
+		return errors.New(fmt.Sprintf("invalid base port %v for %v nodes", config.BasePort, numNodes))
+	}
+	if len(config.Seed) == 0 {
+		return errors.New("the seed must not be empty")
+	}
+	if config.AccountsPerShard < 0 {
+		return errors.New("the number of test accounts must not be negative")
+	}
+	return nil
+}
+
+//LocalNetKey is the key set of a generated validator.
+type LocalNetKey struct {
+	PrivateKey     string
+	PaymentAddress string
+	ReadOnlyKey    string
+	//MiningKey is the value of the `--miningkeys` flag of the node.
+	MiningKey          string
+	CommitteePublicKey string
+	BLSPublicKey       string
+}
+
+//LocalNetNode is a node of a local network, member of the genesis committee of its chain.
+type LocalNetNode struct {
+	Name       string
+	Role       string
+	ShardID    int //BeaconChainID for beacon nodes
+	ListenPort int
+	RPCPort    int
+	DataDir    string
+	Key        LocalNetKey
+}
+
+//LocalNetAccount is a test account of a given shard. It holds no PRV until a funded key sends some to it.
+type LocalNetAccount struct {
+	PrivateKey     string
+	PaymentAddress string
+	ReadOnlyKey    string
+	ShardID        byte
+}
+
+//LocalNet is a generated local network: its genesis committees, nodes and test accounts.
+type LocalNet struct {
+	Config   *LocalNetConfig
+	Beacon   []*LocalNetNode
+	Shards   [][]*LocalNetNode
+	Accounts []*LocalNetAccount
+}
+
+//Nodes returns the beacon nodes, then the nodes of each shard.
+func (net *LocalNet) Nodes() []*LocalNetNode {
+	res := append([]*LocalNetNode{}, net.Beacon...)
+	for _, shardNodes := range net.Shards {
+		res = append(res, shardNodes...)
+	}
+	return res
+}
+
+//GenerateLocalNet creates the keys and the nodes of a local network. Validator key sets are derived from a master key built
+//from the seed, their committee keys from a mining key also derived from the seed.
+func GenerateLocalNet(config *LocalNetConfig) (*LocalNet, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	validatorMaster, err := wallet.NewMasterKey(privacy.HashToScalar([]byte(config.Seed + "/validators")).ToBytesS())
+	if err != nil {
+		return nil, err
+	}
+	accountMaster, err := wallet.NewMasterKey(privacy.HashToScalar([]byte(config.Seed + "/accounts")).ToBytesS())
+	if err != nil {
+		return nil, err
+	}
+
+	net := &LocalNet{Config: config, Shards: make([][]*LocalNetNode, config.NumShards)}
+	nodeIndex := 0
+	newNode := func(name, role string, shardID int) (*LocalNetNode, error) {
+		key, err := newLocalNetKey(validatorMaster, uint32(nodeIndex), config.Seed)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot generate key of %v: %v", name, err))
+		}
+		node := &LocalNetNode{
+			Name:       name,
+			Role:       role,
+			ShardID:    shardID,
+			ListenPort: config.BasePort + 2*nodeIndex,
+			RPCPort:    config.BasePort + 2*nodeIndex + 1,
+			DataDir:    filepath.Join("data", name),
+			Key:        *key,
+		}
+		nodeIndex++
+		return node, nil
+	}
+
+	for i := 0; i < config.BeaconCommitteeSize; i++ {
+		node, err := newNode(fmt.Sprintf("beacon-%v", i), LocalNetRoleBeacon, BeaconChainID)
+		if err != nil {
+			return nil, err
+		}
+		net.Beacon = append(net.Beacon, node)
+	}
+	for shardID := 0; shardID < config.NumShards; shardID++ {
+		for i := 0; i < config.ShardCommitteeSize; i++ {
+			node, err := newNode(fmt.Sprintf("shard%v-%v", shardID, i), LocalNetRoleShard, shardID)
+			if err != nil {
+				return nil, err
+			}
+			net.Shards[shardID] = append(net.Shards[shardID], node)
+		}
+	}
+
+	//Child keys are derived in order until every shard has its accounts.
+	generated := make([]int, config.NumShards)
+	remaining := config.NumShards * config.AccountsPerShard
+	for childIdx := uint32(0); remaining > 0; childIdx++ {
+		child, err := accountMaster.NewChildKey(childIdx)
+		if err != nil {
+			return nil, err
+		}
+		pk := child.KeySet.PaymentAddress.Pk
+		shardID := common.GetShardIDFromLastByte(pk[len(pk)-1])
+		if int(shardID) >= config.NumShards || generated[shardID] == config.AccountsPerShard {
+			continue
+		}
+
+		net.Accounts = append(net.Accounts, &LocalNetAccount{
+			PrivateKey:     child.Base58CheckSerialize(wallet.PriKeyType),
+			PaymentAddress: child.Base58CheckSerialize(wallet.PaymentAddressType),
+			ReadOnlyKey:    child.Base58CheckSerialize(wallet.ReadonlyKeyType),
+			ShardID:        shardID,
+		})
+		generated[shardID]++
+		remaining--
+	}
+
+	return net, nil
+}
+
+func newLocalNetKey(master *wallet.KeyWallet, childIdx uint32, seed string) (*LocalNetKey, error) {
+	child, err := master.NewChildKey(childIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &LocalNetKey{
+		PrivateKey:     child.Base58CheckSerialize(wallet.PriKeyType),
+		PaymentAddress: child.Base58CheckSerialize(wallet.PaymentAddressType),
+		ReadOnlyKey:    child.Base58CheckSerialize(wallet.ReadonlyKeyType),
+	}
+
+	miningSeed := privacy.HashToScalar([]byte(fmt.Sprintf("%v/mining/%v", seed, childIdx))).ToBytesS()
+	key.MiningKey = base58.Base58Check{}.Encode(miningSeed, common.ZeroByte)
+
+	committeePK, err := GetCommitteeKeyFromMiningSeed(key.PaymentAddress, key.MiningKey)
+	if err != nil {
+		return nil, err
+	}
+	key.CommitteePublicKey, err = committeePK.ToBase58()
+	if err != nil {
+		return nil, err
+	}
+	key.BLSPublicKey = committeePK.GetMiningKeyBase58(common.BlsConsensus)
+
+	return key, nil
+}
+
+//LaunchCommand returns the command line starting a node.
+func (net *LocalNet) LaunchCommand(node *LocalNetNode) string {
+	return fmt.Sprintf("%v --datadir \"%v\" --rpclisten \"0.0.0.0:%v\" --listen \"0.0.0.0:%v\" --externaladdress \"127.0.0.1:%v\" --miningkeys \"%v\" --discoverpeersaddress \"%v\" --norpcauth",
+		net.Config.Binary, node.DataDir, node.RPCPort, node.ListenPort, node.ListenPort, node.Key.MiningKey, net.Config.Bootnode)
+}
+
+type localNetKeyListEntry struct {
+	PaymentAddress     string
+	CommitteePublicKey string
+	MiningKey          string
+}
+
+//Write creates the bundle of the network in a directory:
+//  - keylist.json: the genesis beacon and shard committees;
+//  - accounts.json: the test accounts, with their private keys;
+//  - nodes/<name>.json and nodes/<name>.sh: the configuration and the launch script of each node;
+//  - run_all.sh: a script starting every node in the background.
+//
+//Files holding private or mining keys are readable by the owner only.
+func (net *LocalNet) Write(dir string) error {
+	nodesDir := filepath.Join(dir, "nodes")
+	err := os.MkdirAll(nodesDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	keyList := struct {
+		Beacon []localNetKeyListEntry
+		Shard  map[string][]localNetKeyListEntry
+	}{Beacon: make([]localNetKeyListEntry, 0), Shard: make(map[string][]localNetKeyListEntry)}
+	for _, node := range net.Beacon {
+		keyList.Beacon = append(keyList.Beacon, localNetKeyListEntry{node.Key.PaymentAddress, node.Key.CommitteePublicKey, node.Key.MiningKey})
+	}
+	for shardID, shardNodes := range net.Shards {
+		entries := make([]localNetKeyListEntry, 0)
+		for _, node := range shardNodes {
+			entries = append(entries, localNetKeyListEntry{node.Key.PaymentAddress, node.Key.CommitteePublicKey, node.Key.MiningKey})
+		}
+		keyList.Shard[strconv.Itoa(shardID)] = entries
+	}
+	err = writeLocalNetJSON(filepath.Join(dir, "keylist.json"), keyList, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = writeLocalNetJSON(filepath.Join(dir, "accounts.json"), net.Accounts, 0600)
+	if err != nil {
+		return err
+	}
+
+	runAll := "#!/bin/sh\n#Starts every node of the local network in the background, logs go to logs/<name>.log.\ncd \"$(dirname \"$0\")\"\nmkdir -p logs\n"
+	for _, node := range net.Nodes() {
+		err = writeLocalNetJSON(filepath.Join(nodesDir, node.Name+".json"), node, 0600)
+		if err != nil {
+			return err
+		}
+
+		script := fmt.Sprintf("#!/bin/sh\n#%v node %v\n%v\n", node.Role, node.Name, net.LaunchCommand(node))
+		err = ioutil.WriteFile(filepath.Join(nodesDir, node.Name+".sh"), []byte(script), 0700)
+		if err != nil {
+			return err
+		}
+
+		runAll += fmt.Sprintf("sh nodes/%v.sh > logs/%v.log 2>&1 &\n", node.Name, node.Name)
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "run_all.sh"), []byte(runAll), 0700)
+}
+
+func writeLocalNetJSON(fileName string, v interface{}, perm os.FileMode) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, perm)
+}
+
+func (net *LocalNet) String() string {
+	s := fmt.Sprintf("Local network: %v shard(s) of %v node(s), %v beacon node(s), seed %q\n",
+		net.Config.NumShards, net.Config.ShardCommitteeSize, net.Config.BeaconCommitteeSize, net.Config.Seed)
+	for _, node := range net.Nodes() {
+		s += fmt.Sprintf("\t%v: p2p %v, rpc %v, %v\n", node.Name, node.ListenPort, node.RPCPort, node.Key.PaymentAddress)
+	}
+	accounts := make([]string, 0)
+	for _, account := range net.Accounts {
+		accounts = append(accounts, fmt.Sprintf("\tshard %v: %v", account.ShardID, account.PaymentAddress))
+	}
+	s += fmt.Sprintf("Test accounts (not funded):\n%v", strings.Join(accounts, "\n"))
+	return s
+}
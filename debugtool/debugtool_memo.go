@@ -0,0 +1,136 @@
+package debugtool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/incognitokey"
+	"github.com/thanhn-inc/debugtool/privacy"
+	"github.com/thanhn-inc/debugtool/privacy/coin"
+	"github.com/thanhn-inc/debugtool/privacy/privacy_v1/hybridencryption"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+const (
+	//memoEncryptionOverhead is the size of the ElGamal-encrypted AES key (64 bytes) and the AES IV (16 bytes).
+	memoEncryptionOverhead = 80
+	//memoChecksumSize is the size of the checksum prepended to a memo, used to recognize memos encrypted to us.
+	memoChecksumSize = 4
+
+	//MaxMemoSize is the maximum length (in bytes) of a memo that fits in the info field of an output coin.
+	MaxMemoSize = privacy.MaxSizeInfoCoin - memoEncryptionOverhead - memoChecksumSize
+)
+
+//EncryptMemo encrypts a memo to the owner of the given payment address, so that it can be put in the info field of an output coin.
+func EncryptMemo(memo []byte, paymentAddress privacy.PaymentAddress) ([]byte, error) {
+	if len(memo) == 0 {
+		return []byte{}, nil
+	}
+	if len(memo) > MaxMemoSize {
+		return nil, errors.New(fmt.Sprintf("memo too large: %v bytes, maximum allowed: %v bytes", len(memo), MaxMemoSize))
+	}
+
+	publicKey, err := new(privacy.Point).FromBytesS(paymentAddress.Tk)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid transmission key: %v", err))
+	}
+
+	msg := append(common.HashB(memo)[:memoChecksumSize], memo...)
+	cipherText, err := hybridencryption.HybridEncrypt(msg, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipherText.Bytes(), nil
+}
+
+//DecryptMemo decrypts the info field of an output coin using the read-only key of the receiver.
+//
+//It returns an error if the info field does not contain a memo encrypted to this key.
+func DecryptMemo(info []byte, keySet *incognitokey.KeySet) ([]byte, error) {
+	if len(info) == 0 {
+		return []byte{}, nil
+	}
+	if len(keySet.ReadonlyKey.Rk) == 0 {
+		return nil, errors.New("cannot decrypt memo: missing read-only key")
+	}
+	//SetBytes accepts an info field holding only the encrypted AES key, which would make the AES decryption panic.
+	if len(info) < memoEncryptionOverhead {
+		return nil, errors.New("info is not an encrypted memo")
+	}
+
+	cipherText := new(privacy.HybridCipherText)
+	err := cipherText.SetBytes(info)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := hybridencryption.HybridDecrypt(cipherText, new(privacy.Scalar).FromBytesS(keySet.ReadonlyKey.Rk))
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < memoChecksumSize {
+		return nil, errors.New("info is not an encrypted memo")
+	}
+
+	memo := msg[memoChecksumSize:]
+	if !bytes.Equal(msg[:memoChecksumSize], common.HashB(memo)[:memoChecksumSize]) {
+		return nil, errors.New("info is not an encrypted memo")
+	}
+
+	return memo, nil
+}
+
+//DecryptCoinMemo returns the memo attached to a coin received by the owner of keySet.
+func DecryptCoinMemo(keySet *incognitokey.KeySet, c coin.PlainCoin) (string, error) {
+	memo, err := DecryptMemo(c.GetInfo(), keySet)
+	if err != nil {
+		return "", err
+	}
+
+	return string(memo), nil
+}
+
+//newMemoKeySet returns the key set of a private key, with the read-only key needed to decrypt memos.
+func newMemoKeySet(privateKey string) (*incognitokey.KeySet, error) {
+	keyWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	keySet := keyWallet.KeySet
+	if len(keySet.ReadonlyKey.Rk) == 0 {
+		err = keySet.InitFromPrivateKey(&keySet.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &keySet, nil
+}
+
+//GetListDecryptedCoinsWithMemos decrypts a list of output coins like GetListDecryptedCoins, and also returns the memo attached
+//to each coin. Coins without a memo, or with an info field not encrypted to us, have an empty memo.
+func GetListDecryptedCoinsWithMemos(privateKey string, listOutputCoins []jsonresult.ICoinInfo) ([]coin.PlainCoin, []string, []string, error) {
+	listDecryptedCoins, listKeyImages, err := GetListDecryptedCoins(privateKey, listOutputCoins)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keySet, err := newMemoKeySet(privateKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	listMemos := make([]string, len(listDecryptedCoins))
+	for i, decryptedCoin := range listDecryptedCoins {
+		memo, err := DecryptCoinMemo(keySet, decryptedCoin)
+		if err != nil {
+			continue
+		}
+		listMemos[i] = memo
+	}
+
+	return listDecryptedCoins, listKeyImages, listMemos, nil
+}
@@ -0,0 +1,53 @@
+package debugtool
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/thanhn-inc/debugtool/incognitokey"
+)
+
+func TestDecryptMemo(t *testing.T) {
+	keySet := new(incognitokey.KeySet).GenerateKey([]byte("memo receiver"))
+	otherKeySet := new(incognitokey.KeySet).GenerateKey([]byte("someone else"))
+
+	memo := []byte("invoice #42")
+	info, err := EncryptMemo(memo, keySet.PaymentAddress)
+	if err != nil {
+		t.Fatalf("EncryptMemo: %v", err)
+	}
+
+	got, err := DecryptMemo(info, keySet)
+	if err != nil {
+		t.Fatalf("DecryptMemo: %v", err)
+	}
+	if !bytes.Equal(got, memo) {
+		t.Fatalf("DecryptMemo = %q, want %q", got, memo)
+	}
+
+	garbage := make([]byte, 100)
+	for i := range garbage {
+		garbage[i] = byte(i * 7)
+	}
+
+	tests := []struct {
+		name   string
+		info   []byte
+		keySet *incognitokey.KeySet
+	}{
+		{name: "encrypted key only", info: info[:64], keySet: keySet},
+		{name: "truncated iv", info: info[:70], keySet: keySet},
+		{name: "iv without message", info: info[:79], keySet: keySet},
+		{name: "short plaintext", info: []byte("hello"), keySet: keySet},
+		{name: "garbage", info: garbage, keySet: keySet},
+		{name: "memo of another receiver", info: info, keySet: otherKeySet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecryptMemo(tt.info, tt.keySet)
+			if err == nil {
+				t.Fatalf("DecryptMemo succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package debugtool
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/metadata"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+)
+
+const DefaultStuckThreshold = 5 * time.Minute
+
+var metaTypeNames = map[int]string{
+	metadata.IssuingRequestMeta:                    "IssuingRequest",
+	metadata.ContractingRequestMeta:                "ContractingRequest",
+	metadata.IssuingETHRequestMeta:                 "IssuingETHRequest",
+	metadata.IssuingETHResponseMeta:                "IssuingETHResponse",
+	metadata.WithDrawRewardRequestMeta:             "WithdrawRewardRequest",
+	metadata.WithDrawRewardResponseMeta:            "WithdrawRewardResponse",
+	metadata.ReturnStakingMeta:                     "ReturnStaking",
+	metadata.ShardStakingMeta:                      "ShardStaking",
+	metadata.BeaconStakingMeta:                     "BeaconStaking",
+	metadata.StopAutoStakingMeta:                   "StopAutoStaking",
+	metadata.BurningRequestMeta:                    "BurningRequest",
+	metadata.BurningRequestMetaV2:                  "BurningRequestV2",
+	metadata.BurningForDepositToSCRequestMeta:      "BurningForDepositToSCRequest",
+	metadata.PDEContributionMeta:                   "PDEContribution",
+	metadata.PDETradeRequestMeta:                   "PDETradeRequest",
+	metadata.PDETradeResponseMeta:                  "PDETradeResponse",
+	metadata.PDEWithdrawalRequestMeta:              "PDEWithdrawalRequest",
+	metadata.PDEWithdrawalResponseMeta:             "PDEWithdrawalResponse",
+	metadata.PDEContributionResponseMeta:           "PDEContributionResponse",
+	metadata.PDEPRVRequiredContributionRequestMeta: "PDEPRVRequiredContributionRequest",
+	metadata.PDECrossPoolTradeRequestMeta:          "PDECrossPoolTradeRequest",
+	metadata.PDECrossPoolTradeResponseMeta:         "PDECrossPoolTradeResponse",
+	metadata.PDEFeeWithdrawalRequestMeta:           "PDEFeeWithdrawalRequest",
+	metadata.PDEFeeWithdrawalResponseMeta:          "PDEFeeWithdrawalResponse",
+}
+
+//GetMetadataTypeName returns a human-readable name of a metadata type.
+func GetMetadataTypeName(metaType int) string {
+	if metaType == 0 {
+		return "None"
+	}
+	if name, ok := metaTypeNames[metaType]; ok {
+		return name
+	}
+	return fmt.Sprintf("Meta-%v", metaType)
+}
+
+//MempoolTxInfo is the decoded view of a transaction sitting in the mempool.
+type MempoolTxInfo struct {
+	TxHash       string
+	Type         string
+	Version      int8
+	MetadataType int
+	MetadataName string
+	TokenID      string
+	Fee          uint64
+	TokenFee     uint64
+	Size         uint64
+	ShardID      byte
+	FirstSeen    time.Time
+	DecodeErr    error
+}
+
+//FeePerKb returns the PRV fee rate of the transaction (unit: nano per KB).
+func (info MempoolTxInfo) FeePerKb() float64 {
+	if info.Size == 0 {
+		return 0
+	}
+	return float64(info.Fee) / float64(info.Size)
+}
+
+//TimeInMempool returns how long the transaction has been observed in the mempool.
+func (info MempoolTxInfo) TimeInMempool() time.Duration {
+	return time.Since(info.FirstSeen)
+}
+
+func (info MempoolTxInfo) String() string {
+	if info.DecodeErr != nil {
+		return fmt.Sprintf("%v: cannot decode: %v, in mempool %v", info.TxHash, info.DecodeErr, info.TimeInMempool().Round(time.Second))
+	}
+	res := fmt.Sprintf("%v: shard %v, type %v(v%v), meta %v, fee %v, size %vKB, feeRate %.2f/KB, in mempool %v",
+		info.TxHash, info.ShardID, info.Type, info.Version, info.MetadataName, info.Fee, info.Size, info.FeePerKb(), info.TimeInMempool().Round(time.Second))
+	if len(info.TokenID) != 0 {
+		res += fmt.Sprintf(", token %v, tokenFee %v", info.TokenID, info.TokenFee)
+	}
+	return res
+}
+
+//MempoolSnapshot is the result of one polling round of a MempoolMonitor.
+type MempoolSnapshot struct {
+	Time            time.Time
+	Txs             []*MempoolTxInfo
+	NewTxs          []string
+	RemovedTxs      []string
+	StuckTxs        []*MempoolTxInfo
+	ShardPoolStates map[int][]uint64
+}
+
+//MempoolMonitor polls the mempool and the shard pools, decodes newly seen transactions and
+//flags those staying in the mempool longer than StuckThreshold.
+type MempoolMonitor struct {
+	StuckThreshold time.Duration
+
+	mtx sync.Mutex
+	txs map[string]*MempoolTxInfo
+}
+
+func NewMempoolMonitor(stuckThreshold time.Duration) *MempoolMonitor {
+	if stuckThreshold == 0 {
+		stuckThreshold = DefaultStuckThreshold
+	}
+	return &MempoolMonitor{
+		StuckThreshold: stuckThreshold,
+		txs:            make(map[string]*MempoolTxInfo),
+	}
+}
+
+//Update polls the network once and returns the current view of the mempool.
+func (m *MempoolMonitor) Update() (*MempoolSnapshot, error) {
+	txList, err := GetRawMempool()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	snapshot := &MempoolSnapshot{Time: time.Now(), ShardPoolStates: make(map[int][]uint64)}
+
+	current := make(map[string]bool)
+	for _, txHash := range txList {
+		current[txHash] = true
+		if _, ok := m.txs[txHash]; ok {
+			continue
+		}
+
+		info := DecodeMempoolTx(txHash)
+		info.FirstSeen = snapshot.Time
+		m.txs[txHash] = info
+		snapshot.NewTxs = append(snapshot.NewTxs, txHash)
+	}
+
+	for txHash := range m.txs {
+		if !current[txHash] {
+			delete(m.txs, txHash)
+			snapshot.RemovedTxs = append(snapshot.RemovedTxs, txHash)
+		}
+	}
+
+	for _, info := range m.txs {
+		snapshot.Txs = append(snapshot.Txs, info)
+		if info.TimeInMempool() >= m.StuckThreshold {
+			snapshot.StuckTxs = append(snapshot.StuckTxs, info)
+		}
+	}
+	sort.Slice(snapshot.Txs, func(i, j int) bool {
+		return snapshot.Txs[i].FirstSeen.Before(snapshot.Txs[j].FirstSeen)
+	})
+	sort.Slice(snapshot.StuckTxs, func(i, j int) bool {
+		return snapshot.StuckTxs[i].FirstSeen.Before(snapshot.StuckTxs[j].FirstSeen)
+	})
+
+	activeShards, err := GetActiveShard()
+	if err != nil {
+		return snapshot, err
+	}
+	for shardID := 0; shardID < activeShards; shardID++ {
+		heights, err := GetShardPoolState(byte(shardID))
+		if err != nil {
+			fmt.Printf("cannot get pool state of shard %v: %v\n", shardID, err)
+			continue
+		}
+		snapshot.ShardPoolStates[shardID] = heights
+	}
+
+	return snapshot, nil
+}
+
+//DecodeMempoolTx retrieves a transaction and decodes its type, metadata, fee and size.
+func DecodeMempoolTx(txHash string) *MempoolTxInfo {
+	info := &MempoolTxInfo{TxHash: txHash}
+
+	txDetail, found, err := getTxDetail(txHash)
+	if err != nil {
+		info.DecodeErr = err
+		return info
+	}
+	if !found {
+		info.DecodeErr = fmt.Errorf("tx not found")
+		return info
+	}
+
+	info.Type = txDetail.Type
+	info.Version = txDetail.Version
+	info.Fee = txDetail.Fee
+	info.Size = txDetail.TxSize
+	info.ShardID = txDetail.ShardID
+	info.TokenID = txDetail.PrivacyCustomTokenID
+	info.TokenFee = txDetail.PrivacyCustomTokenFee
+	info.MetadataName = GetMetadataTypeName(0)
+
+	if len(txDetail.Metadata) != 0 {
+		var md metadata.MetadataBase
+		err = json.Unmarshal([]byte(txDetail.Metadata), &md)
+		if err != nil {
+			info.DecodeErr = fmt.Errorf("cannot parse metadata %v: %v", txDetail.Metadata, err)
+			return info
+		}
+		info.MetadataType = md.Type
+		info.MetadataName = GetMetadataTypeName(md.Type)
+	}
+
+	return info
+}
+
+//GetShardPoolState returns the heights of the blocks currently in the pool of a shard.
+func GetShardPoolState(shardID byte) ([]uint64, error) {
+	responseInBytes, err := rpc.GetShardPoolState(shardID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var heights []uint64
+	err = json.Unmarshal(response.Result, &heights)
+	if err != nil {
+		return nil, err
+	}
+
+	return heights, nil
+}
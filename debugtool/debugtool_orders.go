@@ -0,0 +1,567 @@
+package debugtool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+)
+
+const (
+	//OrderLimit trades when the rate (amount received per unit sold) reaches TargetRate or more.
+	//A limit buy of token B with token A and a limit sell of token A for token B are both limit orders selling A for B.
+	OrderLimit = "limit"
+	//OrderTakeProfit trades when the rate reaches TargetRate or more.
+	OrderTakeProfit = "takeprofit"
+	//OrderStopLoss trades when the rate falls to TargetRate or less.
+	OrderStopLoss = "stoploss"
+	//OrderDCA trades Amount every Interval, Count times. If TargetRate is not 0, a run is skipped when the rate is lower.
+	OrderDCA = "dca"
+
+	OrderOpen      = "open"
+	OrderFilled    = "filled"
+	OrderCancelled = "cancelled"
+
+	OrderFillPending  = "pending"
+	OrderFillAccepted = PDETradeAccepted
+	OrderFillRefunded = PDETradeRefunded
+	//OrderFillFailed is a trade whose transaction never made it to a block.
+	OrderFillFailed = "failed"
+
+	DefaultOrderBookFile     = "orders.json"
+	DefaultOrderPollInterval = 30 * time.Second
+
+	orderFillTrackTimeout = 2 * time.Minute
+	//orderFillTxTimeout is how long a trade transaction may stay unknown to the node before it is checked on every node
+	//and given up.
+	orderFillTxTimeout = 30 * time.Minute
+)
+
+//OrderFill is a trade sent for an order.
+type OrderFill struct {
+	Time          time.Time
+	TxHash        string
+	SellAmount    uint64
+	QuotedAmount  uint64
+	MinAmount     uint64
+	Rate          float64
+	Status        string
+	ReceiveAmount uint64 `json:",omitempty"`
+	Reason        string `json:",omitempty"`
+}
+
+//Order is a conditional or scheduled trade executed by the OrderEngine.
+type Order struct {
+	ID          string
+	Owner       string //payment address of the private key allowed to execute the order
+	Type        string
+	TokenToSell string
+	TokenToBuy  string
+	Amount      uint64
+	TargetRate  float64
+	SlippageBps uint64
+	TradingFee  uint64
+
+	Interval time.Duration `json:",omitempty"`
+	Count    int           `json:",omitempty"`
+	NextRun  time.Time     `json:",omitempty"`
+
+	Status    string
+	CreatedAt time.Time
+	Fills     []*OrderFill
+	LastError string `json:",omitempty"`
+}
+
+func (order Order) String() string {
+	res := fmt.Sprintf("%v [%v] %v: sell %v %v for %v", order.ID, order.Status, order.Type, order.Amount, order.TokenToSell, order.TokenToBuy)
+	switch order.Type {
+	case OrderDCA:
+		res += fmt.Sprintf(", every %v, %v/%v done, next run %v", order.Interval, order.countSent(), order.Count, order.NextRun.Format(time.RFC3339))
+		if order.TargetRate != 0 {
+			res += fmt.Sprintf(", minimum rate %v", order.TargetRate)
+		}
+	case OrderStopLoss:
+		res += fmt.Sprintf(" when rate <= %v", order.TargetRate)
+	default:
+		res += fmt.Sprintf(" when rate >= %v", order.TargetRate)
+	}
+	if len(order.LastError) != 0 {
+		res += fmt.Sprintf(", last error: %v", order.LastError)
+	}
+	for _, fill := range order.Fills {
+		res += fmt.Sprintf("\n\t%v tx %v: sold %v at rate %v (quoted %v), %v", fill.Time.Format(time.RFC3339), fill.TxHash, fill.SellAmount, fill.Rate, fill.QuotedAmount, fill.Status)
+		if fill.Status == OrderFillAccepted {
+			res += fmt.Sprintf(", received %v", fill.ReceiveAmount)
+		} else if len(fill.Reason) != 0 {
+			res += fmt.Sprintf(", %v", fill.Reason)
+		}
+	}
+	return res
+}
+
+func (order *Order) pendingFill() *OrderFill {
+	for _, fill := range order.Fills {
+		if fill.Status == OrderFillPending {
+			return fill
+		}
+	}
+	return nil
+}
+
+//countSent returns the number of DCA runs sent, refunded and failed trades excluded.
+func (order *Order) countSent() int {
+	count := 0
+	for _, fill := range order.Fills {
+		if fill.Status != OrderFillRefunded && fill.Status != OrderFillFailed {
+			count++
+		}
+	}
+	return count
+}
+
+//isTriggered checks the trigger condition of an order at the given rate and time.
+func (order *Order) isTriggered(rate float64, now time.Time) bool {
+	switch order.Type {
+	case OrderLimit, OrderTakeProfit:
+		return rate >= order.TargetRate
+	case OrderStopLoss:
+		return rate <= order.TargetRate
+	case OrderDCA:
+		return !now.Before(order.NextRun) && (order.TargetRate == 0 || rate >= order.TargetRate)
+	}
+	return false
+}
+
+//NewOrder validates and creates an open order. The owner is given by its private key or its payment address;
+//only an OrderEngine running with the owner's private key executes the order.
+func NewOrder(ownerKey, orderType, tokenToSell, tokenToBuy string, amount uint64, targetRate float64, interval time.Duration, count int) (*Order, error) {
+	if tokenToSell == tokenToBuy {
+		return nil, errors.New("cannot trade a token to itself")
+	}
+	if amount == 0 {
+		return nil, errors.New("amount must be positive")
+	}
+
+	switch orderType {
+	case OrderLimit, OrderTakeProfit, OrderStopLoss:
+		if targetRate <= 0 {
+			return nil, errors.New(fmt.Sprintf("%v order needs a positive target rate", orderType))
+		}
+	case OrderDCA:
+		if interval <= 0 || count <= 0 {
+			return nil, errors.New("dca order needs a positive interval and count")
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown order type %v, expect one of %v, %v, %v, %v", orderType, OrderLimit, OrderTakeProfit, OrderStopLoss, OrderDCA))
+	}
+
+	owner, err := getPaymentAddressFromKey(ownerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	now := time.Now()
+	return &Order{
+		ID:          hex.EncodeToString(b),
+		Owner:       owner,
+		Type:        orderType,
+		TokenToSell: tokenToSell,
+		TokenToBuy:  tokenToBuy,
+		Amount:      amount,
+		TargetRate:  targetRate,
+		SlippageBps: DefaultPDETradeSlippage,
+		Interval:    interval,
+		Count:       count,
+		NextRun:     now,
+		Status:      OrderOpen,
+		CreatedAt:   now,
+		Fills:       make([]*OrderFill, 0),
+	}, nil
+}
+
+//OrderBook is a list of orders stored in a local file.
+type OrderBook struct {
+	fileName string
+	Orders   []*Order
+}
+
+//LoadOrderBook loads an order book from a file. An empty book is returned if the file does not exist.
+func LoadOrderBook(fileName string) (*OrderBook, error) {
+	book := &OrderBook{fileName: fileName, Orders: make([]*Order, 0)}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return book, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &book.Orders)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse order book %v: %v", fileName, err))
+	}
+
+	return book, nil
+}
+
+//Save writes the order book to its file. The file is replaced atomically.
+func (book *OrderBook) Save() error {
+	data, err := json.MarshalIndent(book.Orders, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := book.fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, book.fileName)
+}
+
+//Get returns an order by its ID (or a unique prefix of it).
+func (book *OrderBook) Get(orderID string) (*Order, error) {
+	var res *Order
+	for _, order := range book.Orders {
+		if strings.HasPrefix(order.ID, orderID) {
+			if res != nil {
+				return nil, errors.New(fmt.Sprintf("order ID %v is ambiguous", orderID))
+			}
+			res = order
+		}
+	}
+	if res == nil {
+		return nil, errors.New(fmt.Sprintf("order %v not found", orderID))
+	}
+	return res, nil
+}
+
+//AddOrder adds an order to the book file.
+func AddOrder(fileName string, order *Order) error {
+	book, err := LoadOrderBook(fileName)
+	if err != nil {
+		return err
+	}
+
+	book.Orders = append(book.Orders, order)
+	return book.Save()
+}
+
+//CancelOrder cancels an open order of the book file. A trade already sent for the order is not cancelled.
+func CancelOrder(fileName, orderID string) (*Order, error) {
+	book, err := LoadOrderBook(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := book.Get(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != OrderOpen {
+		return nil, errors.New(fmt.Sprintf("order %v is %v", order.ID, order.Status))
+	}
+
+	order.Status = OrderCancelled
+	return order, book.Save()
+}
+
+//ListOrders returns the orders of the book file, open orders first.
+func ListOrders(fileName string) ([]*Order, error) {
+	book, err := LoadOrderBook(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	res := book.Orders
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Status == OrderOpen && res[j].Status != OrderOpen
+	})
+	return res, nil
+}
+
+//OrderEngine executes the open orders of a private key from an order book file.
+//
+//The book is reloaded before each step and before each trade, so orders added or cancelled from another process are taken into account.
+//The changes of an order are written to a freshly loaded book, so that a restarted engine resumes the orders and the tracking of
+//their trades without overwriting the changes made meanwhile by another process.
+type OrderEngine struct {
+	PrivateKey   string
+	FileName     string
+	PollInterval time.Duration
+	OnEvent      func(order *Order, msg string)
+
+	owner string
+}
+
+//NewOrderEngine creates an engine for the orders of a private key.
+func NewOrderEngine(privateKey, fileName string) (*OrderEngine, error) {
+	owner, err := getPaymentAddressFromKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderEngine{
+		PrivateKey:   privateKey,
+		FileName:     fileName,
+		PollInterval: DefaultOrderPollInterval,
+		OnEvent:      func(*Order, string) {},
+		owner:        owner,
+	}, nil
+}
+
+//Run executes orders until the context is done.
+func (engine *OrderEngine) Run(ctx context.Context) error {
+	for {
+		err := engine.Step(ctx)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(engine.PollInterval):
+		}
+	}
+}
+
+//Step checks every open order of the engine once: it updates the trades in flight, then sends the trades of triggered orders.
+func (engine *OrderEngine) Step(ctx context.Context) error {
+	book, err := LoadOrderBook(engine.FileName)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range book.Orders {
+		if order.Status != OrderOpen || !isSameAddress(order.Owner, engine.owner) {
+			continue
+		}
+
+		changed := engine.processOrder(ctx, order)
+		if changed {
+			err = engine.saveOrder(order)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//processOrder handles an open order and returns true if it has been changed.
+func (engine *OrderEngine) processOrder(ctx context.Context, order *Order) bool {
+	if fill := order.pendingFill(); fill != nil {
+		return engine.updateFill(ctx, order, fill)
+	}
+
+	options := &PDETradeOptions{SlippageBps: order.SlippageBps, TradingFee: order.TradingFee}
+	quote, err := GetPDETradeQuote(order.TokenToSell, order.TokenToBuy, order.Amount, options)
+	if err != nil {
+		return engine.setError(order, fmt.Sprintf("cannot get quote: %v", err))
+	}
+	rate := float64(quote.ExpectedAmount) / float64(order.Amount)
+
+	now := time.Now()
+	if !order.isTriggered(rate, now) {
+		if order.Type == OrderDCA && !now.Before(order.NextRun) {
+			//The rate is too low for this run, skip it.
+			order.NextRun = order.NextRun.Add(order.Interval)
+			engine.OnEvent(order, fmt.Sprintf("rate %v lower than %v, run skipped", rate, order.TargetRate))
+			return true
+		}
+		return false
+	}
+
+	err = engine.checkBalance(order)
+	if err != nil {
+		return engine.setError(order, err.Error())
+	}
+
+	//The order may have been cancelled from another process while the quote and the balance were fetched.
+	current, err := engine.loadOrder(order.ID)
+	if err != nil {
+		return engine.setError(order, err.Error())
+	}
+	if current.Status != OrderOpen {
+		engine.OnEvent(order, fmt.Sprintf("order %v meanwhile, trade not sent", current.Status))
+		return false
+	}
+
+	engine.OnEvent(order, fmt.Sprintf("triggered at rate %v, sending trade", rate))
+	txHash, err := CreateAndSendPDETradeTransactionWithQuote(engine.PrivateKey, quote, -1)
+	if err != nil {
+		return engine.setError(order, fmt.Sprintf("cannot send trade: %v", err))
+	}
+
+	order.LastError = ""
+	order.Fills = append(order.Fills, &OrderFill{
+		Time:         now,
+		TxHash:       txHash,
+		SellAmount:   order.Amount,
+		QuotedAmount: quote.ExpectedAmount,
+		MinAmount:    quote.MinAmount,
+		Rate:         rate,
+		Status:       OrderFillPending,
+	})
+	if order.Type == OrderDCA {
+		order.NextRun = now.Add(order.Interval)
+	}
+	engine.OnEvent(order, fmt.Sprintf("trade sent: %v", txHash))
+
+	return true
+}
+
+//checkBalance makes sure the owner can pay the selling amount, the trading fee and the transaction fee.
+func (engine *OrderEngine) checkBalance(order *Order) error {
+	required := map[string]uint64{common.PRVIDStr: DefaultPRVFee}
+	required[order.TokenToSell] += order.Amount
+	if order.TokenToSell != common.PRVIDStr && order.TokenToBuy != common.PRVIDStr {
+		required[common.PRVIDStr] += order.TradingFee
+	} else {
+		required[order.TokenToSell] += order.TradingFee
+	}
+
+	for tokenID, amount := range required {
+		balance, err := GetBalance(engine.PrivateKey, tokenID)
+		if err != nil {
+			return errors.New(fmt.Sprintf("cannot get balance of %v: %v", tokenID, err))
+		}
+		if balance < amount {
+			return errors.New(fmt.Sprintf("balance of %v insufficient: need %v, have %v", tokenID, amount, balance))
+		}
+	}
+
+	return nil
+}
+
+//updateFill checks the result of a trade in flight and updates the order accordingly.
+func (engine *OrderEngine) updateFill(ctx context.Context, order *Order, fill *OrderFill) bool {
+	status, err := GetPDETradeStatus(fill.TxHash)
+	if err != nil {
+		return engine.setError(order, fmt.Sprintf("cannot get status of trade %v: %v", fill.TxHash, err))
+	}
+	if status == common.PDENotFoundStatus {
+		return engine.checkFillTx(order, fill)
+	}
+
+	trackCtx, cancel := context.WithTimeout(ctx, orderFillTrackTimeout)
+	defer cancel()
+	res, err := TrackPDETrade(trackCtx, "", fill.TxHash, nil, 0, nil)
+	if err != nil {
+		return engine.setError(order, fmt.Sprintf("cannot get result of trade %v: %v", fill.TxHash, err))
+	}
+
+	fill.Status = res.Status
+	fill.ReceiveAmount = res.ReceiveAmount
+	fill.Reason = res.RefundReason
+	order.LastError = ""
+	engine.OnEvent(order, fmt.Sprintf("trade %v %v", fill.TxHash, fill.Status))
+
+	switch order.Type {
+	case OrderDCA:
+		if order.countSent() >= order.Count {
+			order.Status = OrderFilled
+		}
+	default:
+		//A refunded trade leaves the order open, it is sent again when the condition holds.
+		if fill.Status == OrderFillAccepted {
+			order.Status = OrderFilled
+		}
+	}
+
+	return true
+}
+
+//checkFillTx checks the transaction of a trade not processed yet. If it has not reached a block and no node knows it after
+//orderFillTxTimeout, the fill is marked failed so that the order can trade again.
+func (engine *OrderEngine) checkFillTx(order *Order, fill *OrderFill) bool {
+	txDetail, found, err := getTxDetail(fill.TxHash)
+	if err != nil {
+		return engine.setError(order, fmt.Sprintf("cannot get trade tx %v: %v", fill.TxHash, err))
+	}
+	if found && txDetail.IsInBlock {
+		//The trade waits for the beacon chain to process it.
+		return false
+	}
+	if time.Since(fill.Time) < orderFillTxTimeout {
+		return false
+	}
+	if found {
+		return engine.setError(order, fmt.Sprintf("trade tx %v still in mempool after %v", fill.TxHash, orderFillTxTimeout))
+	}
+
+	absent, err := IsTxAbsentFromAllNodes(fill.TxHash)
+	if err != nil {
+		return engine.setError(order, err.Error())
+	}
+	if !absent {
+		return engine.setError(order, fmt.Sprintf("trade tx %v not found on the current node after %v but known by another node", fill.TxHash, orderFillTxTimeout))
+	}
+
+	fill.Status = OrderFillFailed
+	fill.Reason = fmt.Sprintf("tx not found on any node after %v", orderFillTxTimeout)
+	order.LastError = ""
+	engine.OnEvent(order, fmt.Sprintf("trade %v %v: %v", fill.TxHash, fill.Status, fill.Reason))
+
+	return true
+}
+
+//loadOrder returns an order as currently stored in the book file.
+func (engine *OrderEngine) loadOrder(orderID string) (*Order, error) {
+	book, err := LoadOrderBook(engine.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range book.Orders {
+		if order.ID == orderID {
+			return order, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("order %v no longer in %v", orderID, engine.FileName))
+}
+
+//saveOrder writes an order to a freshly loaded book, leaving the other orders as they are in the file. An order cancelled
+//meanwhile stays cancelled, with the trades sent for it recorded.
+func (engine *OrderEngine) saveOrder(order *Order) error {
+	book, err := LoadOrderBook(engine.FileName)
+	if err != nil {
+		return err
+	}
+
+	for i, stored := range book.Orders {
+		if stored.ID != order.ID {
+			continue
+		}
+		if stored.Status == OrderCancelled {
+			order.Status = OrderCancelled
+		}
+		book.Orders[i] = order
+		return book.Save()
+	}
+	return errors.New(fmt.Sprintf("order %v no longer in %v", order.ID, engine.FileName))
+}
+
+func (engine *OrderEngine) setError(order *Order, msg string) bool {
+	engine.OnEvent(order, msg)
+	if order.LastError == msg {
+		return false
+	}
+	order.LastError = msg
+	return true
+}
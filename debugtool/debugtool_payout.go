@@ -0,0 +1,379 @@
+package debugtool
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/privacy/privacy_util"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+const (
+	//MaxPayoutReceiversPerTx is the maximum number of receivers in a payout transaction, one output is kept for the change.
+	MaxPayoutReceiversPerTx = privacy_util.MaxOutputCoin - 1
+
+	PayoutRowPending = "pending"
+	PayoutRowSent    = "sent"
+	PayoutRowPaid    = "paid"
+
+	payoutConfirmTimeout = 15 * time.Minute
+)
+
+//PayoutRow is a line of a payout file.
+type PayoutRow struct {
+	Line    int
+	Address string
+	Amount  uint64
+	Memo    string `json:",omitempty"`
+	Status  string
+	TxHash  string `json:",omitempty"`
+}
+
+//key identifies a row by its content, so that adding or removing lines of the file does not change the key of the other rows.
+func (row PayoutRow) key() string {
+	return fmt.Sprintf("%v-%v-%q", row.Address, row.Amount, row.Memo)
+}
+
+//payoutRowKeys returns the key of each row, followed by its occurrence among the rows with the same content.
+func payoutRowKeys(rows []*PayoutRow) []string {
+	count := make(map[string]int)
+	res := make([]string, len(rows))
+	for i, row := range rows {
+		key := row.key()
+		res[i] = fmt.Sprintf("%v#%v", key, count[key])
+		count[key]++
+	}
+	return res
+}
+
+//PayoutState records which rows of a payout file have been paid, and in which transaction.
+type PayoutState struct {
+	File    string
+	TokenID string
+	Rows    []*PayoutRow
+	//TxInputs holds the key images of the coins spent by each payout transaction, per token ID.
+	TxInputs map[string]map[string][]string `json:",omitempty"`
+}
+
+//ParsePayoutFile reads a CSV file in which each line is `ADDRESS,AMOUNT[,MEMO]`. A header line starting with `address` is allowed.
+//
+//Every address is validated, the function fails if any line is invalid.
+func ParsePayoutFile(fileName string) ([]*PayoutRow, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows := make([]*PayoutRow, 0)
+	invalidLines := make([]string, 0)
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot read line %v of %v: %v", line, fileName, err))
+		}
+		if len(record) == 0 || (len(record) == 1 && len(strings.TrimSpace(record[0])) == 0) {
+			continue
+		}
+		if line == 1 && strings.ToLower(strings.TrimSpace(record[0])) == "address" {
+			continue
+		}
+
+		row, err := parsePayoutRecord(line, record)
+		if err != nil {
+			invalidLines = append(invalidLines, err.Error())
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	if len(invalidLines) != 0 {
+		return nil, errors.New(fmt.Sprintf("%v invalid lines in %v:\n%v", len(invalidLines), fileName, strings.Join(invalidLines, "\n")))
+	}
+	if len(rows) == 0 {
+		return nil, errors.New(fmt.Sprintf("no receiver found in %v", fileName))
+	}
+
+	return rows, nil
+}
+
+func parsePayoutRecord(line int, record []string) (*PayoutRow, error) {
+	if len(record) < 2 || len(record) > 3 {
+		return nil, errors.New(fmt.Sprintf("line %v: expect ADDRESS,AMOUNT[,MEMO], got %v fields", line, len(record)))
+	}
+
+	addr := strings.TrimSpace(record[0])
+	keyWallet, err := wallet.Base58CheckDeserialize(addr)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("line %v: invalid address %v: %v", line, addr, err))
+	}
+	if len(keyWallet.KeySet.PaymentAddress.Pk) == 0 {
+		return nil, errors.New(fmt.Sprintf("line %v: %v is not a payment address", line, addr))
+	}
+
+	amount, err := strconv.ParseUint(strings.TrimSpace(record[1]), 10, 64)
+	if err != nil || amount == 0 {
+		return nil, errors.New(fmt.Sprintf("line %v: invalid amount %v", line, record[1]))
+	}
+
+	row := &PayoutRow{Line: line, Address: addr, Amount: amount, Status: PayoutRowPending}
+	if len(record) == 3 {
+		row.Memo = record[2]
+		if len(row.Memo) > MaxMemoSize {
+			return nil, errors.New(fmt.Sprintf("line %v: memo too large: %v bytes, maximum allowed: %v bytes", line, len(row.Memo), MaxMemoSize))
+		}
+	}
+
+	return row, nil
+}
+
+//LoadPayoutState loads a payout state file. It returns nil if the file does not exist.
+func LoadPayoutState(stateFile string) (*PayoutState, error) {
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state PayoutState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse state file %v: %v", stateFile, err))
+	}
+
+	return &state, nil
+}
+
+//Save writes the state to a file. The file is replaced atomically so that a crash never leaves a partial state.
+func (state *PayoutState) Save(stateFile string) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := stateFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, stateFile)
+}
+
+//Summary returns the number of rows and the total amount for each status.
+func (state *PayoutState) Summary() (map[string]int, map[string]uint64) {
+	count := make(map[string]int)
+	total := make(map[string]uint64)
+	for _, row := range state.Rows {
+		count[row.Status]++
+		total[row.Status] += row.Amount
+	}
+
+	return count, total
+}
+
+func (state *PayoutState) rowsByStatus(status string) []*PayoutRow {
+	res := make([]*PayoutRow, 0)
+	for _, row := range state.Rows {
+		if row.Status == status {
+			res = append(res, row)
+		}
+	}
+	return res
+}
+
+func (state *PayoutState) setStatus(rows []*PayoutRow, status, txHash string) {
+	for _, row := range rows {
+		row.Status = status
+		row.TxHash = txHash
+	}
+}
+
+//mergePayoutState restores the status of rows already processed in a previous run. It fails if a row paid or sent by
+//a previous run is no longer in the file, since the receivers cannot be matched safely anymore.
+func mergePayoutState(rows []*PayoutRow, oldState *PayoutState) error {
+	if oldState == nil {
+		return nil
+	}
+
+	oldRows := make(map[string]*PayoutRow)
+	for i, key := range payoutRowKeys(oldState.Rows) {
+		oldRows[key] = oldState.Rows[i]
+	}
+	for i, key := range payoutRowKeys(rows) {
+		if oldRow, ok := oldRows[key]; ok {
+			rows[i].Status = oldRow.Status
+			rows[i].TxHash = oldRow.TxHash
+			delete(oldRows, key)
+		}
+	}
+
+	//The rows left in oldRows have no match in the file.
+	missingRows := make([]string, 0)
+	for i, key := range payoutRowKeys(oldState.Rows) {
+		oldRow := oldState.Rows[i]
+		if _, ok := oldRows[key]; ok && oldRow.Status != PayoutRowPending {
+			missingRows = append(missingRows, fmt.Sprintf("line %v: %v %v (%v, tx %v)", oldRow.Line, oldRow.Amount, oldRow.Address, oldRow.Status, oldRow.TxHash))
+		}
+	}
+	if len(missingRows) != 0 {
+		return errors.New(fmt.Sprintf("%v rows processed by the previous run are no longer in %v, restore them or use a new state file:\n%v",
+			len(missingRows), oldState.File, strings.Join(missingRows, "\n")))
+	}
+
+	return nil
+}
+
+//Payout pays every receiver listed in a CSV payout file (see ParsePayoutFile) with PRV or a token.
+//
+//Receivers are split into transactions of at most MaxPayoutReceiversPerTx outputs. Transactions are sent one after another, each one
+//waiting for the previous one to be confirmed so that its change can be spent. The progress is recorded in stateFile after every step,
+//and a rerun with the same state file skips the rows already paid.
+func Payout(privateKey, fileName, tokenIDStr, stateFile string) (*PayoutState, error) {
+	rows, err := ParsePayoutFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	oldState, err := LoadPayoutState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	if oldState != nil && oldState.TokenID != tokenIDStr {
+		return nil, errors.New(fmt.Sprintf("state file %v was created for token %v, not %v", stateFile, oldState.TokenID, tokenIDStr))
+	}
+	err = mergePayoutState(rows, oldState)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &PayoutState{File: fileName, TokenID: tokenIDStr, Rows: rows, TxInputs: make(map[string]map[string][]string)}
+	if oldState != nil {
+		for txHash, inputs := range oldState.TxInputs {
+			state.TxInputs[txHash] = inputs
+		}
+	}
+	err = state.Save(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	//Resolve the transactions sent by a previous run but not yet known to be confirmed.
+	sentTxs := make(map[string][]*PayoutRow)
+	for _, row := range state.rowsByStatus(PayoutRowSent) {
+		sentTxs[row.TxHash] = append(sentTxs[row.TxHash], row)
+	}
+	for txHash, txRows := range sentTxs {
+		fmt.Printf("Checking tx %v of the previous run (%v rows)...\n", txHash, len(txRows))
+		err = confirmPayoutTx(privateKey, state, stateFile, txHash, txRows)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	pendingRows := state.rowsByStatus(PayoutRowPending)
+	fmt.Printf("Paying %v/%v rows with %v transactions\n", len(pendingRows), len(state.Rows), (len(pendingRows)+MaxPayoutReceiversPerTx-1)/MaxPayoutReceiversPerTx)
+
+	for start := 0; start < len(pendingRows); start += MaxPayoutReceiversPerTx {
+		end := start + MaxPayoutReceiversPerTx
+		if end > len(pendingRows) {
+			end = len(pendingRows)
+		}
+		chunk := pendingRows[start:end]
+
+		addrList := make([]string, len(chunk))
+		amountList := make([]uint64, len(chunk))
+		memoList := make([]string, len(chunk))
+		for i, row := range chunk {
+			addrList[i] = row.Address
+			amountList[i] = row.Amount
+			memoList[i] = row.Memo
+		}
+
+		var txParam *TxParam
+		var txHash string
+		if tokenIDStr == common.PRVIDStr {
+			txParam = NewTxParam(privateKey, addrList, amountList, common.PRVIDStr, 0, nil)
+			txParam.SetMemoList(memoList)
+			txHash, err = createAndSendRawTransactionWithParam(txParam, -1)
+		} else {
+			txParam = newTokenTransferTxParam(privateKey, addrList, amountList, memoList, tokenIDStr, false)
+			txHash, err = createAndSendRawTokenTransactionWithParam(txParam, -1)
+		}
+		if err != nil {
+			return state, errors.New(fmt.Sprintf("cannot send payout for lines %v-%v: %v", chunk[0].Line, chunk[len(chunk)-1].Line, err))
+		}
+		if len(txHash) == 0 {
+			return state, errors.New(fmt.Sprintf("cannot send payout for lines %v-%v: empty tx hash", chunk[0].Line, chunk[len(chunk)-1].Line))
+		}
+
+		fmt.Printf("Payout tx %v sent for lines %v-%v\n", txHash, chunk[0].Line, chunk[len(chunk)-1].Line)
+		state.setStatus(chunk, PayoutRowSent, txHash)
+		state.TxInputs[txHash] = txParam.spentKeyImages()
+		err = state.Save(stateFile)
+		if err != nil {
+			return state, err
+		}
+
+		err = confirmPayoutTx(privateKey, state, stateFile, txHash, chunk)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	return state, nil
+}
+
+//confirmPayoutTx waits for a payout transaction and records the result.
+//
+//Rows of a dropped or rejected transaction are pending again only if the transaction is unknown to every node and none of its
+//inputs has been spent, so that a rerun never pays a receiver twice. Otherwise they are left sent for a manual resolution.
+func confirmPayoutTx(privateKey string, state *PayoutState, stateFile, txHash string, rows []*PayoutRow) error {
+	res, err := WaitForTx(context.Background(), txHash, 1, payoutConfirmTimeout, func(update TxStatusUpdate) {
+		fmt.Println(update)
+	})
+	if err != nil {
+		if res.Status == TxStatusDropped || res.Status == TxStatusRejected {
+			isDropped, checkErr := isTxDropped(privateKey, txHash, state.TxInputs[txHash])
+			if checkErr != nil {
+				return errors.New(fmt.Sprintf("%v, but it may have been accepted (%v): rows left %v, check them manually", err, checkErr, PayoutRowSent))
+			}
+			if !isDropped {
+				return errors.New(fmt.Sprintf("%v, but it is still known to a node or its inputs are spent: rows left %v, check them manually", err, PayoutRowSent))
+			}
+
+			state.setStatus(rows, PayoutRowPending, "")
+			delete(state.TxInputs, txHash)
+			saveErr := state.Save(stateFile)
+			if saveErr != nil {
+				return saveErr
+			}
+		}
+		return err
+	}
+
+	state.setStatus(rows, PayoutRowPaid, txHash)
+	delete(state.TxInputs, txHash)
+	return state.Save(stateFile)
+}
@@ -13,15 +13,28 @@ import (
 )
 
 func CreatePDETradeTransaction(privateKey, tokenIDToSell, tokenIDToBuy string, amount uint64, version int8) ([]byte, string, error) {
+	quote, err := GetPDETradeQuote(tokenIDToSell, tokenIDToBuy, amount, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return CreatePDETradeTransactionWithQuote(privateKey, quote, version)
+}
+
+//CreatePDETradeTransactionWithQuote creates a PDE trade transaction with the minimum output and the trading fee of a quote.
+func CreatePDETradeTransactionWithQuote(privateKey string, quote *PDETradeQuote, version int8) ([]byte, string, error) {
+	tokenIDToSell, tokenIDToBuy, amount := quote.TokenIDToSell, quote.TokenIDToBuy, quote.SellAmount
+	minAccept, tradingFee := quote.MinAmount, quote.TradingFee
+	metaType := quote.tradeRequestMetaType()
 	if version == 2 {
-		return CreatePDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy, amount)
+		return createPDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy, amount, minAccept, tradingFee, metaType)
 	} else if version == 1{
-		return CreatePDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy, amount)
+		return createPDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy, amount, minAccept, tradingFee, metaType)
 	} else {//Try either one of the version, if possible
-		encodedTx, txHash, err := CreatePDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy, amount)
+		encodedTx, txHash, err := createPDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy, amount, minAccept, tradingFee, metaType)
 		if err != nil {
 			fmt.Println("CreatePDETradeTransactionVer1 error:", err)
-			encodedTx, txHash, err1 := CreatePDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy, amount)
+			encodedTx, txHash, err1 := createPDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy, amount, minAccept, tradingFee, metaType)
 			if err1 != nil {
 				return nil, "", errors.New(fmt.Sprintf("cannot create raw pdetradetransaction for either version: %v, %v", err, err1))
 			}
@@ -30,74 +43,64 @@ func CreatePDETradeTransaction(privateKey, tokenIDToSell, tokenIDToBuy string, a
 		return encodedTx, txHash, nil
 	}
 }
-func CreatePDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy string, amount uint64) ([]byte, string, error) {
+func CreatePDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy string, amount, minAccept, tradingFee uint64) ([]byte, string, error) {
+	return createPDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy, amount, minAccept, tradingFee, getPDETradeRequestMetaType(tokenIDToSell, tokenIDToBuy))
+}
+
+//createPDETradeTransactionVer1 creates a trade with the given metadata type: metadata.PDETradeRequestMeta for a trade in a single pool,
+//metadata.PDECrossPoolTradeRequestMeta for a trade of two tokens through their PRV pools.
+func createPDETradeTransactionVer1(privateKey, tokenIDToSell, tokenIDToBuy string, amount, minAccept, tradingFee uint64, metaType int) ([]byte, string, error) {
 	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
 	if err != nil {
 		return nil, "", err
 	}
 
-	minAccept := uint64(1)
-	//uncomment this code if you want to get the best price
-	minAccept, err = CheckPrice(tokenIDToSell, tokenIDToBuy, amount)
-	if err != nil {
-		return nil, "", err
-	}
 	addr := senderWallet.Base58CheckSerialize(wallet.PaymentAddressType)
 
-	var pdeTradeMetadata *metadata.PDETradeRequest
-	if tokenIDToSell == common.PRVIDStr || tokenIDToBuy == common.PRVIDStr {
-		pdeTradeMetadata, err = metadata.NewPDETradeRequest(tokenIDToBuy, tokenIDToSell, amount, minAccept, 0,
-			addr, "", metadata.PDETradeRequestMeta)
-	} else {
-		pdeTradeMetadata, err = metadata.NewPDETradeRequest(tokenIDToBuy, tokenIDToSell, amount, minAccept, 0,
-			addr, "", metadata.PDECrossPoolTradeRequestMeta)
-	}
+	pdeTradeMetadata, err := metadata.NewPDETradeRequest(tokenIDToBuy, tokenIDToSell, amount, minAccept, tradingFee,
+		addr, "", metaType)
 	if err != nil {
 		return nil, "", errors.New(fmt.Sprintf("cannot init trade request for %v to %v with amount %v: %v", tokenIDToSell, tokenIDToBuy, amount, err))
 	}
 
-	txParam := NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{amount}, tokenIDToSell, 1, pdeTradeMetadata)
+	txParam := newPDETradeTxParam(privateKey, tokenIDToSell, tokenIDToBuy, amount, tradingFee, pdeTradeMetadata)
 	if tokenIDToSell == common.PRVIDStr {
 		return CreateRawTransaction(txParam, 1)
 	} else {
-		//Trade token will use token to pay fee (in case of txtokenver1)
-		kvargs := make(map[string]interface{})
-		kvargs["hasTokenFee"] = true
-		txParam.SetKvargs(kvargs)
+		//Trade token will use token to pay fee (in case of txtokenver1), unless the trading fee is paid in PRV
+		if len(txParam.prvReceiverList) == 0 {
+			kvargs := make(map[string]interface{})
+			kvargs["hasTokenFee"] = true
+			txParam.SetKvargs(kvargs)
+		}
 		return CreateRawTokenTransaction(txParam, 1)
 	}
 }
-func CreatePDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy string, amount uint64) ([]byte, string, error) {
+func CreatePDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy string, amount, minAccept, tradingFee uint64) ([]byte, string, error) {
+	return createPDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy, amount, minAccept, tradingFee, getPDETradeRequestMetaType(tokenIDToSell, tokenIDToBuy))
+}
+
+//createPDETradeTransactionVer2 creates a trade with the given metadata type: metadata.PDETradeRequestMeta for a trade in a single pool,
+//metadata.PDECrossPoolTradeRequestMeta for a trade of two tokens through their PRV pools.
+func createPDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy string, amount, minAccept, tradingFee uint64, metaType int) ([]byte, string, error) {
 	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
 	if err != nil {
 		return nil, "", err
 	}
 
-	minAccept := uint64(1)
-	////uncomment this code if you want to get the best price
-	//minAccept, err = CheckPrice(tokenIDToSell, tokenIDToBuy, amount)
-	//if err != nil {
-	//	return nil, "", err
-	//}
 	addr := senderWallet.Base58CheckSerialize(wallet.PaymentAddressType)
 	pubKeyStr, txRandomStr, err := GenerateOTAFromPaymentAddress(addr)
 	if err != nil {
 		return nil, "", err
 	}
 
-	var pdeTradeMetadata *metadata.PDETradeRequest
-	if tokenIDToSell == common.PRVIDStr || tokenIDToBuy == common.PRVIDStr {
-		pdeTradeMetadata, err = metadata.NewPDETradeRequest(tokenIDToBuy, tokenIDToSell, amount, minAccept, 0,
-			pubKeyStr, txRandomStr, metadata.PDETradeRequestMeta)
-	} else {
-		pdeTradeMetadata, err = metadata.NewPDETradeRequest(tokenIDToBuy, tokenIDToSell, amount, minAccept, 0,
-			pubKeyStr, txRandomStr, metadata.PDECrossPoolTradeRequestMeta)
-	}
+	pdeTradeMetadata, err := metadata.NewPDETradeRequest(tokenIDToBuy, tokenIDToSell, amount, minAccept, tradingFee,
+		pubKeyStr, txRandomStr, metaType)
 	if err != nil {
 		return nil, "", errors.New(fmt.Sprintf("cannot init trade request for %v to %v with amount %v: %v", tokenIDToSell, tokenIDToBuy, amount, err))
 	}
 
-	txParam := NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{amount}, tokenIDToSell, 1, pdeTradeMetadata)
+	txParam := newPDETradeTxParam(privateKey, tokenIDToSell, tokenIDToBuy, amount, tradingFee, pdeTradeMetadata)
 	if tokenIDToSell == common.PRVIDStr {
 		return CreateRawTransaction(txParam, 2)
 	} else {
@@ -105,20 +108,47 @@ func CreatePDETradeTransactionVer2(privateKey, tokenIDToSell, tokenIDToBuy strin
 	}
 
 }
+
+//newPDETradeTxParam builds the burning outputs of a trade. The trading fee is burned together with the selling amount,
+//except for cross-pool trades of tokens where it is paid in PRV.
+func newPDETradeTxParam(privateKey, tokenIDToSell, tokenIDToBuy string, amount, tradingFee uint64, md metadata.Metadata) *TxParam {
+	isCrossPoolTokenTrade := md.GetType() == metadata.PDECrossPoolTradeRequestMeta
+	burnAmount := amount
+	if !isCrossPoolTokenTrade {
+		burnAmount += tradingFee
+	}
+
+	txParam := NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{burnAmount}, tokenIDToSell, 1, md)
+	if isCrossPoolTokenTrade && tradingFee > 0 {
+		txParam.SetPRVReceivers([]string{common.BurningAddress2}, []uint64{tradingFee})
+	}
+
+	return txParam
+}
 func CreateAndSendPDETradeTransaction(privateKey, tokenIDToSell, tokenIDToBuy string, amount uint64) (string, error) {
-	encodedTx, txHash, err := CreatePDETradeTransaction(privateKey, tokenIDToSell, tokenIDToBuy, amount, -1)
+	quote, err := GetPDETradeQuote(tokenIDToSell, tokenIDToBuy, amount, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return CreateAndSendPDETradeTransactionWithQuote(privateKey, quote, -1)
+}
+
+//CreateAndSendPDETradeTransactionWithQuote creates and sends a PDE trade transaction with the minimum output and the trading fee of a quote.
+func CreateAndSendPDETradeTransactionWithQuote(privateKey string, quote *PDETradeQuote, version int8) (string, error) {
+	encodedTx, txHash, err := CreatePDETradeTransactionWithQuote(privateKey, quote, version)
 	if err != nil {
 		return "", err
 	}
 
 	var responseInBytes []byte
-	if tokenIDToSell == common.PRVIDStr {
-		responseInBytes, err = rpc.SendRawTx(string(encodedTx))
+	if quote.TokenIDToSell == common.PRVIDStr {
+		responseInBytes, err = sendRawTx(encodedTx, txHash)
 		if err != nil {
 			return "", err
 		}
 	} else {
-		responseInBytes, err = rpc.SendRawTokenTx(string(encodedTx))
+		responseInBytes, err = sendRawTokenTx(encodedTx, txHash)
 		if err != nil {
 			return "", err
 		}
@@ -129,52 +159,7 @@ func CreateAndSendPDETradeTransaction(privateKey, tokenIDToSell, tokenIDToBuy st
 	if err != nil {
 		return "", err
 	}
-
-	//fmt.Printf("CreateAndSendPDETradeTransaction succeeded: %v\n", txHash)
-
-	////Checking if the tokenToBuy has been received
-	//start := time.Now()
-	//oldBalance, err := GetBalance(privateKey, tokenIDToSell)
-	//if err != nil {
-	//	return txHash, err
-	//}
-	//for {
-	//	isInBlock, err := CheckTxInBlock(txHash)
-	//	if err != nil {
-	//		return txHash, err
-	//	}
-	//	if !isInBlock {
-	//		if time.Since(start).Seconds() > 900 {
-	//			fmt.Println("Abort because of timeout")
-	//			return txHash, fmt.Errorf("abort because of timeout")
-	//		} else {
-	//			fmt.Println("sleep 10 seconds...")
-	//			time.Sleep(10 * time.Second)
-	//		}
-	//	} else {
-	//		fmt.Println("start checking balance tokenToBuy...")
-	//		for {
-	//			newBalance, err := GetBalance(privateKey, tokenIDToBuy)
-	//			if err != nil {
-	//				return txHash, err
-	//			}
-	//
-	//			if newBalance - oldBalance > 0 {
-	//				fmt.Printf("balance updated: %v\n", newBalance)
-	//				break
-	//			} else {
-	//				if time.Since(start).Seconds() > 900 {
-	//					fmt.Println("Abort because of timeout")
-	//					return txHash, fmt.Errorf("abort because of timeout")
-	//				} else {
-	//					fmt.Println("sleep 10 seconds for checking balance...")
-	//					time.Sleep(10 * time.Second)
-	//				}
-	//			}
-	//		}
-	//		break
-	//	}
-	//}
+	recordPDETradeQuote(txHash, quote)
 
 	return txHash, nil
 }
@@ -204,12 +189,12 @@ func CreateAndSendPDEContributeTransaction(privateKey, pairID, tokenID string, a
 
 	var responseInBytes []byte
 	if tokenID == common.PRVIDStr {
-		responseInBytes, err = rpc.SendRawTx(string(encodedTx))
+		responseInBytes, err = sendRawTx(encodedTx, txHash)
 		if err != nil {
 			return "", err
 		}
 	} else {
-		responseInBytes, err = rpc.SendRawTokenTx(string(encodedTx))
+		responseInBytes, err = sendRawTokenTx(encodedTx, txHash)
 		if err != nil {
 			return "", err
 		}
@@ -243,7 +228,7 @@ func CreateAndSendPDEWithdrawalTransaction(privateKey, tokenID1, tokenID2 string
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTx(string(encodedTx))
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
 	if err != nil {
 		return "", err
 	}
@@ -256,6 +241,96 @@ func CreateAndSendPDEWithdrawalTransaction(privateKey, tokenID1, tokenID2 string
 	return txHash, nil
 }
 
+//CreatePDEFeeWithdrawalTransaction creates a transaction withdrawing the trading fees accrued by a private key in a pool.
+//If feeAmount is 0, all the accrued fees are withdrawn.
+func CreatePDEFeeWithdrawalTransaction(privateKey, tokenID1, tokenID2 string, feeAmount uint64) ([]byte, string, error) {
+	position, err := GetLPPosition(privateKey, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	share := position.GetShare(tokenID1, tokenID2)
+	if share == nil || share.TradingFee == 0 {
+		return nil, "", errors.New(fmt.Sprintf("no trading fee to withdraw in pool %v - %v", tokenID1, tokenID2))
+	}
+	if feeAmount == 0 {
+		feeAmount = share.TradingFee
+	}
+	if feeAmount > share.TradingFee {
+		return nil, "", errors.New(fmt.Sprintf("withdrawal amount %v is greater than the accrued trading fee %v", feeAmount, share.TradingFee))
+	}
+
+	//The fee is recorded under the address used to contribute, which may be of an older format.
+	md, err := metadata.NewPDEFeeWithdrawalRequest(share.ContributorAddress, tokenID1, tokenID2, feeAmount, metadata.PDEFeeWithdrawalRequestMeta)
+	if err != nil {
+		return nil, "", err
+	}
+
+	txParam := NewTxParam(privateKey, []string{}, []uint64{}, common.PRVIDStr, 0, md)
+
+	return CreateRawTransaction(txParam, -1)
+}
+func CreateAndSendPDEFeeWithdrawalTransaction(privateKey, tokenID1, tokenID2 string, feeAmount uint64) (string, error) {
+	encodedTx, txHash, err := CreatePDEFeeWithdrawalTransaction(privateKey, tokenID1, tokenID2, feeAmount)
+	if err != nil {
+		return "", err
+	}
+
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return txHash, nil
+}
+
+//GetPDEFeeWithdrawalStatus returns the status of a trading fee withdrawal request (see common.PDEFeeWithdrawalAcceptedStatus).
+func GetPDEFeeWithdrawalStatus(txHash string) (int, error) {
+	responseInBytes, err := rpc.GetPDEFeeWithdrawalStatus(txHash)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	var status int
+	err = json.Unmarshal(response.Result, &status)
+	if err != nil {
+		return 0, err
+	}
+
+	return status, nil
+}
+
+//GetPDETradeStatus returns the status of a trade transaction: not found (0), accepted (1) or refunded (2).
+func GetPDETradeStatus(txHash string) (int, error) {
+	responseInBytes, err := rpc.CheckTradeStatus(txHash)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	var status int
+	err = json.Unmarshal(response.Result, &status)
+	if err != nil {
+		return 0, err
+	}
+
+	return status, nil
+}
+
 func GetCurrentPDEState(beaconHeight uint64) (*jsonresult.CurrentPDEState, error){
 	responseInBytes, err := rpc.GetPDEState(beaconHeight)
 	if err != nil {
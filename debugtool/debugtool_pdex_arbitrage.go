@@ -0,0 +1,393 @@
+package debugtool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/thanhn-inc/debugtool/common"
+)
+
+const (
+	DefaultMaxArbitrageHops = 4
+
+	//arbitrageMaxPoolShare bounds the amount searched for a cycle when no maximum is given: 10% of the first pool.
+	arbitrageMaxPoolShare = 10
+	arbitrageSearchSteps  = 64
+)
+
+//PDEArbitrage is a profitable cycle of trades starting and ending with the same token.
+type PDEArbitrage struct {
+	Route *PDERoute
+	//Profit is the amount of the starting token earned after transaction and trading fees.
+	Profit uint64
+	//ProfitPRV is Profit valued in PRV at the current price, used to compare cycles starting with different tokens.
+	ProfitPRV     uint64
+	ProfitPercent float64
+}
+
+func (arb PDEArbitrage) String() string {
+	return fmt.Sprintf("Cycle %v: sell %v, get %v, profit %v (%.4f%%, %v PRV) after %v PRV of fees, %v tx(s)",
+		strings.Join(arb.Route.Path, " -> "), arb.Route.SellAmount, arb.Route.ExpectedAmount, arb.Profit, arb.ProfitPercent,
+		arb.ProfitPRV, arb.Route.TotalPRVFee, len(arb.Route.Legs))
+}
+
+//Plan returns the trades of the cycle, one per transaction, in execution order.
+func (arb PDEArbitrage) Plan(options *PDETradeOptions) []*PDETradeQuote {
+	res := make([]*PDETradeQuote, 0)
+	for _, leg := range arb.Route.Legs {
+		res = append(res, legToQuote(leg, arb.Route.BeaconHeight, options))
+	}
+	return res
+}
+
+func legToQuote(leg PDERouteLeg, beaconHeight uint64, options *PDETradeOptions) *PDETradeQuote {
+	if options == nil {
+		options = DefaultPDETradeOptions()
+	}
+
+	quote := &PDETradeQuote{
+		TokenIDToSell:     leg.TokenIDToSell,
+		TokenIDToBuy:      leg.TokenIDToBuy,
+		SellAmount:        leg.SellAmount,
+		Route:             []string{leg.TokenIDToSell, leg.TokenIDToBuy},
+		BeaconHeight:      beaconHeight,
+		ExpectedAmount:    leg.ExpectedAmount,
+		SlippageBps:       options.SlippageBps,
+		TradingFee:        options.TradingFee,
+		TradingFeeTokenID: leg.TokenIDToSell,
+	}
+	if leg.IsCrossPool {
+		quote.Route = []string{leg.TokenIDToSell, common.PRVIDStr, leg.TokenIDToBuy}
+		quote.TradingFeeTokenID = common.PRVIDStr
+	}
+	quote.MinAmount = getMinAcceptableAmount(leg.ExpectedAmount, options)
+
+	return quote
+}
+
+//poolKeyOf identifies the pool of two tokens regardless of the trading direction.
+func poolKeyOf(tokenID1, tokenID2 string) string {
+	if tokenID1 > tokenID2 {
+		tokenID1, tokenID2 = tokenID2, tokenID1
+	}
+	return tokenID1 + "-" + tokenID2
+}
+
+//FindCycles returns every path of at most maxHops pools from startTokenID back to itself. A pool is used at most once in a path,
+//but a token (typically PRV) may be crossed several times.
+func (router *PDERouter) FindCycles(startTokenID string, maxHops int) [][]string {
+	if maxHops <= 0 {
+		maxHops = DefaultMaxArbitrageHops
+	}
+
+	res := make([][]string, 0)
+	usedPools := make(map[string]bool)
+	var search func(path []string)
+	search = func(path []string) {
+		current := path[len(path)-1]
+		if len(path) > 1 && current == startTokenID {
+			res = append(res, append([]string{}, path...))
+			return
+		}
+		if len(path)-1 >= maxHops {
+			return
+		}
+		for _, next := range router.neighbors[current] {
+			poolKey := poolKeyOf(current, next)
+			if usedPools[poolKey] {
+				continue
+			}
+			usedPools[poolKey] = true
+			search(append(path, next))
+			usedPools[poolKey] = false
+		}
+	}
+	search([]string{startTokenID})
+
+	return res
+}
+
+//evaluateCycle finds the selling amount maximizing the profit of a cycle, up to maxAmount.
+//It returns nil if the cycle is not profitable, or if its fees cannot be valued in the starting token.
+func (router *PDERouter) evaluateCycle(path []string, maxAmount, tradingFee uint64) *PDEArbitrage {
+	//EvaluateRoute leaves the fees out of NetAmount when they cannot be valued, which would look like a profit.
+	_, err := router.valuePRV(DefaultPRVFee+tradingFee, path[0])
+	if err != nil {
+		return nil
+	}
+
+	if maxAmount == 0 {
+		sellPoolAmount, _, err := getPoolAmounts(router.pools, router.BeaconHeight, path[0], path[1])
+		if err != nil {
+			return nil
+		}
+		maxAmount = sellPoolAmount / arbitrageMaxPoolShare
+	}
+
+	profitOf := func(amount uint64) (*PDERoute, int64) {
+		if amount == 0 {
+			return nil, 0
+		}
+		route, err := router.EvaluateRoute(path, amount, tradingFee)
+		if err != nil {
+			return nil, 0
+		}
+		return route, int64(route.NetAmount) - int64(amount)
+	}
+
+	//The output of a cycle is concave in the input amount, a ternary search finds the best size.
+	low, high := uint64(1), maxAmount
+	for i := 0; i < arbitrageSearchSteps && high-low > 2; i++ {
+		m1 := low + (high-low)/3
+		m2 := high - (high-low)/3
+		_, p1 := profitOf(m1)
+		_, p2 := profitOf(m2)
+		if p1 < p2 {
+			low = m1
+		} else {
+			high = m2
+		}
+	}
+
+	var bestRoute *PDERoute
+	bestProfit := int64(0)
+	for amount := low; amount <= high; amount++ {
+		route, profit := profitOf(amount)
+		if route != nil && profit > bestProfit {
+			bestRoute, bestProfit = route, profit
+		}
+	}
+	if bestRoute == nil {
+		return nil
+	}
+
+	arb := &PDEArbitrage{
+		Route:         bestRoute,
+		Profit:        uint64(bestProfit),
+		ProfitPercent: float64(bestProfit) / float64(bestRoute.SellAmount) * 100,
+	}
+	arb.ProfitPRV = arb.Profit
+	if path[0] != common.PRVIDStr {
+		prvPoolAmount, tokenPoolAmount, err := getPoolAmounts(router.pools, router.BeaconHeight, common.PRVIDStr, path[0])
+		if err != nil || tokenPoolAmount == 0 {
+			return nil
+		}
+		value := new(big.Int).Mul(new(big.Int).SetUint64(arb.Profit), new(big.Int).SetUint64(prvPoolAmount))
+		arb.ProfitPRV = value.Div(value, new(big.Int).SetUint64(tokenPoolAmount)).Uint64()
+	}
+
+	return arb
+}
+
+//ScanArbitrage searches profitable cycles of at most maxHops pools starting with one of startTokenIDs (every token if empty).
+//maxAmount caps the amount sold by a cycle (0 for 10% of the first pool); tradingFee is paid for each transaction.
+//
+//Opportunities are ranked by profit valued in PRV. When every token is scanned, a cycle is reported once, from the starting token giving the best profit.
+func (router *PDERouter) ScanArbitrage(startTokenIDs []string, maxHops int, maxAmount, tradingFee uint64) []*PDEArbitrage {
+	dedup := len(startTokenIDs) == 0
+	if dedup {
+		for tokenID := range router.neighbors {
+			startTokenIDs = append(startTokenIDs, tokenID)
+		}
+		sort.Strings(startTokenIDs)
+	}
+
+	best := make(map[string]*PDEArbitrage)
+	keys := make([]string, 0)
+	for _, tokenID := range startTokenIDs {
+		for _, path := range router.FindCycles(tokenID, maxHops) {
+			arb := router.evaluateCycle(path, maxAmount, tradingFee)
+			if arb == nil {
+				continue
+			}
+
+			key := strings.Join(path, "-")
+			if dedup {
+				key = cycleKey(path)
+			}
+			if prev, ok := best[key]; !ok {
+				keys = append(keys, key)
+			} else if prev.ProfitPRV >= arb.ProfitPRV {
+				continue
+			}
+			best[key] = arb
+		}
+	}
+
+	res := make([]*PDEArbitrage, 0)
+	for _, key := range keys {
+		res = append(res, best[key])
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].ProfitPRV != res[j].ProfitPRV {
+			return res[i].ProfitPRV > res[j].ProfitPRV
+		}
+		return res[i].ProfitPercent > res[j].ProfitPercent
+	})
+
+	return res
+}
+
+//cycleKey identifies a cycle regardless of its starting token: the rotation of the path which is the smallest in lexicographic order.
+func cycleKey(path []string) string {
+	tokens := path[:len(path)-1]
+	res := ""
+	for i := range tokens {
+		rotation := strings.Join(append(append([]string{}, tokens[i:]...), tokens[:i]...), "-")
+		if len(res) == 0 || rotation < res {
+			res = rotation
+		}
+	}
+	return res
+}
+
+//ScanPDEArbitrage searches profitable cycles over the pools at the latest beacon height. See PDERouter.ScanArbitrage.
+func ScanPDEArbitrage(startTokenIDs []string, maxHops int, maxAmount, tradingFee uint64) ([]*PDEArbitrage, error) {
+	router, err := newLatestPDERouter()
+	if err != nil {
+		return nil, err
+	}
+
+	return router.ScanArbitrage(startTokenIDs, maxHops, maxAmount, tradingFee), nil
+}
+
+//ExecutePDEArbitrage sends the trades of a cycle one after the other. Each trade sells what the previous one actually returned,
+//and is sent only after the previous trade is accepted and its coins have arrived.
+//
+//Before each trade, the rest of the cycle is evaluated again at the latest prices; the execution stops if it would no longer
+//return the amount sold plus every trading and transaction fee, valued in the starting token. The last trade requires at least
+//that amount.
+//
+//If a trade is refunded, the execution stops and the funds stay in the token of that trade. The results of the sent trades are returned in any case.
+func ExecutePDEArbitrage(ctx context.Context, privateKey string, arb *PDEArbitrage, options *PDETradeOptions, onProgress func(string)) ([]*PDETradeResult, error) {
+	if options == nil {
+		options = DefaultPDETradeOptions()
+	}
+	if onProgress == nil {
+		onProgress = func(string) {}
+	}
+
+	route := arb.Route
+	startTokenID := route.Path[0]
+	balance, err := GetBalance(privateKey, startTokenID)
+	if err != nil {
+		return nil, err
+	}
+	if balance < route.SellAmount {
+		return nil, errors.New(fmt.Sprintf("balance insufficient: need %v %v, have %v", route.SellAmount, startTokenID, balance))
+	}
+	prvBalance, err := GetBalance(privateKey, common.PRVIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if startTokenID == common.PRVIDStr {
+		prvBalance -= route.SellAmount
+	}
+	if prvBalance < route.TotalPRVFee {
+		return nil, errors.New(fmt.Sprintf("PRV balance insufficient for the fees: need %v, have %v", route.TotalPRVFee, prvBalance))
+	}
+
+	results := make([]*PDETradeResult, 0)
+	amount := route.SellAmount
+	var required uint64
+	for i := range route.Legs {
+		router, err := newLatestPDERouter()
+		if err != nil {
+			return results, err
+		}
+		if i == 0 {
+			feeValue, err := router.valuePRV(route.TotalPRVFee, startTokenID)
+			if err != nil {
+				return results, errors.New(fmt.Sprintf("cannot value the fees in %v: %v", startTokenID, err))
+			}
+			required = route.SellAmount + feeValue
+		}
+
+		remaining, err := router.EvaluateRoute(arbitrageRemainingPath(route, i), amount, options.TradingFee)
+		if err != nil {
+			return results, err
+		}
+		if remaining.ExpectedAmount < required {
+			return results, errors.New(fmt.Sprintf("cycle no longer profitable: trades %v to %v would return %v %v, need %v to cover the amount sold and the fees",
+				i+1, len(route.Legs), remaining.ExpectedAmount, startTokenID, required))
+		}
+
+		leg := remaining.Legs[0]
+		quote, err := quoteArbitrageLeg(router, leg, amount, options)
+		if err != nil {
+			return results, err
+		}
+		if i == len(route.Legs)-1 && quote.MinAmount < required {
+			quote.MinAmount = required
+		}
+
+		onProgress(fmt.Sprintf("trade %v/%v: sell %v %v for %v, expected %v, minimum %v", i+1, len(route.Legs),
+			amount, leg.TokenIDToSell, leg.TokenIDToBuy, quote.ExpectedAmount, quote.MinAmount))
+		txHash, err := CreateAndSendPDETradeTransactionWithQuote(privateKey, quote, -1)
+		if err != nil {
+			return results, err
+		}
+		onProgress(fmt.Sprintf("trade %v/%v sent: %v", i+1, len(route.Legs), txHash))
+
+		res, err := TrackPDETrade(ctx, privateKey, txHash, quote, 0, onProgress)
+		if res != nil {
+			results = append(results, res)
+		}
+		if err != nil {
+			return results, err
+		}
+		if res.Status != PDETradeAccepted {
+			return results, errors.New(fmt.Sprintf("trade %v refunded (%v), stopped holding %v", txHash, res.RefundReason, leg.TokenIDToSell))
+		}
+		amount = res.ReceiveAmount
+	}
+
+	if amount > route.SellAmount {
+		onProgress(fmt.Sprintf("cycle done: sold %v %v, got back %v (+%v before fees)", route.SellAmount, startTokenID, amount, amount-route.SellAmount))
+	} else {
+		onProgress(fmt.Sprintf("cycle done: sold %v %v, got back %v (-%v before fees)", route.SellAmount, startTokenID, amount, route.SellAmount-amount))
+	}
+
+	return results, nil
+}
+
+//arbitrageRemainingPath returns the tokens crossed by the legs of a cycle from the given leg to the end.
+func arbitrageRemainingPath(route *PDERoute, fromLeg int) []string {
+	res := []string{route.Legs[fromLeg].TokenIDToSell}
+	for _, leg := range route.Legs[fromLeg:] {
+		if leg.IsCrossPool {
+			res = append(res, common.PRVIDStr)
+		}
+		res = append(res, leg.TokenIDToBuy)
+	}
+	return res
+}
+
+func newLatestPDERouter() (*PDERouter, error) {
+	bestBlocks, err := GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPDERouter(bestBlocks[-1])
+}
+
+//quoteArbitrageLeg quotes a leg with the pools of router, through the same pools as the leg.
+func quoteArbitrageLeg(router *PDERouter, leg PDERouteLeg, amount uint64, options *PDETradeOptions) (*PDETradeQuote, error) {
+	path := []string{leg.TokenIDToSell, leg.TokenIDToBuy}
+	if leg.IsCrossPool {
+		path = []string{leg.TokenIDToSell, common.PRVIDStr, leg.TokenIDToBuy}
+	}
+	route, err := router.EvaluateRoute(path, amount, options.TradingFee)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := legToQuote(route.Legs[0], route.BeaconHeight, options)
+	quote.PriceImpact = route.PriceImpact
+	return quote, nil
+}
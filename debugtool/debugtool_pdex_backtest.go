@@ -0,0 +1,249 @@
+package debugtool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+)
+
+//PDESimOrder is a trade decided by a strategy.
+type PDESimOrder struct {
+	TokenIDToSell       string
+	TokenIDToBuy        string
+	SellAmount          uint64
+	MinAcceptableAmount uint64
+	TradingFee          uint64
+}
+
+//PDEStrategy decides the trades to make at each snapshot of a backtest.
+//The simulator must not be changed by the strategy; balances are the current holdings of the strategy.
+type PDEStrategy interface {
+	Name() string
+	Step(sim *PDESimulator, balances map[string]uint64) []PDESimOrder
+}
+
+//AutoTradeStrategy is the rule of AutoTrade: sell Amount of TokenToSell once the rate reaches ExpectedRate.
+//If Repeat is true, it trades at every snapshot where the rate is reached, as long as the balance allows it.
+type AutoTradeStrategy struct {
+	TokenToSell  string
+	TokenToBuy   string
+	Amount       uint64
+	ExpectedRate float64
+	SlippageBps  uint64
+	Repeat       bool
+
+	done bool
+}
+
+func (strategy *AutoTradeStrategy) Name() string {
+	return fmt.Sprintf("autotrade %v %v -> %v at rate %v", strategy.Amount, strategy.TokenToSell, strategy.TokenToBuy, strategy.ExpectedRate)
+}
+
+func (strategy *AutoTradeStrategy) Step(sim *PDESimulator, balances map[string]uint64) []PDESimOrder {
+	if strategy.done || balances[strategy.TokenToSell] < strategy.Amount {
+		return nil
+	}
+
+	res, err := sim.Copy().Trade(strategy.TokenToSell, strategy.TokenToBuy, strategy.Amount, 0)
+	if err != nil || res.Refunded {
+		return nil
+	}
+
+	rate := float64(res.ReceiveAmount) / float64(strategy.Amount)
+	if rate < strategy.ExpectedRate {
+		return nil
+	}
+
+	strategy.done = !strategy.Repeat
+	return []PDESimOrder{{
+		TokenIDToSell:       strategy.TokenToSell,
+		TokenIDToBuy:        strategy.TokenToBuy,
+		SellAmount:          strategy.Amount,
+		MinAcceptableAmount: getMinAcceptableAmount(res.ReceiveAmount, &PDETradeOptions{SlippageBps: strategy.SlippageBps}),
+	}}
+}
+
+//PDEBacktestTrade is a trade executed during a backtest.
+type PDEBacktestTrade struct {
+	BeaconHeight uint64
+	Time         int64
+	PDESimTradeResult
+}
+
+//PDEBacktestResult is the outcome of a strategy replayed over pDEX snapshots.
+type PDEBacktestResult struct {
+	Strategy        string
+	FromHeight      uint64
+	ToHeight        uint64
+	Snapshots       int
+	Trades          []*PDEBacktestTrade
+	Skipped         int //orders skipped for an insufficient balance
+	PRVFees         uint64
+	InitialBalances map[string]uint64
+	FinalBalances   map[string]uint64
+
+	//Values of the balances in ValueTokenID. HoldValue is the value of the initial balances at the last snapshot.
+	ValueTokenID string
+	InitialValue uint64
+	FinalValue   uint64
+	HoldValue    uint64
+	PnL          int64
+	PnLVsHold    int64
+}
+
+func (res PDEBacktestResult) String() string {
+	s := "========== BACKTEST RESULT ==========\n"
+	s += fmt.Sprintf("Strategy: %v\n", res.Strategy)
+	s += fmt.Sprintf("Beacon heights: %v - %v, %v snapshots\n", res.FromHeight, res.ToHeight, res.Snapshots)
+	for _, trade := range res.Trades {
+		s += fmt.Sprintf("\t%v (%v): %v\n", trade.BeaconHeight, time.Unix(trade.Time, 0).Format(time.RFC3339), trade.PDESimTradeResult)
+	}
+	s += fmt.Sprintf("Trades: %v, skipped: %v, PRV fees: %v\n", len(res.Trades), res.Skipped, res.PRVFees)
+	s += fmt.Sprintf("Initial balances: %v\n", formatBalances(res.InitialBalances))
+	s += fmt.Sprintf("Final balances: %v\n", formatBalances(res.FinalBalances))
+	s += fmt.Sprintf("Value (in %v): initial %v, final %v (fees included), hold %v\n", res.ValueTokenID, res.InitialValue, res.FinalValue, res.HoldValue)
+	s += fmt.Sprintf("PnL: %v, vs hold: %v\n", res.PnL, res.PnLVsHold)
+	s += "========== END BACKTEST RESULT =========="
+	return s
+}
+
+func formatBalances(balances map[string]uint64) string {
+	tokenIDs := make([]string, 0)
+	for tokenID := range balances {
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+	sort.Strings(tokenIDs)
+
+	res := make([]string, 0)
+	for _, tokenID := range tokenIDs {
+		res = append(res, fmt.Sprintf("%v %v", balances[tokenID], tokenID))
+	}
+	return strings.Join(res, ", ")
+}
+
+//valueOf returns the value of balances in tokenID at the spot prices of a snapshot. Tokens without a price are ignored.
+func (sim *PDESimulator) valueOf(balances map[string]uint64, tokenID string) uint64 {
+	value := 0.0
+	for balanceTokenID, amount := range balances {
+		if balanceTokenID == tokenID {
+			value += float64(amount)
+			continue
+		}
+		price, err := sim.GetPrice(balanceTokenID, tokenID)
+		if err != nil {
+			continue
+		}
+		value += float64(amount) * price
+	}
+	return uint64(math.Floor(value))
+}
+
+//LoadPDESimulators reads the pDEX state every step beacon blocks from fromHeight to toHeight (both included).
+func LoadPDESimulators(fromHeight, toHeight, step uint64, onProgress func(uint64)) ([]*PDESimulator, error) {
+	if fromHeight > toHeight {
+		return nil, errors.New(fmt.Sprintf("invalid height range %v - %v", fromHeight, toHeight))
+	}
+	if step == 0 {
+		step = 1
+	}
+
+	res := make([]*PDESimulator, 0)
+	for height := fromHeight; height <= toHeight; height += step {
+		if onProgress != nil {
+			onProgress(height)
+		}
+
+		sim, err := NewPDESimulatorFromRPC(height)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot get pDEX state at beacon height %v: %v", height, err))
+		}
+		res = append(res, sim)
+
+		if toHeight-height < step {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+//BacktestPDEStrategy replays a strategy over snapshots, in order. The orders of a snapshot are applied to it one after the other,
+//and do not affect the next snapshots. Each trade pays DefaultPRVFee, plus its trading fee if accepted (in PRV for cross-pool trades,
+//in the selling token otherwise); PRV fees are subtracted from the final value.
+func BacktestPDEStrategy(strategy PDEStrategy, snapshots []*PDESimulator, initialBalances map[string]uint64, valueTokenID string) (*PDEBacktestResult, error) {
+	if len(snapshots) == 0 {
+		return nil, errors.New("no snapshot to replay")
+	}
+	if len(valueTokenID) == 0 {
+		valueTokenID = common.PRVIDStr
+	}
+
+	balances := make(map[string]uint64)
+	for tokenID, amount := range initialBalances {
+		balances[tokenID] = amount
+	}
+
+	first, last := snapshots[0], snapshots[len(snapshots)-1]
+	res := &PDEBacktestResult{
+		Strategy:        strategy.Name(),
+		FromHeight:      first.BeaconHeight,
+		ToHeight:        last.BeaconHeight,
+		Snapshots:       len(snapshots),
+		Trades:          make([]*PDEBacktestTrade, 0),
+		InitialBalances: initialBalances,
+		FinalBalances:   balances,
+		ValueTokenID:    valueTokenID,
+	}
+
+	for _, snapshot := range snapshots {
+		sim := snapshot.Copy()
+		for _, order := range strategy.Step(sim, balances) {
+			isCrossPool := order.TokenIDToSell != common.PRVIDStr && order.TokenIDToBuy != common.PRVIDStr
+			required := order.SellAmount
+			if !isCrossPool {
+				required += order.TradingFee
+			}
+			if balances[order.TokenIDToSell] < required {
+				res.Skipped++
+				continue
+			}
+
+			tradeRes, err := sim.Trade(order.TokenIDToSell, order.TokenIDToBuy, order.SellAmount, order.MinAcceptableAmount)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("beacon height %v: %v", sim.BeaconHeight, err))
+			}
+
+			//A refunded trade gets its trading fee back, only the transaction fee is lost.
+			res.PRVFees += DefaultPRVFee
+			if !tradeRes.Refunded {
+				if isCrossPool {
+					res.PRVFees += order.TradingFee
+				} else {
+					balances[order.TokenIDToSell] -= order.TradingFee
+				}
+				balances[order.TokenIDToSell] -= order.SellAmount
+				balances[order.TokenIDToBuy] += tradeRes.ReceiveAmount
+			}
+			res.Trades = append(res.Trades, &PDEBacktestTrade{BeaconHeight: sim.BeaconHeight, Time: sim.State.BeaconTimeStamp, PDESimTradeResult: *tradeRes})
+		}
+	}
+
+	res.InitialValue = first.valueOf(initialBalances, valueTokenID)
+	res.HoldValue = last.valueOf(initialBalances, valueTokenID)
+	res.FinalValue = last.valueOf(balances, valueTokenID)
+	feeValue := last.valueOf(map[string]uint64{common.PRVIDStr: res.PRVFees}, valueTokenID)
+	if feeValue > res.FinalValue {
+		res.FinalValue = 0
+	} else {
+		res.FinalValue -= feeValue
+	}
+	res.PnL = int64(res.FinalValue) - int64(res.InitialValue)
+	res.PnLVsHold = int64(res.FinalValue) - int64(res.HoldValue)
+
+	return res, nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"strings"
 	"time"
 )
 
@@ -34,20 +35,31 @@ func ChooseBestStableCoinPool(tokenIDToSell string, sellAmount uint64) (string,
 		"3f89c75324b46f13c7b036871060e641d996a24c09b3065835cb1d38b799d6c1",
 	}
 
+	bestBlocks, err := GetBestBlock()
+	if err != nil {
+		return "", 0, err
+	}
+
+	router, err := NewPDERouter(bestBlocks[-1])
+	if err != nil {
+		return "", 0, err
+	}
+
 	tokenToTrade := tokenIDs[0]
 	maxValue := uint64(0)
 	for i, tokenID := range tokenIDs {
-		expectedTradeValue, err := CheckXPrice(tokenIDToSell, tokenID, sellAmount)
+		routes, err := router.FindRoutes(tokenIDToSell, tokenID, sellAmount, DefaultMaxRouteHops, 0)
 		if err != nil {
 			return "", 0, err
 		}
+		expectedTradeValue := routes[0].ExpectedAmount
 		if i <= 1{
 			expectedTradeValue = expectedTradeValue * 1000
 		}
 
 		rate := float64(expectedTradeValue) / float64(sellAmount)
 
-		fmt.Printf("trade %v %v ==> get %v %v ==> rate %v\n", sellAmount, tokenIDToSell, expectedTradeValue, tokenID, rate)
+		fmt.Printf("trade %v %v ==> get %v %v via %v ==> rate %v\n", sellAmount, tokenIDToSell, expectedTradeValue, tokenID, strings.Join(routes[0].Path, " -> "), rate)
 		if expectedTradeValue > maxValue {
 			maxValue = expectedTradeValue
 			tokenToTrade = tokenID
@@ -61,7 +73,7 @@ func ChooseBestStableCoinPool(tokenIDToSell string, sellAmount uint64) (string,
 func AutoTrade(privateKey, tokenToSell, tokenToBuy string, amount uint64, expectedRate float64) (string, error) {
 	balance, err := GetBalance(privateKey, tokenToSell)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	if balance < amount {
@@ -0,0 +1,268 @@
+package debugtool
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	//DepthPriceChange is the price move used to compute the liquidity depth of a pool.
+	DepthPriceChange = 0.02
+
+	PoolEventNone      = "none"
+	PoolEventTrade     = "trade"
+	PoolEventAdd       = "add"
+	PoolEventWithdraw  = "withdraw"
+	PoolEventCreated   = "created"
+	PoolEventRemoved   = "removed"
+	PoolEventUndefined = "unknown"
+)
+
+//PDEPoolSample is the state of a pool at a beacon height, with the changes since the previous sample of the same pool.
+type PDEPoolSample struct {
+	BeaconHeight    uint64
+	Time            int64
+	Token1ID        string
+	Token2ID        string
+	Token1PoolValue uint64
+	Token2PoolValue uint64
+	//Price is the amount of Token2 for one Token1.
+	Price float64
+	//Depth1 (resp. Depth2) is the amount of Token1 (resp. Token2) to sell to move the price by DepthPriceChange.
+	Depth1 uint64
+	Depth2 uint64
+
+	//Changes since the previous sample, inferred from the pool values only. Several operations between two samples are seen as one.
+	Delta1 int64
+	Delta2 int64
+	Event  string
+	//Volume1 (resp. Volume2) is the amount of Token1 (resp. Token2) sold to the pool.
+	Volume1 uint64
+	Volume2 uint64
+}
+
+//PDEPoolPair identifies a pool by its two tokens.
+type PDEPoolPair struct {
+	Token1ID string
+	Token2ID string
+}
+
+func newPDEPoolSample(beaconHeight uint64, time int64, token1ID, token2ID string, value1, value2 uint64) *PDEPoolSample {
+	sample := &PDEPoolSample{
+		BeaconHeight:    beaconHeight,
+		Time:            time,
+		Token1ID:        token1ID,
+		Token2ID:        token2ID,
+		Token1PoolValue: value1,
+		Token2PoolValue: value2,
+		Event:           PoolEventNone,
+	}
+	if value1 != 0 && value2 != 0 {
+		sample.Price = float64(value2) / float64(value1)
+		//For x*y = k, selling dx moves the price by a factor (x / (x + dx))^2.
+		factor := 1/math.Sqrt(1-DepthPriceChange) - 1
+		sample.Depth1 = uint64(float64(value1) * factor)
+		sample.Depth2 = uint64(float64(value2) * factor)
+	}
+
+	return sample
+}
+
+//setDelta infers what happened to the pool between the previous sample and this one.
+func (sample *PDEPoolSample) setDelta(prev *PDEPoolSample) {
+	if prev == nil {
+		return
+	}
+
+	sample.Delta1 = int64(sample.Token1PoolValue) - int64(prev.Token1PoolValue)
+	sample.Delta2 = int64(sample.Token2PoolValue) - int64(prev.Token2PoolValue)
+	switch {
+	case sample.Delta1 == 0 && sample.Delta2 == 0:
+		sample.Event = PoolEventNone
+	case prev.Token1PoolValue == 0 && prev.Token2PoolValue == 0:
+		sample.Event = PoolEventCreated
+	case sample.Token1PoolValue == 0 && sample.Token2PoolValue == 0:
+		sample.Event = PoolEventRemoved
+	case sample.Delta1 > 0 && sample.Delta2 < 0:
+		sample.Event = PoolEventTrade
+		sample.Volume1 = uint64(sample.Delta1)
+	case sample.Delta1 < 0 && sample.Delta2 > 0:
+		sample.Event = PoolEventTrade
+		sample.Volume2 = uint64(sample.Delta2)
+	case sample.Delta1 >= 0 && sample.Delta2 >= 0:
+		sample.Event = PoolEventAdd
+	case sample.Delta1 <= 0 && sample.Delta2 <= 0:
+		sample.Event = PoolEventWithdraw
+	default:
+		sample.Event = PoolEventUndefined
+	}
+}
+
+//SamplePDEPoolHistory reads the given pools every step beacon blocks from fromHeight to toHeight (both included).
+//If pairs is empty, every pool existing in one of the samples is recorded.
+//
+//Samples are grouped by pool, in increasing beacon height.
+func SamplePDEPoolHistory(pairs []PDEPoolPair, fromHeight, toHeight, step uint64, onProgress func(uint64)) ([]*PDEPoolSample, error) {
+	if fromHeight > toHeight {
+		return nil, errors.New(fmt.Sprintf("invalid height range %v - %v", fromHeight, toHeight))
+	}
+	if step == 0 {
+		step = 1
+	}
+
+	samplesByPool := make(map[string][]*PDEPoolSample)
+	poolOrder := make([]string, 0)
+	addSample := func(key string, sample *PDEPoolSample) {
+		samples, ok := samplesByPool[key]
+		if !ok {
+			poolOrder = append(poolOrder, key)
+		}
+		if len(samples) != 0 {
+			sample.setDelta(samples[len(samples)-1])
+		}
+		samplesByPool[key] = append(samples, sample)
+	}
+
+	for height := fromHeight; height <= toHeight; height += step {
+		if onProgress != nil {
+			onProgress(height)
+		}
+
+		pdeState, err := GetCurrentPDEState(height)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot get pDEX state at beacon height %v: %v", height, err))
+		}
+
+		if len(pairs) != 0 {
+			for _, pair := range pairs {
+				value1, value2, err := getPoolAmounts(pdeState.PDEPoolPairs, height, pair.Token1ID, pair.Token2ID)
+				if err != nil {
+					value1, value2 = 0, 0
+				}
+				addSample(pair.Token1ID+"-"+pair.Token2ID, newPDEPoolSample(height, pdeState.BeaconTimeStamp, pair.Token1ID, pair.Token2ID, value1, value2))
+			}
+		} else {
+			seen := make(map[string]bool)
+			for _, pool := range pdeState.PDEPoolPairs {
+				key := pool.Token1IDStr + "-" + pool.Token2IDStr
+				seen[key] = true
+				addSample(key, newPDEPoolSample(height, pdeState.BeaconTimeStamp, pool.Token1IDStr, pool.Token2IDStr, pool.Token1PoolValue, pool.Token2PoolValue))
+			}
+			//Pools which disappeared are recorded as empty.
+			for key, samples := range samplesByPool {
+				if !seen[key] {
+					last := samples[len(samples)-1]
+					addSample(key, newPDEPoolSample(height, pdeState.BeaconTimeStamp, last.Token1ID, last.Token2ID, 0, 0))
+				}
+			}
+		}
+
+		if toHeight-height < step {
+			break
+		}
+	}
+
+	sort.Strings(poolOrder)
+	res := make([]*PDEPoolSample, 0)
+	for _, key := range poolOrder {
+		res = append(res, samplesByPool[key]...)
+	}
+
+	return res, nil
+}
+
+var poolHistoryCSVHeader = []string{"BeaconHeight", "Time", "Token1ID", "Token2ID", "Token1PoolValue", "Token2PoolValue",
+	"Price", "Depth1", "Depth2", "Delta1", "Delta2", "Event", "Volume1", "Volume2"}
+
+//WritePDEPoolHistoryCSV writes samples as CSV, with a header line.
+func WritePDEPoolHistoryCSV(samples []*PDEPoolSample, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(poolHistoryCSVHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range samples {
+		err = writer.Write([]string{
+			fmt.Sprint(s.BeaconHeight), fmt.Sprint(s.Time), s.Token1ID, s.Token2ID, fmt.Sprint(s.Token1PoolValue), fmt.Sprint(s.Token2PoolValue),
+			fmt.Sprint(s.Price), fmt.Sprint(s.Depth1), fmt.Sprint(s.Depth2), fmt.Sprint(s.Delta1), fmt.Sprint(s.Delta2), s.Event,
+			fmt.Sprint(s.Volume1), fmt.Sprint(s.Volume2),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+//WritePDEPoolHistoryJSON writes samples as a JSON array.
+func WritePDEPoolHistoryJSON(samples []*PDEPoolSample, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(samples)
+}
+
+//ExportPDEPoolHistory writes samples to a file, as JSON if the file name ends with `.json`, as CSV otherwise.
+func ExportPDEPoolHistory(samples []*PDEPoolSample, fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(fileName)) == ".json" {
+		return WritePDEPoolHistoryJSON(samples, f)
+	}
+	return WritePDEPoolHistoryCSV(samples, f)
+}
+
+//SummarizePDEPoolHistory returns, for each pool, the first and last price and the total inferred volume.
+func SummarizePDEPoolHistory(samples []*PDEPoolSample) string {
+	type summary struct {
+		first, last      *PDEPoolSample
+		volume1, volume2 uint64
+		trades           int
+	}
+
+	summaries := make(map[string]*summary)
+	keys := make([]string, 0)
+	for _, s := range samples {
+		key := s.Token1ID + "-" + s.Token2ID
+		sum, ok := summaries[key]
+		if !ok {
+			sum = &summary{first: s}
+			summaries[key] = sum
+			keys = append(keys, key)
+		}
+		sum.last = s
+		sum.volume1 += s.Volume1
+		sum.volume2 += s.Volume2
+		if s.Event == PoolEventTrade {
+			sum.trades++
+		}
+	}
+
+	res := ""
+	for _, key := range keys {
+		sum := summaries[key]
+		change := 0.0
+		if sum.first.Price != 0 {
+			change = (sum.last.Price - sum.first.Price) / sum.first.Price * 100
+		}
+		res += fmt.Sprintf("%v - %v: price %v -> %v (%.4f%%), depth %v/%v, volume %v/%v, %v trade samples\n",
+			sum.first.Token1ID, sum.first.Token2ID, sum.first.Price, sum.last.Price, change,
+			sum.last.Depth1, sum.last.Depth2, sum.volume1, sum.volume2, sum.trades)
+	}
+
+	return strings.TrimSuffix(res, "\n")
+}
@@ -0,0 +1,215 @@
+package debugtool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+)
+
+const DefaultAddLiquidityTimeout = 20 * time.Minute
+
+var contributionStatusNames = map[byte]string{
+	common.PDENotFoundStatus:                     "not found",
+	common.PDEContributionWaitingStatus:          common.PDEContributionWaitingChainStatus,
+	common.PDEContributionAcceptedStatus:         common.PDEContributionMatchedChainStatus,
+	common.PDEContributionRefundStatus:           common.PDEContributionRefundChainStatus,
+	common.PDEContributionMatchedNReturnedStatus: common.PDEContributionMatchedNReturnedChainStatus,
+}
+
+//GetPDEContributionStatusName returns the name of a contribution status.
+func GetPDEContributionStatusName(status byte) string {
+	if name, ok := contributionStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%v)", status)
+}
+
+//AddLiquidityResult is the outcome of a paired contribution.
+type AddLiquidityResult struct {
+	PairID      string
+	TokenIDA    string
+	TokenIDB    string
+	AmountA     uint64
+	AmountB     uint64
+	TxHashA     string
+	TxHashB     string
+	Status      *jsonresult.PDEContributionStatus
+	ShareBefore *LPPoolShare //nil if the user had no share in the pool
+	ShareAfter  *LPPoolShare
+}
+
+func (res AddLiquidityResult) String() string {
+	s := "========== ADD LIQUIDITY RESULT ==========\n"
+	s += fmt.Sprintf("PairID: %v\n", res.PairID)
+	s += fmt.Sprintf("Sent: %v %v (tx %v), %v %v (tx %v)\n", res.AmountA, res.TokenIDA, res.TxHashA, res.AmountB, res.TokenIDB, res.TxHashB)
+	if res.Status != nil {
+		s += fmt.Sprintf("Status: %v\n", GetPDEContributionStatusName(res.Status.Status))
+		s += fmt.Sprintf("%v: contributed %v, returned %v\n", res.Status.TokenID1Str, res.Status.Contributed1Amount, res.Status.Returned1Amount)
+		s += fmt.Sprintf("%v: contributed %v, returned %v\n", res.Status.TokenID2Str, res.Status.Contributed2Amount, res.Status.Returned2Amount)
+	}
+	if res.ShareAfter != nil {
+		before := uint64(0)
+		if res.ShareBefore != nil {
+			before = res.ShareBefore.Share
+		}
+		s += fmt.Sprintf("Share: %v -> %v (%.6f%% of the pool)\n", before, res.ShareAfter.Share, res.ShareAfter.SharePercent)
+	}
+	s += "========== END ADD LIQUIDITY RESULT =========="
+	return s
+}
+
+//GetPDEContributionStatus returns the status of a contribution pair.
+func GetPDEContributionStatus(pairID string) (*jsonresult.PDEContributionStatus, error) {
+	responseInBytes, err := rpc.GetPDEContributionStatus(pairID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var status jsonresult.PDEContributionStatus
+	if len(response.Result) == 0 || string(response.Result) == "null" {
+		return &status, nil
+	}
+	err = json.Unmarshal(response.Result, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+//GetMatchingContributionAmount returns the amount of tokenIDB matching amountA of tokenIDA at the current pool ratio.
+//The result is rounded up so that amountA is fully contributed, the small excess of tokenIDB is returned by the pDEX.
+func GetMatchingContributionAmount(tokenIDA, tokenIDB string, amountA uint64) (uint64, error) {
+	bestBlocks, err := GetBestBlock()
+	if err != nil {
+		return 0, err
+	}
+
+	allPoolPairs, err := GetAllPDEPoolPairs(bestBlocks[-1])
+	if err != nil {
+		return 0, err
+	}
+
+	poolA, poolB, err := getPoolAmounts(allPoolPairs, bestBlocks[-1], tokenIDA, tokenIDB)
+	if err != nil {
+		return 0, err
+	}
+	if poolA == 0 || poolB == 0 {
+		return 0, errors.New(fmt.Sprintf("pool %v - %v is empty, the amount of both tokens must be given", tokenIDA, tokenIDB))
+	}
+
+	res := new(big.Int).Mul(new(big.Int).SetUint64(amountA), new(big.Int).SetUint64(poolB))
+	res.Add(res, new(big.Int).SetUint64(poolA-1))
+	res.Div(res, new(big.Int).SetUint64(poolA))
+	if !res.IsUint64() {
+		return 0, errors.New(fmt.Sprintf("matching amount of %v %v overflows", amountA, tokenIDA))
+	}
+
+	return res.Uint64(), nil
+}
+
+//NewPDEContributionPairID returns a random pair ID for a paired contribution.
+func NewPDEContributionPairID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("pair-%v-%v", time.Now().Unix(), hex.EncodeToString(b))
+}
+
+//AddLiquidity contributes amountA of tokenIDA and the matching amount of tokenIDB to their pool with a single pair ID.
+//If amountB is 0, it is computed from the current pool ratio.
+//
+//The second contribution is sent after the first one is in a block, so that both never spend the same PRV coins for fees.
+//The function then waits for the pDEX to match (or refund) the pair and reports the new share of the user.
+func AddLiquidity(ctx context.Context, privateKey, tokenIDA, tokenIDB string, amountA, amountB uint64, onProgress func(string)) (*AddLiquidityResult, error) {
+	if tokenIDA == tokenIDB {
+		return nil, errors.New("the two tokens must be different")
+	}
+	if onProgress == nil {
+		onProgress = func(string) {}
+	}
+
+	var err error
+	if amountB == 0 {
+		amountB, err = GetMatchingContributionAmount(tokenIDA, tokenIDB, amountA)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &AddLiquidityResult{
+		PairID:   NewPDEContributionPairID(),
+		TokenIDA: tokenIDA,
+		TokenIDB: tokenIDB,
+		AmountA:  amountA,
+		AmountB:  amountB,
+	}
+
+	position, err := GetLPPosition(privateKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	res.ShareBefore = position.GetShare(tokenIDA, tokenIDB)
+
+	onProgress(fmt.Sprintf("contributing %v %v and %v %v with pair ID %v", amountA, tokenIDA, amountB, tokenIDB, res.PairID))
+	res.TxHashA, err = CreateAndSendPDEContributeTransaction(privateKey, res.PairID, tokenIDA, amountA)
+	if err != nil {
+		return res, errors.New(fmt.Sprintf("cannot contribute %v: %v", tokenIDA, err))
+	}
+	onProgress(fmt.Sprintf("contribution of %v sent: %v", tokenIDA, res.TxHashA))
+
+	_, err = WaitForTx(ctx, res.TxHashA, 1, 0, func(update TxStatusUpdate) {
+		onProgress(update.String())
+	})
+	if err != nil {
+		return res, err
+	}
+
+	res.TxHashB, err = CreateAndSendPDEContributeTransaction(privateKey, res.PairID, tokenIDB, amountB)
+	if err != nil {
+		//The first contribution stays in WaitingPDEContributions; contributing tokenIDB with the same pair ID completes it.
+		return res, errors.New(fmt.Sprintf("cannot contribute %v, pair ID %v is waiting: %v", tokenIDB, res.PairID, err))
+	}
+	onProgress(fmt.Sprintf("contribution of %v sent: %v", tokenIDB, res.TxHashB))
+
+	for {
+		status, err := GetPDEContributionStatus(res.PairID)
+		if err != nil {
+			return res, err
+		}
+		res.Status = status
+
+		switch status.Status {
+		case common.PDEContributionAcceptedStatus, common.PDEContributionMatchedNReturnedStatus:
+			position, err = GetLPPosition(privateKey, 0)
+			if err != nil {
+				return res, err
+			}
+			res.ShareAfter = position.GetShare(tokenIDA, tokenIDB)
+			return res, nil
+		case common.PDEContributionRefundStatus:
+			return res, nil
+		}
+		onProgress(fmt.Sprintf("pair %v: %v", res.PairID, GetPDEContributionStatusName(status.Status)))
+
+		select {
+		case <-ctx.Done():
+			return res, errors.New(fmt.Sprintf("stop waiting for pair %v: %v", res.PairID, ctx.Err()))
+		case <-time.After(DefaultTrackerPollInterval):
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package debugtool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/metadata"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+//LPPoolShare is the share of a liquidity provider in a pool.
+type LPPoolShare struct {
+	//ContributorAddress is the address as recorded in the pDEX state; withdrawal requests must use this exact string.
+	ContributorAddress string
+	Token1ID           string
+	Token2ID           string
+	Share              uint64
+	TotalShares        uint64
+	SharePercent       float64
+	Token1Amount       uint64
+	Token2Amount       uint64
+	Token1PoolValue    uint64
+	Token2PoolValue    uint64
+	//TradingFee is the accrued trading fee of the contributor in this pool, withdrawn with a PDEFeeWithdrawalRequest.
+	TradingFee uint64
+}
+
+func (share LPPoolShare) String() string {
+	return fmt.Sprintf("%v - %v: share %v/%v (%.6f%%), amounts %v/%v, trading fee %v",
+		share.Token1ID, share.Token2ID, share.Share, share.TotalShares, share.SharePercent,
+		share.Token1Amount, share.Token2Amount, share.TradingFee)
+}
+
+//LPPosition is the list of pool shares of a payment address at a beacon height.
+type LPPosition struct {
+	PaymentAddress string
+	BeaconHeight   uint64
+	Shares         []*LPPoolShare
+}
+
+func (position LPPosition) String() string {
+	res := fmt.Sprintf("LP position of %v at beacon height %v: %v pools\n", position.PaymentAddress, position.BeaconHeight, len(position.Shares))
+	for _, share := range position.Shares {
+		res += fmt.Sprintf("\t%v\n", share)
+	}
+	return strings.TrimSuffix(res, "\n")
+}
+
+//GetShare returns the share of the position in a pool, tokens in any order.
+func (position LPPosition) GetShare(tokenID1, tokenID2 string) *LPPoolShare {
+	for _, share := range position.Shares {
+		if (share.Token1ID == tokenID1 && share.Token2ID == tokenID2) || (share.Token1ID == tokenID2 && share.Token2ID == tokenID1) {
+			return share
+		}
+	}
+	return nil
+}
+
+//LPPoolShareChange is the change of a pool share between two beacon heights.
+type LPPoolShareChange struct {
+	Token1ID        string
+	Token2ID        string
+	From            *LPPoolShare //nil if the share did not exist
+	To              *LPPoolShare //nil if the share does not exist anymore
+	ShareDelta      int64
+	Token1Delta     int64
+	Token2Delta     int64
+	TradingFeeDelta int64
+	//ImpermanentLoss compares (in Token2, at the final price) the final amounts with holding the initial amounts (percent).
+	ImpermanentLoss float64
+}
+
+func (change LPPoolShareChange) String() string {
+	return fmt.Sprintf("%v - %v: share %+d, amounts %+d/%+d, trading fee %+d, vs holding %.4f%%",
+		change.Token1ID, change.Token2ID, change.ShareDelta, change.Token1Delta, change.Token2Delta, change.TradingFeeDelta, change.ImpermanentLoss)
+}
+
+//getPaymentAddressFromKey returns the payment address of a private key or a payment address.
+func getPaymentAddressFromKey(keyStr string) (string, error) {
+	keyWallet, err := wallet.Base58CheckDeserialize(keyStr)
+	if err != nil {
+		return "", err
+	}
+	if len(keyWallet.KeySet.PrivateKey) != 0 {
+		err = keyWallet.KeySet.InitFromPrivateKey(&keyWallet.KeySet.PrivateKey)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(keyWallet.KeySet.PaymentAddress.Pk) == 0 {
+		return "", errors.New(fmt.Sprintf("%v is neither a private key nor a payment address", keyStr))
+	}
+
+	return keyWallet.Base58CheckSerialize(wallet.PaymentAddressType), nil
+}
+
+//isSameAddress compares a contributor address of the pDEX state with a payment address, regardless of the address version.
+func isSameAddress(addr1, addr2 string) bool {
+	isSame, _ := wallet.ComparePaymentAddresses(addr1, addr2)
+	return isSame
+}
+
+//GetLPPosition returns the pool shares of a private key or a payment address at a beacon height (0 for the latest one).
+func GetLPPosition(keyStr string, beaconHeight uint64) (*LPPosition, error) {
+	paymentAddress, err := getPaymentAddressFromKey(keyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if beaconHeight == 0 {
+		bestBlocks, err := GetBestBlock()
+		if err != nil {
+			return nil, err
+		}
+		beaconHeight = bestBlocks[-1]
+	}
+
+	pdeState, err := GetCurrentPDEState(beaconHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	return computeLPPosition(pdeState, paymentAddress, beaconHeight)
+}
+
+func computeLPPosition(pdeState *jsonresult.CurrentPDEState, paymentAddress string, beaconHeight uint64) (*LPPosition, error) {
+	totalShares := make(map[string]uint64)
+	shares := make(map[string]*LPPoolShare)
+	for key, amount := range pdeState.PDEShares {
+		_, tokenID1, tokenID2, addr, err := jsonresult.ParsePDEContributorKey(jsonresult.PDESharePrefix, key)
+		if err != nil {
+			return nil, err
+		}
+		pairKey := tokenID1 + "-" + tokenID2
+		totalShares[pairKey] += amount
+
+		if amount != 0 && isSameAddress(addr, paymentAddress) {
+			shares[pairKey] = &LPPoolShare{ContributorAddress: addr, Token1ID: tokenID1, Token2ID: tokenID2, Share: amount}
+		}
+	}
+
+	for key, amount := range pdeState.PDETradingFees {
+		_, tokenID1, tokenID2, addr, err := jsonresult.ParsePDEContributorKey(jsonresult.PDETradingFeePrefix, key)
+		if err != nil {
+			return nil, err
+		}
+		if amount == 0 || !isSameAddress(addr, paymentAddress) {
+			continue
+		}
+
+		pairKey := tokenID1 + "-" + tokenID2
+		share, ok := shares[pairKey]
+		if !ok {
+			//Fees can remain after all the shares have been withdrawn.
+			share = &LPPoolShare{ContributorAddress: addr, Token1ID: tokenID1, Token2ID: tokenID2}
+			shares[pairKey] = share
+		}
+		share.TradingFee += amount
+	}
+
+	res := &LPPosition{PaymentAddress: paymentAddress, BeaconHeight: beaconHeight, Shares: make([]*LPPoolShare, 0)}
+	for pairKey, share := range shares {
+		share.TotalShares = totalShares[pairKey]
+		if share.TotalShares != 0 {
+			share.SharePercent = float64(share.Share) / float64(share.TotalShares) * 100
+		}
+
+		value1, value2, err := getPoolAmounts(pdeState.PDEPoolPairs, beaconHeight, share.Token1ID, share.Token2ID)
+		if err == nil {
+			share.Token1PoolValue, share.Token2PoolValue = value1, value2
+			share.Token1Amount = shareOf(value1, share.Share, share.TotalShares)
+			share.Token2Amount = shareOf(value2, share.Share, share.TotalShares)
+		}
+		res.Shares = append(res.Shares, share)
+	}
+	sort.Slice(res.Shares, func(i, j int) bool {
+		if res.Shares[i].Token1ID != res.Shares[j].Token1ID {
+			return res.Shares[i].Token1ID < res.Shares[j].Token1ID
+		}
+		return res.Shares[i].Token2ID < res.Shares[j].Token2ID
+	})
+
+	return res, nil
+}
+
+//shareOf returns amount * share / totalShares.
+func shareOf(amount, share, totalShares uint64) uint64 {
+	if totalShares == 0 {
+		return 0
+	}
+	res := new(big.Int).Mul(new(big.Int).SetUint64(amount), new(big.Int).SetUint64(share))
+	res.Div(res, new(big.Int).SetUint64(totalShares))
+	return res.Uint64()
+}
+
+//GetLPPositionChanges compares the pool shares of a private key or a payment address between two beacon heights.
+func GetLPPositionChanges(keyStr string, fromHeight, toHeight uint64) ([]*LPPoolShareChange, error) {
+	fromPosition, err := GetLPPosition(keyStr, fromHeight)
+	if err != nil {
+		return nil, err
+	}
+	toPosition, err := GetLPPosition(keyStr, toHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]*LPPoolShareChange, 0)
+	for _, share := range fromPosition.Shares {
+		changes = append(changes, newLPPoolShareChange(share, toPosition.GetShare(share.Token1ID, share.Token2ID)))
+	}
+	for _, share := range toPosition.Shares {
+		if fromPosition.GetShare(share.Token1ID, share.Token2ID) == nil {
+			changes = append(changes, newLPPoolShareChange(nil, share))
+		}
+	}
+
+	return changes, nil
+}
+
+func newLPPoolShareChange(from, to *LPPoolShare) *LPPoolShareChange {
+	change := &LPPoolShareChange{From: from, To: to}
+	fromShare, toShare := &LPPoolShare{}, &LPPoolShare{}
+	if from != nil {
+		fromShare = from
+		change.Token1ID, change.Token2ID = from.Token1ID, from.Token2ID
+	}
+	if to != nil {
+		toShare = to
+		change.Token1ID, change.Token2ID = to.Token1ID, to.Token2ID
+	}
+
+	change.ShareDelta = int64(toShare.Share) - int64(fromShare.Share)
+	change.Token1Delta = int64(toShare.Token1Amount) - int64(fromShare.Token1Amount)
+	change.Token2Delta = int64(toShare.Token2Amount) - int64(fromShare.Token2Amount)
+	change.TradingFeeDelta = int64(toShare.TradingFee) - int64(fromShare.TradingFee)
+
+	//Only meaningful if the share did not change.
+	if from != nil && to != nil && from.Share == to.Share && to.Token1PoolValue != 0 {
+		price := float64(to.Token2PoolValue) / float64(to.Token1PoolValue)
+		holdValue := float64(from.Token1Amount)*price + float64(from.Token2Amount)
+		lpValue := float64(to.Token1Amount)*price + float64(to.Token2Amount)
+		if holdValue != 0 {
+			change.ImpermanentLoss = (lpValue - holdValue) / holdValue * 100
+		}
+	}
+
+	return change
+}
+
+//NewPDEWithdrawalRequestByPercent builds the request to withdraw a percentage (in basis points) of a pool share.
+func NewPDEWithdrawalRequestByPercent(share *LPPoolShare, percentBps uint64) (*metadata.PDEWithdrawalRequest, error) {
+	if percentBps == 0 || percentBps > MaxBasisPoint {
+		return nil, errors.New(fmt.Sprintf("invalid percentage %v, must be between 1 and %v basis points", percentBps, MaxBasisPoint))
+	}
+	if share.Share == 0 {
+		return nil, errors.New(fmt.Sprintf("no share in pool %v - %v", share.Token1ID, share.Token2ID))
+	}
+
+	shareAmount := shareOf(share.Share, percentBps, MaxBasisPoint)
+	if shareAmount == 0 {
+		return nil, errors.New(fmt.Sprintf("%v basis points of share %v is 0", percentBps, share.Share))
+	}
+
+	return metadata.NewPDEWithdrawalRequest(share.ContributorAddress, share.Token2ID, share.Token1ID, shareAmount, metadata.PDEWithdrawalRequestMeta)
+}
+
+//CreatePDEWithdrawalTransactionByPercent creates a transaction withdrawing a percentage (in basis points) of the share of
+//a private key in a pool, at the latest beacon height.
+func CreatePDEWithdrawalTransactionByPercent(privateKey, tokenID1, tokenID2 string, percentBps uint64) ([]byte, string, error) {
+	position, err := GetLPPosition(privateKey, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	share := position.GetShare(tokenID1, tokenID2)
+	if share == nil {
+		return nil, "", errors.New(fmt.Sprintf("no share in pool %v - %v", tokenID1, tokenID2))
+	}
+
+	md, err := NewPDEWithdrawalRequestByPercent(share, percentBps)
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Printf("Withdrawing %v/%v shares of pool %v - %v\n", md.WithdrawalShareAmt, share.Share, share.Token1ID, share.Token2ID)
+
+	txParam := NewTxParam(privateKey, []string{}, []uint64{}, common.PRVIDStr, 0, md)
+
+	return CreateRawTransaction(txParam, -1)
+}
+
+//CreateAndSendPDEWithdrawalTransactionByPercent creates and sends a transaction withdrawing a percentage (in basis points)
+//of the share of a private key in a pool.
+func CreateAndSendPDEWithdrawalTransactionByPercent(privateKey, tokenID1, tokenID2 string, percentBps uint64) (string, error) {
+	encodedTx, txHash, err := CreatePDEWithdrawalTransactionByPercent(privateKey, tokenID1, tokenID2, percentBps)
+	if err != nil {
+		return "", err
+	}
+
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return txHash, nil
+}
@@ -0,0 +1,184 @@
+package debugtool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/metadata"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+)
+
+const (
+	//DefaultPDETradeSlippage is the default slippage tolerance of a PDE trade (unit: basis point).
+	DefaultPDETradeSlippage = uint64(50)
+	MaxBasisPoint           = uint64(10000)
+)
+
+//PDETradeOptions are the optional parameters of a PDE trade.
+type PDETradeOptions struct {
+	//SlippageBps is the tolerated difference between the expected and the received amount (unit: basis point).
+	SlippageBps uint64
+	//MinAcceptableAmount, if not 0, overrides the minimum amount computed from the slippage tolerance.
+	MinAcceptableAmount uint64
+	//TradingFee is paid to the liquidity providers; trades with higher fees are processed first.
+	TradingFee uint64
+}
+
+//DefaultPDETradeOptions returns the options used when none is specified.
+func DefaultPDETradeOptions() *PDETradeOptions {
+	return &PDETradeOptions{SlippageBps: DefaultPDETradeSlippage}
+}
+
+//PDETradeQuote is the breakdown of a PDE trade computed from the current pool state.
+type PDETradeQuote struct {
+	TokenIDToSell     string
+	TokenIDToBuy      string
+	SellAmount        uint64
+	Route             []string
+	BeaconHeight      uint64
+	ExpectedAmount    uint64
+	MinAmount         uint64
+	SlippageBps       uint64
+	PriceImpact       float64 //percent
+	TradingFee        uint64
+	TradingFeeTokenID string
+}
+
+//IsCrossPool returns true if the trade goes through two pools (neither token is PRV).
+//A quote of two tokens routed through their direct pool (Route has two tokens) is not a cross-pool trade.
+func (quote PDETradeQuote) IsCrossPool() bool {
+	return quote.TokenIDToSell != common.PRVIDStr && quote.TokenIDToBuy != common.PRVIDStr && len(quote.Route) != 2
+}
+
+func (quote PDETradeQuote) tradeRequestMetaType() int {
+	if quote.IsCrossPool() {
+		return metadata.PDECrossPoolTradeRequestMeta
+	}
+	return metadata.PDETradeRequestMeta
+}
+
+//getPDETradeRequestMetaType returns the default metadata type of a trade: trades of two tokens go through their PRV pools.
+func getPDETradeRequestMetaType(tokenIDToSell, tokenIDToBuy string) int {
+	if tokenIDToSell == common.PRVIDStr || tokenIDToBuy == common.PRVIDStr {
+		return metadata.PDETradeRequestMeta
+	}
+	return metadata.PDECrossPoolTradeRequestMeta
+}
+
+func (quote PDETradeQuote) String() string {
+	res := "========== PDE TRADE QUOTE ==========\n"
+	res += fmt.Sprintf("Sell: %v %v\n", quote.SellAmount, quote.TokenIDToSell)
+	res += fmt.Sprintf("Buy: %v\n", quote.TokenIDToBuy)
+	res += fmt.Sprintf("Route: %v\n", strings.Join(quote.Route, " -> "))
+	res += fmt.Sprintf("Expected output: %v\n", quote.ExpectedAmount)
+	res += fmt.Sprintf("Minimum output: %v (slippage %.2f%%)\n", quote.MinAmount, float64(quote.SlippageBps)/100)
+	res += fmt.Sprintf("Price impact: %.4f%%\n", quote.PriceImpact)
+	res += fmt.Sprintf("Trading fee: %v %v\n", quote.TradingFee, quote.TradingFeeTokenID)
+	res += fmt.Sprintf("Beacon height: %v\n", quote.BeaconHeight)
+	res += "========== END PDE TRADE QUOTE =========="
+	return res
+}
+
+//GetPDETradeQuote computes the expected output, minimum output and price impact of a trade from the latest pool state.
+func GetPDETradeQuote(tokenIDToSell, tokenIDToBuy string, sellAmount uint64, options *PDETradeOptions) (*PDETradeQuote, error) {
+	if options == nil {
+		options = DefaultPDETradeOptions()
+	}
+	if tokenIDToSell == tokenIDToBuy {
+		return nil, errors.New(fmt.Sprintf("cannot trade %v to itself", tokenIDToSell))
+	}
+	if options.SlippageBps > MaxBasisPoint {
+		return nil, errors.New(fmt.Sprintf("invalid slippage %v, must be at most %v basis points", options.SlippageBps, MaxBasisPoint))
+	}
+
+	bestBlocks, err := GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+	bestBeaconHeight := bestBlocks[-1]
+
+	allPoolPairs, err := GetAllPDEPoolPairs(bestBeaconHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &PDETradeQuote{
+		TokenIDToSell:     tokenIDToSell,
+		TokenIDToBuy:      tokenIDToBuy,
+		SellAmount:        sellAmount,
+		BeaconHeight:      bestBeaconHeight,
+		SlippageBps:       options.SlippageBps,
+		TradingFee:        options.TradingFee,
+		TradingFeeTokenID: tokenIDToSell,
+	}
+	if quote.IsCrossPool() {
+		quote.Route = []string{tokenIDToSell, common.PRVIDStr, tokenIDToBuy}
+		quote.TradingFeeTokenID = common.PRVIDStr
+	} else {
+		quote.Route = []string{tokenIDToSell, tokenIDToBuy}
+	}
+
+	expectedAmount := sellAmount
+	spotAmount := new(big.Float).SetUint64(sellAmount)
+	for i := 0; i < len(quote.Route)-1; i++ {
+		sellPoolAmount, buyPoolAmount, err := getPoolAmounts(allPoolPairs, bestBeaconHeight, quote.Route[i], quote.Route[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		expectedAmount, err = UniswapValue(expectedAmount, sellPoolAmount, buyPoolAmount)
+		if err != nil {
+			return nil, err
+		}
+
+		spotAmount.Mul(spotAmount, new(big.Float).SetUint64(buyPoolAmount))
+		spotAmount.Quo(spotAmount, new(big.Float).SetUint64(sellPoolAmount))
+	}
+	quote.ExpectedAmount = expectedAmount
+
+	if spotAmount.Sign() > 0 {
+		ratio, _ := new(big.Float).Quo(new(big.Float).SetUint64(expectedAmount), spotAmount).Float64()
+		quote.PriceImpact = (1 - ratio) * 100
+	}
+
+	quote.MinAmount = getMinAcceptableAmount(expectedAmount, options)
+
+	return quote, nil
+}
+
+//getMinAcceptableAmount returns the minimum amount of a trade, either given by the options or computed from the slippage tolerance.
+func getMinAcceptableAmount(expectedAmount uint64, options *PDETradeOptions) uint64 {
+	res := options.MinAcceptableAmount
+	if res != 0 {
+		if res > expectedAmount {
+			fmt.Printf("WARNING: minimum output %v is greater than the expected output %v, the trade is likely to be refunded\n", res, expectedAmount)
+		}
+	} else {
+		minAmount := new(big.Int).SetUint64(expectedAmount)
+		minAmount.Mul(minAmount, new(big.Int).SetUint64(MaxBasisPoint-options.SlippageBps))
+		minAmount.Div(minAmount, new(big.Int).SetUint64(MaxBasisPoint))
+		res = minAmount.Uint64()
+	}
+	if res == 0 {
+		res = 1
+	}
+
+	return res
+}
+
+//getPoolAmounts returns the amounts of the selling token and the buying token in their pool.
+func getPoolAmounts(allPoolPairs map[string]*jsonresult.PDEPoolForPair, beaconHeight uint64, tokenIDToSell, tokenIDToBuy string) (uint64, uint64, error) {
+	poolKey := string(jsonresult.BuildPDEPoolForPairKey(beaconHeight, tokenIDToSell, tokenIDToBuy))
+	poolPair, ok := allPoolPairs[poolKey]
+	if !ok {
+		return 0, 0, fmt.Errorf("cannot found pool pair %v - %v", tokenIDToSell, tokenIDToBuy)
+	}
+
+	if poolPair.Token1IDStr == tokenIDToSell {
+		return poolPair.Token1PoolValue, poolPair.Token2PoolValue, nil
+	}
+	return poolPair.Token2PoolValue, poolPair.Token1PoolValue, nil
+}
@@ -0,0 +1,315 @@
+package debugtool
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+)
+
+const DefaultMaxRouteHops = 3
+
+//PDERouteHop is a trade through a single pool.
+type PDERouteHop struct {
+	TokenIDToSell  string
+	TokenIDToBuy   string
+	SellAmount     uint64
+	BuyAmount      uint64
+	SellPoolAmount uint64
+	BuyPoolAmount  uint64
+	PriceImpact    float64 //percent
+}
+
+//PDERouteLeg is a part of a route executable with a single trade transaction. A leg is either a single-pool trade,
+//or a cross-pool trade going through PRV (token -> PRV -> token).
+type PDERouteLeg struct {
+	TokenIDToSell  string
+	TokenIDToBuy   string
+	SellAmount     uint64
+	ExpectedAmount uint64
+	IsCrossPool    bool
+}
+
+//PDERoute is a path from a token to another through one or more pools.
+type PDERoute struct {
+	Path           []string
+	Hops           []PDERouteHop
+	Legs           []PDERouteLeg
+	BeaconHeight   uint64
+	SellAmount     uint64
+	ExpectedAmount uint64
+	PriceImpact    float64 //percent
+	//TotalPRVFee is the PRV spent on transaction fees and trading fees, one transaction per leg.
+	TotalPRVFee uint64
+	//NetAmount is ExpectedAmount minus TotalPRVFee valued in the buying token at the current price.
+	NetAmount uint64
+}
+
+func (route PDERoute) String() string {
+	res := fmt.Sprintf("Route %v: expected %v, net %v, price impact %.4f%%, %v tx(s), PRV fee %v\n",
+		strings.Join(route.Path, " -> "), route.ExpectedAmount, route.NetAmount, route.PriceImpact, len(route.Legs), route.TotalPRVFee)
+	for i, hop := range route.Hops {
+		res += fmt.Sprintf("\tHop %v: sell %v %v, get %v %v (pool %v/%v, impact %.4f%%)\n", i+1,
+			hop.SellAmount, hop.TokenIDToSell, hop.BuyAmount, hop.TokenIDToBuy, hop.SellPoolAmount, hop.BuyPoolAmount, hop.PriceImpact)
+	}
+	for i, leg := range route.Legs {
+		res += fmt.Sprintf("\tTx %v: %v -> %v, crossPool %v\n", i+1, leg.TokenIDToSell, leg.TokenIDToBuy, leg.IsCrossPool)
+	}
+	return strings.TrimSuffix(res, "\n")
+}
+
+//ToQuote converts a route executable with a single transaction into a trade quote, which can be sent with
+//CreateAndSendPDETradeTransactionWithQuote. Cross-pool legs are sent with the cross-pool trade metadata.
+func (route PDERoute) ToQuote(options *PDETradeOptions) (*PDETradeQuote, error) {
+	if options == nil {
+		options = DefaultPDETradeOptions()
+	}
+	if options.SlippageBps > MaxBasisPoint {
+		return nil, errors.New(fmt.Sprintf("invalid slippage %v, must be at most %v basis points", options.SlippageBps, MaxBasisPoint))
+	}
+	if len(route.Legs) != 1 {
+		return nil, errors.New(fmt.Sprintf("route %v needs %v transactions, only single-transaction routes can be quoted",
+			strings.Join(route.Path, " -> "), len(route.Legs)))
+	}
+
+	leg := route.Legs[0]
+	quote := &PDETradeQuote{
+		TokenIDToSell:     leg.TokenIDToSell,
+		TokenIDToBuy:      leg.TokenIDToBuy,
+		SellAmount:        leg.SellAmount,
+		Route:             route.Path,
+		BeaconHeight:      route.BeaconHeight,
+		ExpectedAmount:    leg.ExpectedAmount,
+		SlippageBps:       options.SlippageBps,
+		PriceImpact:       route.PriceImpact,
+		TradingFee:        options.TradingFee,
+		TradingFeeTokenID: leg.TokenIDToSell,
+	}
+	if leg.IsCrossPool {
+		quote.TradingFeeTokenID = common.PRVIDStr
+	}
+	quote.MinAmount = getMinAcceptableAmount(leg.ExpectedAmount, options)
+
+	return quote, nil
+}
+
+//PDERouter searches trading routes over a snapshot of all PDE pool pairs.
+type PDERouter struct {
+	BeaconHeight uint64
+	pools        map[string]*jsonresult.PDEPoolForPair
+	neighbors    map[string][]string
+}
+
+//NewPDERouter builds the pool graph at the given beacon height.
+func NewPDERouter(beaconHeight uint64) (*PDERouter, error) {
+	allPoolPairs, err := GetAllPDEPoolPairs(beaconHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPDERouterFromPools(beaconHeight, allPoolPairs), nil
+}
+
+//NewPDERouterFromPools builds the pool graph from a list of pool pairs keyed by jsonresult.BuildPDEPoolForPairKey.
+func NewPDERouterFromPools(beaconHeight uint64, allPoolPairs map[string]*jsonresult.PDEPoolForPair) *PDERouter {
+	router := &PDERouter{
+		BeaconHeight: beaconHeight,
+		pools:        allPoolPairs,
+		neighbors:    make(map[string][]string),
+	}
+	for _, pool := range allPoolPairs {
+		if pool.Token1PoolValue == 0 || pool.Token2PoolValue == 0 {
+			continue
+		}
+		router.neighbors[pool.Token1IDStr] = append(router.neighbors[pool.Token1IDStr], pool.Token2IDStr)
+		router.neighbors[pool.Token2IDStr] = append(router.neighbors[pool.Token2IDStr], pool.Token1IDStr)
+	}
+	for token := range router.neighbors {
+		sort.Strings(router.neighbors[token])
+	}
+
+	return router
+}
+
+//FindRoutes returns every route of at most maxHops pools from tokenIDToSell to tokenIDToBuy, the best one first.
+//tradingFee is the trading fee (in PRV) paid for each transaction of a route.
+func (router *PDERouter) FindRoutes(tokenIDToSell, tokenIDToBuy string, sellAmount uint64, maxHops int, tradingFee uint64) ([]*PDERoute, error) {
+	if tokenIDToSell == tokenIDToBuy {
+		return nil, errors.New(fmt.Sprintf("cannot trade %v to itself", tokenIDToSell))
+	}
+	if maxHops <= 0 {
+		maxHops = DefaultMaxRouteHops
+	}
+
+	routes := make([]*PDERoute, 0)
+	visited := map[string]bool{tokenIDToSell: true}
+	var search func(path []string)
+	search = func(path []string) {
+		current := path[len(path)-1]
+		if current == tokenIDToBuy {
+			route, err := router.EvaluateRoute(path, sellAmount, tradingFee)
+			if err == nil {
+				routes = append(routes, route)
+			}
+			return
+		}
+		if len(path)-1 >= maxHops {
+			return
+		}
+		for _, next := range router.neighbors[current] {
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			search(append(append([]string{}, path...), next))
+			visited[next] = false
+		}
+	}
+	search([]string{tokenIDToSell})
+
+	if len(routes) == 0 {
+		return nil, errors.New(fmt.Sprintf("no route found from %v to %v within %v hops", tokenIDToSell, tokenIDToBuy, maxHops))
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].NetAmount != routes[j].NetAmount {
+			return routes[i].NetAmount > routes[j].NetAmount
+		}
+		return len(routes[i].Hops) < len(routes[j].Hops)
+	})
+
+	return routes, nil
+}
+
+//EvaluateRoute computes the amounts of every hop of a path, and splits it into executable legs.
+func (router *PDERouter) EvaluateRoute(path []string, sellAmount uint64, tradingFee uint64) (*PDERoute, error) {
+	if len(path) < 2 {
+		return nil, errors.New("a route needs at least two tokens")
+	}
+
+	route := &PDERoute{Path: path, BeaconHeight: router.BeaconHeight, SellAmount: sellAmount}
+	amount := sellAmount
+	spotAmount := new(big.Float).SetUint64(sellAmount)
+	for i := 0; i < len(path)-1; i++ {
+		sellPoolAmount, buyPoolAmount, err := getPoolAmounts(router.pools, router.BeaconHeight, path[i], path[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		buyAmount, err := UniswapValue(amount, sellPoolAmount, buyPoolAmount)
+		if err != nil {
+			return nil, err
+		}
+
+		route.Hops = append(route.Hops, PDERouteHop{
+			TokenIDToSell:  path[i],
+			TokenIDToBuy:   path[i+1],
+			SellAmount:     amount,
+			BuyAmount:      buyAmount,
+			SellPoolAmount: sellPoolAmount,
+			BuyPoolAmount:  buyPoolAmount,
+			PriceImpact:    priceImpact(amount, buyAmount, sellPoolAmount, buyPoolAmount),
+		})
+
+		spotAmount.Mul(spotAmount, new(big.Float).SetUint64(buyPoolAmount))
+		spotAmount.Quo(spotAmount, new(big.Float).SetUint64(sellPoolAmount))
+		amount = buyAmount
+	}
+	route.ExpectedAmount = amount
+	if spotAmount.Sign() > 0 {
+		ratio, _ := new(big.Float).Quo(new(big.Float).SetUint64(amount), spotAmount).Float64()
+		route.PriceImpact = (1 - ratio) * 100
+	}
+
+	//Merge token -> PRV -> token hops into cross-pool legs.
+	for i := 0; i < len(route.Hops); i++ {
+		hop := route.Hops[i]
+		if i+1 < len(route.Hops) && hop.TokenIDToBuy == common.PRVIDStr && hop.TokenIDToSell != common.PRVIDStr {
+			nextHop := route.Hops[i+1]
+			route.Legs = append(route.Legs, PDERouteLeg{
+				TokenIDToSell:  hop.TokenIDToSell,
+				TokenIDToBuy:   nextHop.TokenIDToBuy,
+				SellAmount:     hop.SellAmount,
+				ExpectedAmount: nextHop.BuyAmount,
+				IsCrossPool:    true,
+			})
+			i++
+			continue
+		}
+		route.Legs = append(route.Legs, PDERouteLeg{
+			TokenIDToSell:  hop.TokenIDToSell,
+			TokenIDToBuy:   hop.TokenIDToBuy,
+			SellAmount:     hop.SellAmount,
+			ExpectedAmount: hop.BuyAmount,
+		})
+	}
+
+	route.TotalPRVFee = uint64(len(route.Legs)) * (DefaultPRVFee + tradingFee)
+	route.NetAmount = route.ExpectedAmount
+	feeValue, err := router.valuePRV(route.TotalPRVFee, path[len(path)-1])
+	if err == nil {
+		if feeValue >= route.NetAmount {
+			route.NetAmount = 0
+		} else {
+			route.NetAmount -= feeValue
+		}
+	}
+
+	return route, nil
+}
+
+//valuePRV converts an amount of PRV to a token at the current pool price.
+func (router *PDERouter) valuePRV(prvAmount uint64, tokenID string) (uint64, error) {
+	if tokenID == common.PRVIDStr {
+		return prvAmount, nil
+	}
+
+	prvPoolAmount, tokenPoolAmount, err := getPoolAmounts(router.pools, router.BeaconHeight, common.PRVIDStr, tokenID)
+	if err != nil {
+		return 0, err
+	}
+
+	res := new(big.Int).Mul(new(big.Int).SetUint64(prvAmount), new(big.Int).SetUint64(tokenPoolAmount))
+	res.Div(res, new(big.Int).SetUint64(prvPoolAmount))
+	return res.Uint64(), nil
+}
+
+func priceImpact(sellAmount, buyAmount, sellPoolAmount, buyPoolAmount uint64) float64 {
+	spot := new(big.Float).SetUint64(sellAmount)
+	spot.Mul(spot, new(big.Float).SetUint64(buyPoolAmount))
+	spot.Quo(spot, new(big.Float).SetUint64(sellPoolAmount))
+	if spot.Sign() <= 0 {
+		return 0
+	}
+	ratio, _ := new(big.Float).Quo(new(big.Float).SetUint64(buyAmount), spot).Float64()
+	return (1 - ratio) * 100
+}
+
+//FindBestPDERoute returns the route with the best output (after fees) from tokenIDToSell to tokenIDToBuy at the latest beacon height.
+func FindBestPDERoute(tokenIDToSell, tokenIDToBuy string, sellAmount uint64, maxHops int, tradingFee uint64) (*PDERoute, error) {
+	routes, err := FindPDERoutes(tokenIDToSell, tokenIDToBuy, sellAmount, maxHops, tradingFee)
+	if err != nil {
+		return nil, err
+	}
+
+	return routes[0], nil
+}
+
+//FindPDERoutes returns every route of at most maxHops pools at the latest beacon height, the best one first.
+func FindPDERoutes(tokenIDToSell, tokenIDToBuy string, sellAmount uint64, maxHops int, tradingFee uint64) ([]*PDERoute, error) {
+	bestBlocks, err := GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	router, err := NewPDERouter(bestBlocks[-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return router.FindRoutes(tokenIDToSell, tokenIDToBuy, sellAmount, maxHops, tradingFee)
+}
@@ -0,0 +1,380 @@
+package debugtool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+)
+
+//PDESimulator applies trades, contributions and withdrawals to an in-memory copy of the pDEX state,
+//with the same integer arithmetic as the beacon chain. Trading fees are not distributed to the liquidity providers.
+type PDESimulator struct {
+	BeaconHeight uint64
+	State        *jsonresult.CurrentPDEState
+}
+
+//NewPDESimulator creates a simulator over a copy of a pDEX state read at the given beacon height.
+func NewPDESimulator(beaconHeight uint64, state *jsonresult.CurrentPDEState) *PDESimulator {
+	stateCopy := &jsonresult.CurrentPDEState{
+		WaitingPDEContributions: make(map[string]*jsonresult.PDEContribution),
+		PDEPoolPairs:            make(map[string]*jsonresult.PDEPoolForPair),
+		PDEShares:               make(map[string]uint64),
+		PDETradingFees:          make(map[string]uint64),
+		BeaconTimeStamp:         state.BeaconTimeStamp,
+	}
+	for key, contribution := range state.WaitingPDEContributions {
+		tmp := *contribution
+		stateCopy.WaitingPDEContributions[key] = &tmp
+	}
+	for key, pool := range state.PDEPoolPairs {
+		tmp := *pool
+		stateCopy.PDEPoolPairs[key] = &tmp
+	}
+	for key, share := range state.PDEShares {
+		stateCopy.PDEShares[key] = share
+	}
+	for key, fee := range state.PDETradingFees {
+		stateCopy.PDETradingFees[key] = fee
+	}
+
+	return &PDESimulator{BeaconHeight: beaconHeight, State: stateCopy}
+}
+
+//NewPDESimulatorFromRPC creates a simulator over the pDEX state at a beacon height (0 for the latest one).
+func NewPDESimulatorFromRPC(beaconHeight uint64) (*PDESimulator, error) {
+	if beaconHeight == 0 {
+		bestBlocks, err := GetBestBlock()
+		if err != nil {
+			return nil, err
+		}
+		beaconHeight = bestBlocks[-1]
+	}
+
+	state, err := GetCurrentPDEState(beaconHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPDESimulator(beaconHeight, state), nil
+}
+
+//LoadPDESimulator creates a simulator over a pDEX state saved as JSON (the result of the RPC getpdestate).
+//The beacon height is read from the keys of the pools.
+func LoadPDESimulator(fileName string) (*PDESimulator, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var state jsonresult.CurrentPDEState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse pDEX state %v: %v", fileName, err))
+	}
+
+	beaconHeight, err := getPDEStateBeaconHeight(&state)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%v: %v", fileName, err))
+	}
+
+	return NewPDESimulator(beaconHeight, &state), nil
+}
+
+//NewPDESimulatorFromSource creates a simulator from a beacon height or, if source is not a number, from a JSON file.
+func NewPDESimulatorFromSource(source string) (*PDESimulator, error) {
+	beaconHeight, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return LoadPDESimulator(source)
+	}
+
+	return NewPDESimulatorFromRPC(beaconHeight)
+}
+
+func getPDEStateBeaconHeight(state *jsonresult.CurrentPDEState) (uint64, error) {
+	for key := range state.PDEPoolPairs {
+		parts := strings.SplitN(strings.TrimPrefix(key, string(jsonresult.PDEPoolPrefix)), "-", 2)
+		beaconHeight, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("invalid pool key %v", key))
+		}
+		return beaconHeight, nil
+	}
+
+	return 0, errors.New("no pool found in the pDEX state")
+}
+
+//Save writes the current state of the simulator as JSON, in the format read by LoadPDESimulator.
+func (sim *PDESimulator) Save(fileName string) error {
+	data, err := json.MarshalIndent(sim.State, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+//Copy returns an independent copy of the simulator.
+func (sim *PDESimulator) Copy() *PDESimulator {
+	return NewPDESimulator(sim.BeaconHeight, sim.State)
+}
+
+func (sim *PDESimulator) getPool(tokenID1, tokenID2 string) (*jsonresult.PDEPoolForPair, bool) {
+	pool, ok := sim.State.PDEPoolPairs[string(jsonresult.BuildPDEPoolForPairKey(sim.BeaconHeight, tokenID1, tokenID2))]
+	return pool, ok
+}
+
+//GetPoolAmounts returns the amounts of tokenID1 and tokenID2 in their pool.
+func (sim *PDESimulator) GetPoolAmounts(tokenID1, tokenID2 string) (uint64, uint64, error) {
+	return getPoolAmounts(sim.State.PDEPoolPairs, sim.BeaconHeight, tokenID1, tokenID2)
+}
+
+//GetPrice returns the amount of tokenIDToBuy received for one unit of tokenIDToSell at the spot price, through PRV for two tokens.
+func (sim *PDESimulator) GetPrice(tokenIDToSell, tokenIDToBuy string) (float64, error) {
+	route := []string{tokenIDToSell, tokenIDToBuy}
+	if tokenIDToSell != common.PRVIDStr && tokenIDToBuy != common.PRVIDStr {
+		route = []string{tokenIDToSell, common.PRVIDStr, tokenIDToBuy}
+	}
+
+	price := 1.0
+	for i := 0; i < len(route)-1; i++ {
+		sellPoolAmount, buyPoolAmount, err := sim.GetPoolAmounts(route[i], route[i+1])
+		if err != nil {
+			return 0, err
+		}
+		if sellPoolAmount == 0 {
+			return 0, errors.New(fmt.Sprintf("pool %v - %v is empty", route[i], route[i+1]))
+		}
+		price *= float64(buyPoolAmount) / float64(sellPoolAmount)
+	}
+
+	return price, nil
+}
+
+//PDESimTradeResult is the outcome of a simulated trade.
+type PDESimTradeResult struct {
+	TokenIDToSell string
+	TokenIDToBuy  string
+	SellAmount    uint64
+	ReceiveAmount uint64
+	PriceImpact   float64 //percent
+	Refunded      bool
+}
+
+func (res PDESimTradeResult) String() string {
+	if res.Refunded {
+		return fmt.Sprintf("sell %v %v for %v: refunded", res.SellAmount, res.TokenIDToSell, res.TokenIDToBuy)
+	}
+	return fmt.Sprintf("sell %v %v, get %v %v, price impact %.4f%%", res.SellAmount, res.TokenIDToSell, res.ReceiveAmount, res.TokenIDToBuy, res.PriceImpact)
+}
+
+//Trade applies a trade to the pools. Two tokens are traded through PRV in one cross-pool trade, as the chain does.
+//If the output is lower than minAcceptableAmount, the trade is refunded and the pools are unchanged.
+func (sim *PDESimulator) Trade(tokenIDToSell, tokenIDToBuy string, sellAmount, minAcceptableAmount uint64) (*PDESimTradeResult, error) {
+	if tokenIDToSell == tokenIDToBuy {
+		return nil, errors.New(fmt.Sprintf("cannot trade %v to itself", tokenIDToSell))
+	}
+
+	route := []string{tokenIDToSell, tokenIDToBuy}
+	if tokenIDToSell != common.PRVIDStr && tokenIDToBuy != common.PRVIDStr {
+		route = []string{tokenIDToSell, common.PRVIDStr, tokenIDToBuy}
+	}
+
+	return sim.tradeRoute(route, sellAmount, minAcceptableAmount)
+}
+
+//TradeDirect applies a trade through the direct pool of two tokens (metadata.PDETradeRequestMeta).
+func (sim *PDESimulator) TradeDirect(tokenIDToSell, tokenIDToBuy string, sellAmount, minAcceptableAmount uint64) (*PDESimTradeResult, error) {
+	if tokenIDToSell == tokenIDToBuy {
+		return nil, errors.New(fmt.Sprintf("cannot trade %v to itself", tokenIDToSell))
+	}
+
+	return sim.tradeRoute([]string{tokenIDToSell, tokenIDToBuy}, sellAmount, minAcceptableAmount)
+}
+
+func (sim *PDESimulator) tradeRoute(route []string, sellAmount, minAcceptableAmount uint64) (*PDESimTradeResult, error) {
+	res := &PDESimTradeResult{TokenIDToSell: route[0], TokenIDToBuy: route[len(route)-1], SellAmount: sellAmount}
+
+	type poolUpdate struct {
+		pool               *jsonresult.PDEPoolForPair
+		sellTokenID        string
+		sellAmount, amount uint64
+	}
+	updates := make([]poolUpdate, 0)
+	amount := sellAmount
+	spotAmount := new(big.Float).SetUint64(sellAmount)
+	for i := 0; i < len(route)-1; i++ {
+		pool, ok := sim.getPool(route[i], route[i+1])
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("cannot found pool pair %v - %v", route[i], route[i+1]))
+		}
+		sellPoolAmount, buyPoolAmount, _ := sim.GetPoolAmounts(route[i], route[i+1])
+		if sellPoolAmount == 0 || buyPoolAmount == 0 {
+			res.Refunded = true
+			return res, nil
+		}
+
+		buyAmount, err := UniswapValue(amount, sellPoolAmount, buyPoolAmount)
+		if err != nil {
+			res.Refunded = true
+			return res, nil
+		}
+		updates = append(updates, poolUpdate{pool: pool, sellTokenID: route[i], sellAmount: amount, amount: buyAmount})
+
+		spotAmount.Mul(spotAmount, new(big.Float).SetUint64(buyPoolAmount))
+		spotAmount.Quo(spotAmount, new(big.Float).SetUint64(sellPoolAmount))
+		amount = buyAmount
+	}
+	if amount < minAcceptableAmount {
+		res.Refunded = true
+		return res, nil
+	}
+
+	for _, update := range updates {
+		if update.pool.Token1IDStr == update.sellTokenID {
+			update.pool.Token1PoolValue += update.sellAmount
+			update.pool.Token2PoolValue -= update.amount
+		} else {
+			update.pool.Token2PoolValue += update.sellAmount
+			update.pool.Token1PoolValue -= update.amount
+		}
+	}
+
+	res.ReceiveAmount = amount
+	if spotAmount.Sign() > 0 {
+		ratio, _ := new(big.Float).Quo(new(big.Float).SetUint64(amount), spotAmount).Float64()
+		res.PriceImpact = (1 - ratio) * 100
+	}
+
+	return res, nil
+}
+
+//getTotalShares returns the total shares of a pool.
+func (sim *PDESimulator) getTotalShares(tokenID1, tokenID2 string) uint64 {
+	prefix := string(jsonresult.BuildPDESharesKeyV2(sim.BeaconHeight, tokenID1, tokenID2, ""))
+	total := uint64(0)
+	for key, share := range sim.State.PDEShares {
+		if strings.HasPrefix(key, prefix) {
+			total += share
+		}
+	}
+	return total
+}
+
+//GetShare returns the share of a contributor in a pool and the total shares of the pool.
+func (sim *PDESimulator) GetShare(tokenID1, tokenID2, contributorAddress string) (uint64, uint64) {
+	key := string(jsonresult.BuildPDESharesKeyV2(sim.BeaconHeight, tokenID1, tokenID2, contributorAddress))
+	return sim.State.PDEShares[key], sim.getTotalShares(tokenID1, tokenID2)
+}
+
+//PDESimContributionResult is the outcome of a simulated paired contribution.
+type PDESimContributionResult struct {
+	ContributedAmount1 uint64
+	ReturnedAmount1    uint64
+	ContributedAmount2 uint64
+	ReturnedAmount2    uint64
+	AddedShare         uint64
+}
+
+func (res PDESimContributionResult) String() string {
+	return fmt.Sprintf("contributed %v and %v, returned %v and %v, share +%v",
+		res.ContributedAmount1, res.ContributedAmount2, res.ReturnedAmount1, res.ReturnedAmount2, res.AddedShare)
+}
+
+//Contribute applies a matched contribution of amount1 of tokenID1 and amount2 of tokenID2.
+//As on the chain, the amounts are matched to the pool ratio and the excess of one token is returned;
+//the share is computed from the amount of tokenID1 before the pool is updated.
+func (sim *PDESimulator) Contribute(tokenID1, tokenID2 string, amount1, amount2 uint64, contributorAddress string) (*PDESimContributionResult, error) {
+	if tokenID1 == tokenID2 {
+		return nil, errors.New("the two tokens must be different")
+	}
+
+	res := &PDESimContributionResult{ContributedAmount1: amount1, ContributedAmount2: amount2}
+	pool, ok := sim.getPool(tokenID1, tokenID2)
+	if ok && pool.Token1PoolValue != 0 && pool.Token2PoolValue != 0 {
+		poolAmount1, poolAmount2, _ := sim.GetPoolAmounts(tokenID1, tokenID2)
+		expectedAmount2 := new(big.Int).Mul(new(big.Int).SetUint64(amount1), new(big.Int).SetUint64(poolAmount2))
+		expectedAmount2.Div(expectedAmount2, new(big.Int).SetUint64(poolAmount1))
+		if expectedAmount2.Cmp(new(big.Int).SetUint64(amount2)) > 0 {
+			expectedAmount1 := new(big.Int).Mul(new(big.Int).SetUint64(amount2), new(big.Int).SetUint64(poolAmount1))
+			expectedAmount1.Div(expectedAmount1, new(big.Int).SetUint64(poolAmount2))
+			res.ContributedAmount1 = expectedAmount1.Uint64()
+			res.ReturnedAmount1 = amount1 - res.ContributedAmount1
+		} else {
+			res.ContributedAmount2 = expectedAmount2.Uint64()
+			res.ReturnedAmount2 = amount2 - res.ContributedAmount2
+		}
+	}
+
+	totalShares := sim.getTotalShares(tokenID1, tokenID2)
+	if totalShares == 0 || !ok {
+		res.AddedShare = res.ContributedAmount1
+	} else {
+		poolAmount1, _, _ := sim.GetPoolAmounts(tokenID1, tokenID2)
+		if poolAmount1 == 0 {
+			res.AddedShare = res.ContributedAmount1
+		} else {
+			addedShare := new(big.Int).Mul(new(big.Int).SetUint64(totalShares), new(big.Int).SetUint64(res.ContributedAmount1))
+			addedShare.Div(addedShare, new(big.Int).SetUint64(poolAmount1))
+			res.AddedShare = addedShare.Uint64()
+		}
+	}
+
+	if !ok {
+		pool = &jsonresult.PDEPoolForPair{Token1IDStr: tokenID1, Token2IDStr: tokenID2}
+		if tokenID1 > tokenID2 {
+			pool.Token1IDStr, pool.Token2IDStr = tokenID2, tokenID1
+		}
+		sim.State.PDEPoolPairs[string(jsonresult.BuildPDEPoolForPairKey(sim.BeaconHeight, tokenID1, tokenID2))] = pool
+	}
+	if pool.Token1IDStr == tokenID1 {
+		pool.Token1PoolValue += res.ContributedAmount1
+		pool.Token2PoolValue += res.ContributedAmount2
+	} else {
+		pool.Token1PoolValue += res.ContributedAmount2
+		pool.Token2PoolValue += res.ContributedAmount1
+	}
+	sim.State.PDEShares[string(jsonresult.BuildPDESharesKeyV2(sim.BeaconHeight, tokenID1, tokenID2, contributorAddress))] += res.AddedShare
+
+	return res, nil
+}
+
+//Withdraw applies the withdrawal of a share of a pool, and returns the amounts of tokenID1 and tokenID2 withdrawn.
+func (sim *PDESimulator) Withdraw(tokenID1, tokenID2, contributorAddress string, share uint64) (uint64, uint64, error) {
+	currentShare, totalShares := sim.GetShare(tokenID1, tokenID2, contributorAddress)
+	if currentShare == 0 || totalShares == 0 {
+		return 0, 0, errors.New(fmt.Sprintf("%v has no share in pool %v - %v", contributorAddress, tokenID1, tokenID2))
+	}
+	if share > currentShare {
+		share = currentShare
+	}
+
+	pool, _ := sim.getPool(tokenID1, tokenID2)
+	poolAmount1, poolAmount2, err := sim.GetPoolAmounts(tokenID1, tokenID2)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	withdrawAmount := func(poolAmount uint64) uint64 {
+		res := new(big.Int).Mul(new(big.Int).SetUint64(poolAmount), new(big.Int).SetUint64(share))
+		return res.Div(res, new(big.Int).SetUint64(totalShares)).Uint64()
+	}
+	amount1, amount2 := withdrawAmount(poolAmount1), withdrawAmount(poolAmount2)
+
+	if pool.Token1IDStr == tokenID1 {
+		pool.Token1PoolValue -= amount1
+		pool.Token2PoolValue -= amount2
+	} else {
+		pool.Token1PoolValue -= amount2
+		pool.Token2PoolValue -= amount1
+	}
+	sim.State.PDEShares[string(jsonresult.BuildPDESharesKeyV2(sim.BeaconHeight, tokenID1, tokenID2, contributorAddress))] = currentShare - share
+
+	return amount1, amount2, nil
+}
@@ -0,0 +1,257 @@
+package debugtool
+
+import (
+	"testing"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+)
+
+const (
+	testTokenA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	testTokenB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+	testBeaconHeight = 10
+)
+
+//newTestPDESimulator returns a simulator with the pools PRV/A = 1000/2000 and PRV/B = 1000/500.
+//The shares of PRV/A are 1 for alice and 2 for bob.
+func newTestPDESimulator() *PDESimulator {
+	state := &jsonresult.CurrentPDEState{
+		PDEPoolPairs: map[string]*jsonresult.PDEPoolForPair{
+			string(jsonresult.BuildPDEPoolForPairKey(testBeaconHeight, common.PRVIDStr, testTokenA)): {
+				Token1IDStr: common.PRVIDStr, Token1PoolValue: 1000, Token2IDStr: testTokenA, Token2PoolValue: 2000,
+			},
+			string(jsonresult.BuildPDEPoolForPairKey(testBeaconHeight, common.PRVIDStr, testTokenB)): {
+				Token1IDStr: common.PRVIDStr, Token1PoolValue: 1000, Token2IDStr: testTokenB, Token2PoolValue: 500,
+			},
+		},
+		PDEShares: map[string]uint64{
+			string(jsonresult.BuildPDESharesKeyV2(testBeaconHeight, common.PRVIDStr, testTokenA, "alice")): 1,
+			string(jsonresult.BuildPDESharesKeyV2(testBeaconHeight, common.PRVIDStr, testTokenA, "bob")):   2,
+		},
+	}
+	return NewPDESimulator(testBeaconHeight, state)
+}
+
+func checkPool(t *testing.T, sim *PDESimulator, tokenID1, tokenID2 string, want1, want2 uint64) {
+	t.Helper()
+	amount1, amount2, err := sim.GetPoolAmounts(tokenID1, tokenID2)
+	if err != nil {
+		t.Fatalf("GetPoolAmounts(%v, %v): %v", tokenID1, tokenID2, err)
+	}
+	if amount1 != want1 || amount2 != want2 {
+		t.Fatalf("pool %v/%v = %v/%v, want %v/%v", tokenID1, tokenID2, amount1, amount2, want1, want2)
+	}
+}
+
+func TestPDESimulatorTrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		tokenToSell string
+		tokenToBuy  string
+		sellAmount  uint64
+		minAmount   uint64
+		wantReceive uint64
+		wantRefund  bool
+		//pool amounts after the trade: PRV/A, then PRV/B
+		wantPoolA [2]uint64
+		wantPoolB [2]uint64
+	}{
+		{
+			//2000000 / 1100 = 1818.18, rounded up to 1819
+			name:        "prv to token",
+			tokenToSell: common.PRVIDStr, tokenToBuy: testTokenA, sellAmount: 100,
+			wantReceive: 181,
+			wantPoolA:   [2]uint64{1100, 1819},
+			wantPoolB:   [2]uint64{1000, 500},
+		},
+		{
+			//2000000 / 3000 = 666.67, rounded up to 667
+			name:        "token to prv",
+			tokenToSell: testTokenA, tokenToBuy: common.PRVIDStr, sellAmount: 1000,
+			wantReceive: 333,
+			wantPoolA:   [2]uint64{667, 3000},
+			wantPoolB:   [2]uint64{1000, 500},
+		},
+		{
+			name:        "nothing to buy",
+			tokenToSell: testTokenA, tokenToBuy: common.PRVIDStr, sellAmount: 0,
+			wantRefund: true,
+			wantPoolA:  [2]uint64{1000, 2000},
+			wantPoolB:  [2]uint64{1000, 500},
+		},
+		{
+			//A -> PRV: 2000000 / 2100 = 952.38, rounded up to 953, gives 47 PRV.
+			//PRV -> B: 500000 / 1047 = 477.55, rounded up to 478, gives 22 B.
+			name:        "cross pool",
+			tokenToSell: testTokenA, tokenToBuy: testTokenB, sellAmount: 100,
+			wantReceive: 22,
+			wantPoolA:   [2]uint64{953, 2100},
+			wantPoolB:   [2]uint64{1047, 478},
+		},
+		{
+			name:        "cross pool refunded below the minimum",
+			tokenToSell: testTokenA, tokenToBuy: testTokenB, sellAmount: 100, minAmount: 23,
+			wantRefund: true,
+			wantPoolA:  [2]uint64{1000, 2000},
+			wantPoolB:  [2]uint64{1000, 500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newTestPDESimulator()
+			res, err := sim.Trade(tt.tokenToSell, tt.tokenToBuy, tt.sellAmount, tt.minAmount)
+			if err != nil {
+				t.Fatalf("Trade: %v", err)
+			}
+			if res.Refunded != tt.wantRefund {
+				t.Fatalf("Refunded = %v, want %v", res.Refunded, tt.wantRefund)
+			}
+			if res.ReceiveAmount != tt.wantReceive {
+				t.Fatalf("ReceiveAmount = %v, want %v", res.ReceiveAmount, tt.wantReceive)
+			}
+			checkPool(t, sim, common.PRVIDStr, testTokenA, tt.wantPoolA[0], tt.wantPoolA[1])
+			checkPool(t, sim, common.PRVIDStr, testTokenB, tt.wantPoolB[0], tt.wantPoolB[1])
+		})
+	}
+}
+
+func TestPDESimulatorContribute(t *testing.T) {
+	tests := []struct {
+		name            string
+		amountPRV       uint64
+		amountA         uint64
+		wantContributed [2]uint64
+		wantReturned    [2]uint64
+		wantAddedShare  uint64
+		wantPoolAfter   [2]uint64
+	}{
+		{
+			//100 PRV matches 200 A, the other 100 A are returned.
+			name:      "excess of the second token",
+			amountPRV: 100, amountA: 300,
+			wantContributed: [2]uint64{100, 200},
+			wantReturned:    [2]uint64{0, 100},
+			wantAddedShare:  0, //3 * 100 / 1000 = 0.3
+			wantPoolAfter:   [2]uint64{1100, 2200},
+		},
+		{
+			//150 A matches 75 PRV, the other 25 PRV are returned.
+			name:      "excess of the first token",
+			amountPRV: 100, amountA: 150,
+			wantContributed: [2]uint64{75, 150},
+			wantReturned:    [2]uint64{25, 0},
+			wantAddedShare:  0,
+			wantPoolAfter:   [2]uint64{1075, 2150},
+		},
+		{
+			//3 PRV would need 6 A; 5 A match 2.5 PRV, rounded down to 2.
+			name:      "rounding of the matched amount",
+			amountPRV: 3, amountA: 5,
+			wantContributed: [2]uint64{2, 5},
+			wantReturned:    [2]uint64{1, 0},
+			wantPoolAfter:   [2]uint64{1002, 2005},
+		},
+		{
+			//3 * 1000 / 1000 = 3 new shares.
+			name:      "share proportional to the pool",
+			amountPRV: 1000, amountA: 2000,
+			wantContributed: [2]uint64{1000, 2000},
+			wantAddedShare:  3,
+			wantPoolAfter:   [2]uint64{2000, 4000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newTestPDESimulator()
+			res, err := sim.Contribute(common.PRVIDStr, testTokenA, tt.amountPRV, tt.amountA, "carol")
+			if err != nil {
+				t.Fatalf("Contribute: %v", err)
+			}
+			if res.ContributedAmount1 != tt.wantContributed[0] || res.ContributedAmount2 != tt.wantContributed[1] {
+				t.Fatalf("contributed %v/%v, want %v/%v", res.ContributedAmount1, res.ContributedAmount2, tt.wantContributed[0], tt.wantContributed[1])
+			}
+			if res.ReturnedAmount1 != tt.wantReturned[0] || res.ReturnedAmount2 != tt.wantReturned[1] {
+				t.Fatalf("returned %v/%v, want %v/%v", res.ReturnedAmount1, res.ReturnedAmount2, tt.wantReturned[0], tt.wantReturned[1])
+			}
+			if res.AddedShare != tt.wantAddedShare {
+				t.Fatalf("AddedShare = %v, want %v", res.AddedShare, tt.wantAddedShare)
+			}
+			checkPool(t, sim, common.PRVIDStr, testTokenA, tt.wantPoolAfter[0], tt.wantPoolAfter[1])
+
+			share, totalShares := sim.GetShare(common.PRVIDStr, testTokenA, "carol")
+			if share != tt.wantAddedShare || totalShares != 3+tt.wantAddedShare {
+				t.Fatalf("share of carol %v/%v, want %v/%v", share, totalShares, tt.wantAddedShare, 3+tt.wantAddedShare)
+			}
+		})
+	}
+}
+
+func TestPDESimulatorWithdraw(t *testing.T) {
+	sim := newTestPDESimulator()
+
+	//1/3 of the pool: 1000 / 3 = 333.33 and 2000 / 3 = 666.67, both rounded down.
+	amountPRV, amountA, err := sim.Withdraw(common.PRVIDStr, testTokenA, "alice", 1)
+	if err != nil {
+		t.Fatalf("Withdraw: %v", err)
+	}
+	if amountPRV != 333 || amountA != 666 {
+		t.Fatalf("alice withdrew %v/%v, want 333/666", amountPRV, amountA)
+	}
+	checkPool(t, sim, common.PRVIDStr, testTokenA, 667, 1334)
+
+	//A share larger than the one held is capped: bob withdraws the rest of the pool.
+	amountPRV, amountA, err = sim.Withdraw(common.PRVIDStr, testTokenA, "bob", 5)
+	if err != nil {
+		t.Fatalf("Withdraw: %v", err)
+	}
+	if amountPRV != 667 || amountA != 1334 {
+		t.Fatalf("bob withdrew %v/%v, want 667/1334", amountPRV, amountA)
+	}
+	checkPool(t, sim, common.PRVIDStr, testTokenA, 0, 0)
+
+	_, _, err = sim.Withdraw(common.PRVIDStr, testTokenA, "alice", 1)
+	if err == nil {
+		t.Fatalf("Withdraw without share succeeded, want an error")
+	}
+}
+
+type testPDEStrategy struct {
+	orders []PDESimOrder
+}
+
+func (strategy *testPDEStrategy) Name() string {
+	return "test"
+}
+
+func (strategy *testPDEStrategy) Step(*PDESimulator, map[string]uint64) []PDESimOrder {
+	return strategy.orders
+}
+
+func TestBacktestPDEStrategyRefund(t *testing.T) {
+	//The trade would receive 181 A, less than the minimum: it is refunded with its trading fee.
+	strategy := &testPDEStrategy{orders: []PDESimOrder{{
+		TokenIDToSell:       common.PRVIDStr,
+		TokenIDToBuy:        testTokenA,
+		SellAmount:          100,
+		MinAcceptableAmount: 182,
+		TradingFee:          10,
+	}}}
+
+	res, err := BacktestPDEStrategy(strategy, []*PDESimulator{newTestPDESimulator()}, map[string]uint64{common.PRVIDStr: 1000}, "")
+	if err != nil {
+		t.Fatalf("BacktestPDEStrategy: %v", err)
+	}
+	if len(res.Trades) != 1 || !res.Trades[0].Refunded {
+		t.Fatalf("trades = %v, want one refunded trade", res.Trades)
+	}
+	if res.FinalBalances[common.PRVIDStr] != 1000 || res.FinalBalances[testTokenA] != 0 {
+		t.Fatalf("final balances = %v, want 1000 PRV", res.FinalBalances)
+	}
+	if res.PRVFees != DefaultPRVFee {
+		t.Fatalf("PRVFees = %v, want %v", res.PRVFees, DefaultPRVFee)
+	}
+}
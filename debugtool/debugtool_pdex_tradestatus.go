@@ -0,0 +1,495 @@
+package debugtool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/common/base58"
+	"github.com/thanhn-inc/debugtool/metadata"
+	"github.com/thanhn-inc/debugtool/privacy/coin"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+)
+
+const (
+	PDETradeAccepted = "accepted"
+	PDETradeRefunded = "refunded"
+
+	DefaultPDETradeTrackTimeout = 15 * time.Minute
+
+	//maxTradeInstructionScanBlocks is the number of beacon blocks searched for the result of a trade after the beacon height
+	//of the shard block containing it.
+	maxTradeInstructionScanBlocks = 100
+)
+
+var (
+	tradeQuotes   = make(map[string]*PDETradeQuote)
+	tradeQuotesMu sync.Mutex
+)
+
+func recordPDETradeQuote(txHash string, quote *PDETradeQuote) {
+	tradeQuotesMu.Lock()
+	defer tradeQuotesMu.Unlock()
+	tradeQuotes[txHash] = quote
+}
+
+//GetPDETradeQuoteOfTx returns the quote used to send a trade from this session, or nil.
+func GetPDETradeQuoteOfTx(txHash string) *PDETradeQuote {
+	tradeQuotesMu.Lock()
+	defer tradeQuotesMu.Unlock()
+	return tradeQuotes[txHash]
+}
+
+//PDETradeResult is the outcome of a PDE trade, parsed from the beacon instructions.
+type PDETradeResult struct {
+	TxHash       string
+	IsCrossPool  bool
+	Request      metadata.PDETradeRequest
+	ShardID      byte
+	ShardHeight  uint64
+	BeaconHeight uint64
+	Status       string
+
+	//Accepted trades.
+	ReceiveTokenID string
+	ReceiveAmount  uint64
+	EffectiveRate  float64
+	QuotedAmount   uint64  //0 if the trade was not quoted in this session
+	QuoteDiff      float64 //percent, (ReceiveAmount - QuotedAmount) / QuotedAmount
+
+	//Refunded trades.
+	Refunds      map[string]uint64
+	RefundReason string
+
+	//BalanceConfirmed is true if the received (or refunded) coins have been found among the outputs of the trader.
+	BalanceConfirmed bool
+}
+
+func (res PDETradeResult) String() string {
+	s := "========== PDE TRADE RESULT ==========\n"
+	s += fmt.Sprintf("TxHash: %v (shard %v, height %v)\n", res.TxHash, res.ShardID, res.ShardHeight)
+	s += fmt.Sprintf("Sell: %v %v, buy: %v, minimum: %v, trading fee: %v, crossPool: %v\n",
+		res.Request.SellAmount, res.Request.TokenIDToSellStr, res.Request.TokenIDToBuyStr, res.Request.MinAcceptableAmount, res.Request.TradingFee, res.IsCrossPool)
+	s += fmt.Sprintf("Status: %v at beacon height %v\n", res.Status, res.BeaconHeight)
+	if res.Status == PDETradeAccepted {
+		s += fmt.Sprintf("Received: %v %v, rate %v\n", res.ReceiveAmount, res.ReceiveTokenID, res.EffectiveRate)
+		if res.QuotedAmount != 0 {
+			s += fmt.Sprintf("Quoted: %v, difference %.4f%%\n", res.QuotedAmount, res.QuoteDiff)
+		}
+	} else {
+		for tokenID, amount := range res.Refunds {
+			s += fmt.Sprintf("Refunded: %v %v\n", amount, tokenID)
+		}
+		s += fmt.Sprintf("Reason: %v\n", res.RefundReason)
+	}
+	s += fmt.Sprintf("Balance confirmed: %v\n", res.BalanceConfirmed)
+	s += "========== END PDE TRADE RESULT =========="
+	return s
+}
+
+//TrackPDETrade follows a trade transaction from the mempool to its shard block and to the beacon instruction processing it,
+//and reports whether it was accepted or refunded.
+//
+//If privateKey is not empty, it also waits for the received (or refunded) coins to show up in the outputs of the trader.
+//If quote is nil, the quote recorded when the trade was sent from this session (if any) is used.
+func TrackPDETrade(ctx context.Context, privateKey, txHash string, quote *PDETradeQuote, timeout time.Duration, onProgress func(string)) (*PDETradeResult, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if onProgress == nil {
+		onProgress = func(string) {}
+	}
+	if quote == nil {
+		quote = GetPDETradeQuoteOfTx(txHash)
+	}
+
+	_, err := WaitForTx(ctx, txHash, 1, 0, func(update TxStatusUpdate) {
+		onProgress(update.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	txDetail, found, err := getTxDetail(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New(fmt.Sprintf("tx %v not found", txHash))
+	}
+
+	res := &PDETradeResult{TxHash: txHash, ShardID: txDetail.ShardID, ShardHeight: txDetail.BlockHeight}
+	err = json.Unmarshal([]byte(txDetail.Metadata), &res.Request)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse metadata of tx %v: %v", txHash, err))
+	}
+	switch res.Request.Type {
+	case metadata.PDETradeRequestMeta:
+	case metadata.PDECrossPoolTradeRequestMeta:
+		res.IsCrossPool = true
+	default:
+		return nil, errors.New(fmt.Sprintf("tx %v is not a trade, metadata type %v", txHash, res.Request.Type))
+	}
+
+	shardBlock, err := GetShardBlockByHeight(txDetail.ShardID, txDetail.BlockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	//Coins received before the trade is processed cannot come from it, so they are recorded to tell them apart from the result.
+	//The beacon height is read after the coins: a trade processed at or below it may have paid a coin of the snapshot.
+	var knownCoins map[string]map[string]bool
+	var snapshotHeight uint64
+	if len(privateKey) != 0 {
+		knownCoins = make(map[string]map[string]bool)
+		for _, tokenID := range []string{res.Request.TokenIDToSellStr, res.Request.TokenIDToBuyStr, common.PRVIDStr} {
+			if _, ok := knownCoins[tokenID]; ok {
+				continue
+			}
+			knownCoins[tokenID], err = getOutputCoinCommitments(privateKey, tokenID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		bestBlocks, err := GetBestBlock()
+		if err != nil {
+			return nil, err
+		}
+		snapshotHeight = bestBlocks[-1]
+	}
+
+	onProgress(fmt.Sprintf("tx %v in shard block %v, searching beacon blocks from %v", txHash, txDetail.BlockHeight, shardBlock.BeaconHeight))
+	beaconHeight, insts, err := findTradeInstructions(ctx, txHash, res.Request.Type, shardBlock.BeaconHeight)
+	if err != nil {
+		return nil, err
+	}
+	res.BeaconHeight = beaconHeight
+
+	err = res.parseInstructions(insts)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status == PDETradeAccepted {
+		if res.Request.SellAmount != 0 {
+			res.EffectiveRate = float64(res.ReceiveAmount) / float64(res.Request.SellAmount)
+		}
+		if quote != nil && quote.ExpectedAmount != 0 {
+			res.QuotedAmount = quote.ExpectedAmount
+			res.QuoteDiff = (float64(res.ReceiveAmount) - float64(quote.ExpectedAmount)) / float64(quote.ExpectedAmount) * 100
+		}
+	} else {
+		res.RefundReason = explainPDETradeRefund(res.Request, beaconHeight)
+	}
+	onProgress(fmt.Sprintf("trade %v %v at beacon height %v", txHash, res.Status, beaconHeight))
+
+	if len(privateKey) == 0 {
+		return res, nil
+	}
+	if beaconHeight <= snapshotHeight {
+		onProgress(fmt.Sprintf("trade %v was processed before tracking started, its coins cannot be told apart from the existing ones", txHash))
+		return res, nil
+	}
+
+	expectedCoins := res.Refunds
+	if res.Status == PDETradeAccepted {
+		expectedCoins = map[string]uint64{res.ReceiveTokenID: res.ReceiveAmount}
+	}
+	for tokenID, amount := range expectedCoins {
+		onProgress(fmt.Sprintf("waiting for a coin of %v %v", amount, tokenID))
+		err = waitForReceivedCoin(ctx, privateKey, tokenID, amount, knownCoins[tokenID])
+		if err != nil {
+			return res, err
+		}
+	}
+	res.BalanceConfirmed = true
+
+	return res, nil
+}
+
+//findTradeInstructions scans beacon blocks from startHeight for the instructions processing a trade request.
+func findTradeInstructions(ctx context.Context, txHash string, metaType int, startHeight uint64) (uint64, [][]string, error) {
+	metaTypeStr := strconv.Itoa(metaType)
+	for height := startHeight; height < startHeight+maxTradeInstructionScanBlocks; height++ {
+		for {
+			bestBlocks, err := GetBestBlock()
+			if err != nil {
+				return 0, nil, err
+			}
+			if bestBlocks[-1] >= height {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return 0, nil, errors.New(fmt.Sprintf("stop waiting for the result of trade %v at beacon height %v: %v", txHash, height, ctx.Err()))
+			case <-time.After(DefaultTrackerPollInterval):
+			}
+		}
+
+		beaconBlock, err := GetBeaconBlockByHeight(height)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		insts := make([][]string, 0)
+		for _, inst := range beaconBlock.Instructions {
+			if len(inst) < 4 || inst[0] != metaTypeStr {
+				continue
+			}
+			if tradeInstructionTxReqID(inst) == txHash {
+				insts = append(insts, inst)
+			}
+		}
+		if len(insts) != 0 {
+			return height, insts, nil
+		}
+	}
+
+	return 0, nil, errors.New(fmt.Sprintf("no result found for trade %v in beacon blocks %v to %v", txHash, startHeight, startHeight+maxTradeInstructionScanBlocks-1))
+}
+
+//tradeInstructionTxReqID returns the hash of the trade request processed by a trade instruction.
+func tradeInstructionTxReqID(inst []string) string {
+	status, content := inst[2], inst[3]
+	switch status {
+	case common.PDETradeAcceptedChainStatus:
+		var accepted metadata.PDETradeAcceptedContent
+		if json.Unmarshal([]byte(content), &accepted) == nil {
+			return accepted.RequestedTxID.String()
+		}
+	case common.PDETradeRefundChainStatus:
+		var action metadata.PDETradeRequestAction
+		if json.Unmarshal([]byte(content), &action) == nil {
+			return action.TxReqID.String()
+		}
+	case common.PDECrossPoolTradeAcceptedChainStatus:
+		var accepted []metadata.PDECrossPoolTradeAcceptedContent
+		if json.Unmarshal([]byte(content), &accepted) == nil && len(accepted) != 0 {
+			return accepted[0].RequestedTxID.String()
+		}
+	case common.PDECrossPoolTradeFeeRefundChainStatus, common.PDECrossPoolTradeSellingTokenRefundChainStatus:
+		var refund metadata.PDERefundCrossPoolTrade
+		if json.Unmarshal([]byte(content), &refund) == nil {
+			return refund.TxReqID.String()
+		}
+	}
+
+	return ""
+}
+
+func (res *PDETradeResult) parseInstructions(insts [][]string) error {
+	res.Refunds = make(map[string]uint64)
+	for _, inst := range insts {
+		status, content := inst[2], inst[3]
+		switch status {
+		case common.PDETradeAcceptedChainStatus:
+			var accepted metadata.PDETradeAcceptedContent
+			err := json.Unmarshal([]byte(content), &accepted)
+			if err != nil {
+				return err
+			}
+			res.Status = PDETradeAccepted
+			res.ReceiveTokenID = accepted.TokenIDToBuyStr
+			res.ReceiveAmount = accepted.ReceiveAmount
+		case common.PDECrossPoolTradeAcceptedChainStatus:
+			var accepted []metadata.PDECrossPoolTradeAcceptedContent
+			err := json.Unmarshal([]byte(content), &accepted)
+			if err != nil {
+				return err
+			}
+			//The last content is the trade with the pool of the buying token.
+			res.Status = PDETradeAccepted
+			res.ReceiveTokenID = accepted[len(accepted)-1].TokenIDToBuyStr
+			res.ReceiveAmount = accepted[len(accepted)-1].ReceiveAmount
+		case common.PDETradeRefundChainStatus:
+			var action metadata.PDETradeRequestAction
+			err := json.Unmarshal([]byte(content), &action)
+			if err != nil {
+				return err
+			}
+			res.Status = PDETradeRefunded
+			res.Refunds[action.Meta.TokenIDToSellStr] += action.Meta.SellAmount + action.Meta.TradingFee
+		case common.PDECrossPoolTradeFeeRefundChainStatus, common.PDECrossPoolTradeSellingTokenRefundChainStatus:
+			var refund metadata.PDERefundCrossPoolTrade
+			err := json.Unmarshal([]byte(content), &refund)
+			if err != nil {
+				return err
+			}
+			res.Status = PDETradeRefunded
+			if refund.Amount > 0 {
+				res.Refunds[refund.TokenIDStr] += refund.Amount
+			}
+		default:
+			return errors.New(fmt.Sprintf("unknown trade instruction status %v", status))
+		}
+	}
+	if res.Status == PDETradeAccepted && len(res.Refunds) != 0 {
+		return errors.New(fmt.Sprintf("trade %v is both accepted and refunded", res.TxHash))
+	}
+
+	return nil
+}
+
+//explainPDETradeRefund replays a refunded trade against the pool state right before it was processed.
+func explainPDETradeRefund(req metadata.PDETradeRequest, beaconHeight uint64) string {
+	allPoolPairs, err := GetAllPDEPoolPairs(beaconHeight - 1)
+	if err != nil {
+		return fmt.Sprintf("unknown, cannot get the pool state at beacon height %v: %v", beaconHeight-1, err)
+	}
+
+	route := []string{req.TokenIDToSellStr, req.TokenIDToBuyStr}
+	if req.TokenIDToSellStr != common.PRVIDStr && req.TokenIDToBuyStr != common.PRVIDStr {
+		route = []string{req.TokenIDToSellStr, common.PRVIDStr, req.TokenIDToBuyStr}
+	}
+
+	amount := req.SellAmount
+	for i := 0; i < len(route)-1; i++ {
+		sellPoolAmount, buyPoolAmount, err := getPoolAmounts(allPoolPairs, beaconHeight-1, route[i], route[i+1])
+		if err != nil || sellPoolAmount == 0 || buyPoolAmount == 0 {
+			return fmt.Sprintf("pool %v - %v does not exist or is empty", route[i], route[i+1])
+		}
+
+		amount, err = UniswapValue(amount, sellPoolAmount, buyPoolAmount)
+		if err != nil {
+			return fmt.Sprintf("the trade cannot be computed on pool %v - %v: %v", route[i], route[i+1], err)
+		}
+	}
+
+	if amount < req.MinAcceptableAmount {
+		return fmt.Sprintf("slippage: the trade would receive %v, less than the minimum acceptable amount %v", amount, req.MinAcceptableAmount)
+	}
+	return fmt.Sprintf("the trade would receive %v (minimum %v) at the start of beacon block %v; trades with higher fees in the same block have moved the price",
+		amount, req.MinAcceptableAmount, beaconHeight)
+}
+
+//waitForReceivedCoin waits until a new output coin of the given token and amount belongs to the private key. Coins whose commitments
+//are in knownCoins are ignored.
+func waitForReceivedCoin(ctx context.Context, privateKey, tokenID string, amount uint64, knownCoins map[string]bool) error {
+	for {
+		listDecryptedCoins, err := getDecryptedOutputCoins(privateKey, tokenID)
+		if err != nil {
+			return err
+		}
+		for _, decryptedCoin := range listDecryptedCoins {
+			if decryptedCoin.GetValue() == amount && !knownCoins[coinCommitmentStr(decryptedCoin)] {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New(fmt.Sprintf("stop waiting for a coin of %v %v: %v", amount, tokenID, ctx.Err()))
+		case <-time.After(DefaultTrackerPollInterval):
+		}
+	}
+}
+
+//getOutputCoinCommitments returns the commitments of the output coins of the given token belonging to the private key.
+func getOutputCoinCommitments(privateKey, tokenID string) (map[string]bool, error) {
+	listDecryptedCoins, err := getDecryptedOutputCoins(privateKey, tokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]bool)
+	for _, decryptedCoin := range listDecryptedCoins {
+		res[coinCommitmentStr(decryptedCoin)] = true
+	}
+
+	return res, nil
+}
+
+func getDecryptedOutputCoins(privateKey, tokenID string) ([]coin.PlainCoin, error) {
+	outCoinKey, err := NewOutCoinKeyFromPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	outCoinKey.SetReadonlyKey("")
+
+	listOutputCoins, _, err := GetOutputCoins(outCoinKey, tokenID, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(listOutputCoins) == 0 {
+		return nil, nil
+	}
+
+	listDecryptedCoins, _, err := GetListDecryptedCoins(privateKey, listOutputCoins)
+	if err != nil {
+		return nil, err
+	}
+
+	return listDecryptedCoins, nil
+}
+
+func coinCommitmentStr(c coin.PlainCoin) string {
+	return base58.Base58Check{}.Encode(c.GetCommitment().ToBytesS(), common.ZeroByte)
+}
+
+//GetShardBlockByHeight retrieves a shard block at the given height.
+func GetShardBlockByHeight(shardID byte, height uint64) (*jsonresult.GetShardBlockResult, error) {
+	responseInBytes, err := rpc.RetrieveBlockByHeight(shardID, height)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []jsonresult.GetShardBlockResult
+	err = json.Unmarshal(response.Result, &blocks)
+	if err != nil {
+		var block jsonresult.GetShardBlockResult
+		err = json.Unmarshal(response.Result, &block)
+		if err != nil {
+			return nil, err
+		}
+		return &block, nil
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New(fmt.Sprintf("shard block %v of shard %v not found", height, shardID))
+	}
+
+	return &blocks[0], nil
+}
+
+//GetBeaconBlockByHeight retrieves a beacon block at the given height.
+func GetBeaconBlockByHeight(height uint64) (*jsonresult.GetBeaconBlockResult, error) {
+	responseInBytes, err := rpc.RetrieveBeaconBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []jsonresult.GetBeaconBlockResult
+	err = json.Unmarshal(response.Result, &blocks)
+	if err != nil {
+		var block jsonresult.GetBeaconBlockResult
+		err = json.Unmarshal(response.Result, &block)
+		if err != nil {
+			return nil, err
+		}
+		return &block, nil
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New(fmt.Sprintf("beacon block %v not found", height))
+	}
+
+	return &blocks[0], nil
+}
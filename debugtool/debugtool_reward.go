@@ -0,0 +1,417 @@
+package debugtool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+)
+
+const (
+	DefaultRewardPollInterval = 10 * time.Minute
+	DefaultRewardForwardFile  = "rewardforwards.json"
+
+	rewardConfirmTimeout = 15 * time.Minute
+	rewardCreditInterval = 20 * time.Second
+)
+
+//RewardWithdrawal is a withdrawal request sent for a token.
+type RewardWithdrawal struct {
+	TokenID string
+	Amount  uint64
+	TxHash  string
+}
+
+//GetRewardAmounts returns the non-zero rewards, per token ID, of a private key or a payment address.
+func GetRewardAmounts(key string) (map[string]uint64, error) {
+	addr, err := getPaymentAddressFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := getPublicKeyFromAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	rewards, err := GetRewardAmountByPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]uint64)
+	for tokenID, amount := range rewards {
+		if amount != 0 {
+			res[tokenID] = amount
+		}
+	}
+
+	return res, nil
+}
+
+//WithdrawAllRewards sends a withdrawal request for every token whose reward is at least its threshold: thresholds[tokenID] if set,
+//defaultThreshold otherwise (a zero threshold means any non-zero reward). The rewards of addr are withdrawn, addr is the payment
+//address of privateKey if empty.
+//
+//Requests are sent one after another, none of them spending the coins of the previous ones. The requests sent before an error are
+//returned along with it.
+func WithdrawAllRewards(privateKey, addr string, thresholds map[string]uint64, defaultThreshold uint64) ([]*RewardWithdrawal, error) {
+	if len(addr) == 0 {
+		var err error
+		addr, err = getPaymentAddressFromKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rewards, err := GetRewardAmounts(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenIDs := make([]string, 0)
+	for tokenID, amount := range rewards {
+		threshold, ok := thresholds[tokenID]
+		if !ok {
+			threshold = defaultThreshold
+		}
+		if amount >= threshold {
+			tokenIDs = append(tokenIDs, tokenID)
+		}
+	}
+	sort.Strings(tokenIDs)
+
+	res := make([]*RewardWithdrawal, 0)
+	excluded := make([]string, 0)
+	for _, tokenID := range tokenIDs {
+		txParam, err := newWithdrawRewardTxParam(privateKey, addr, tokenID)
+		if err != nil {
+			return res, err
+		}
+		txParam.SetExcludedKeyImages(excluded)
+
+		encodedTx, txHash, err := CreateRawTransaction(txParam, -1)
+		if err != nil {
+			return res, errors.New(fmt.Sprintf("cannot create withdrawal of %v: %v", tokenID, err))
+		}
+
+		responseInBytes, err := sendRawTx(encodedTx, txHash)
+		if err != nil {
+			return res, err
+		}
+		_, err = rpchandler.ParseResponse(responseInBytes)
+		if err != nil {
+			return res, errors.New(fmt.Sprintf("cannot send withdrawal of %v: %v", tokenID, err))
+		}
+
+		excluded = append(excluded, txParam.inputKeyImages...)
+		res = append(res, &RewardWithdrawal{TokenID: tokenID, Amount: rewards[tokenID], TxHash: txHash})
+	}
+
+	return res, nil
+}
+
+//RewardScheduler periodically withdraws the rewards of a key once they reach a threshold, and optionally forwards them
+//to another address, e.g. a cold wallet.
+type RewardScheduler struct {
+	PrivateKey string
+	//Thresholds are the minimum rewards to withdraw per token ID; tokens not listed use DefaultThreshold.
+	Thresholds       map[string]uint64
+	DefaultThreshold uint64
+	//ForwardAddr, if not empty, receives the withdrawn rewards.
+	ForwardAddr string
+	//ForwardFile, if not empty, records the withdrawals not forwarded yet, so that a later step forwards them even after a restart.
+	ForwardFile  string
+	PollInterval time.Duration
+	OnEvent      func(msg string)
+
+	addr            string
+	pendingForwards []*RewardWithdrawal
+}
+
+//NewRewardScheduler creates a scheduler withdrawing the rewards of privateKey once they reach threshold in any token.
+func NewRewardScheduler(privateKey string, threshold uint64, forwardAddr string) (*RewardScheduler, error) {
+	addr, err := getPaymentAddressFromKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	forwardFile := ""
+	if len(forwardAddr) != 0 {
+		forwardFile = DefaultRewardForwardFile
+		forwardAddr, err = getPaymentAddressFromKey(forwardAddr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid forward address: %v", err))
+		}
+	}
+
+	return &RewardScheduler{
+		PrivateKey:       privateKey,
+		Thresholds:       make(map[string]uint64),
+		DefaultThreshold: threshold,
+		ForwardAddr:      forwardAddr,
+		ForwardFile:      forwardFile,
+		PollInterval:     DefaultRewardPollInterval,
+		addr:             addr,
+	}, nil
+}
+
+//Run checks the rewards every PollInterval until ctx is done. Errors are reported through OnEvent and retried at the next check.
+func (s *RewardScheduler) Run(ctx context.Context) {
+	for {
+		err := s.Step(ctx)
+		if err != nil {
+			s.emit(fmt.Sprintf("error: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.PollInterval):
+		}
+	}
+}
+
+//Step withdraws the rewards above their threshold and waits for the withdrawals to be confirmed.
+//If ForwardAddr is set, it then waits for the rewards to be received and forwards them, one token after another. The withdrawals
+//are recorded until forwarded: those left by a previous step are forwarded first, before any new withdrawal.
+func (s *RewardScheduler) Step(ctx context.Context) error {
+	balancesBefore := make(map[string]uint64)
+	if len(s.ForwardAddr) != 0 {
+		err := s.forwardPending(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		rewards, err := GetRewardAmounts(s.PrivateKey)
+		if err != nil {
+			return err
+		}
+		for tokenID := range rewards {
+			balancesBefore[tokenID], err = GetBalance(s.PrivateKey, tokenID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	withdrawals, err := WithdrawAllRewards(s.PrivateKey, "", s.Thresholds, s.DefaultThreshold)
+	for _, withdrawal := range withdrawals {
+		s.emit(fmt.Sprintf("withdrawal of %v %v sent: %v", withdrawal.Amount, withdrawal.TokenID, withdrawal.TxHash))
+	}
+	if len(s.ForwardAddr) != 0 && len(withdrawals) != 0 {
+		//Recorded before anything else can fail: once withdrawn, the rewards are no longer seen by the next steps.
+		pending, loadErr := s.loadPendingForwards()
+		if loadErr == nil {
+			loadErr = s.savePendingForwards(append(pending, withdrawals...))
+		}
+		if loadErr != nil {
+			return errors.New(fmt.Sprintf("cannot record the withdrawals to forward: %v", loadErr))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(withdrawals) == 0 {
+		return nil
+	}
+
+	for _, withdrawal := range withdrawals {
+		_, err = WaitForTx(ctx, withdrawal.TxHash, 1, rewardConfirmTimeout, nil)
+		if err != nil {
+			return err
+		}
+		s.emit(fmt.Sprintf("withdrawal of %v confirmed", withdrawal.TokenID))
+	}
+
+	if len(s.ForwardAddr) == 0 {
+		return nil
+	}
+
+	expected := make(map[string]uint64)
+	for _, withdrawal := range withdrawals {
+		expected[withdrawal.TokenID] = balancesBefore[withdrawal.TokenID] + withdrawal.Amount
+		if withdrawal.TokenID == common.PRVIDStr {
+			fees := DefaultPRVFee * uint64(len(withdrawals))
+			if expected[withdrawal.TokenID] < fees {
+				expected[withdrawal.TokenID] = 0
+			} else {
+				expected[withdrawal.TokenID] -= fees
+			}
+		}
+	}
+
+	return s.forwardPending(ctx, expected)
+}
+
+//forwardPending forwards the recorded withdrawals one after another, and forgets each one once forwarded. For the tokens in
+//expected, it first waits for the balance to reach the given amount. A withdrawal missing from every node was never accepted:
+//it is forgotten, since its reward is still to be withdrawn.
+func (s *RewardScheduler) forwardPending(ctx context.Context, expected map[string]uint64) error {
+	pending, err := s.loadPendingForwards()
+	if err != nil {
+		return err
+	}
+
+	for len(pending) != 0 {
+		withdrawal := pending[0]
+		txDetail, found, err := getTxDetail(withdrawal.TxHash)
+		if err != nil {
+			return err
+		}
+		if !found {
+			isAbsent, err := IsTxAbsentFromAllNodes(withdrawal.TxHash)
+			if err != nil {
+				return err
+			}
+			if !isAbsent {
+				return errors.New(fmt.Sprintf("withdrawal %v of %v unknown to the current node, forward postponed", withdrawal.TxHash, withdrawal.TokenID))
+			}
+			s.emit(fmt.Sprintf("withdrawal %v of %v not found on any node, not forwarded", withdrawal.TxHash, withdrawal.TokenID))
+		} else {
+			if !txDetail.IsInBlock {
+				return errors.New(fmt.Sprintf("withdrawal %v of %v not confirmed yet, forward postponed", withdrawal.TxHash, withdrawal.TokenID))
+			}
+			if amount, ok := expected[withdrawal.TokenID]; ok {
+				err = s.waitForCredit(ctx, withdrawal.TokenID, amount)
+				if err != nil {
+					return err
+				}
+			}
+
+			txHash, err := s.forward(withdrawal)
+			if err != nil {
+				return errors.New(fmt.Sprintf("cannot forward %v: %v", withdrawal.TokenID, err))
+			}
+			s.emit(fmt.Sprintf("forwarded %v %v to %v: %v", withdrawal.Amount, withdrawal.TokenID, s.ForwardAddr, txHash))
+
+			//The next forwarding may need the change of this one.
+			_, err = WaitForTx(ctx, txHash, 1, rewardConfirmTimeout, nil)
+			if err != nil {
+				//The forward was sent, it must not be sent again.
+				_ = s.savePendingForwards(pending[1:])
+				return err
+			}
+		}
+
+		pending = pending[1:]
+		err = s.savePendingForwards(pending)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//loadPendingForwards returns the withdrawals of the scheduler key not forwarded yet.
+func (s *RewardScheduler) loadPendingForwards() ([]*RewardWithdrawal, error) {
+	if len(s.ForwardFile) == 0 {
+		return s.pendingForwards, nil
+	}
+
+	allForwards, err := readRewardForwardFile(s.ForwardFile)
+	if err != nil {
+		return nil, err
+	}
+	return allForwards[s.addr], nil
+}
+
+//savePendingForwards records the withdrawals of the scheduler key not forwarded yet. The file may be shared by several keys.
+func (s *RewardScheduler) savePendingForwards(pending []*RewardWithdrawal) error {
+	s.pendingForwards = pending
+	if len(s.ForwardFile) == 0 {
+		return nil
+	}
+
+	allForwards, err := readRewardForwardFile(s.ForwardFile)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		delete(allForwards, s.addr)
+	} else {
+		allForwards[s.addr] = pending
+	}
+
+	data, err := json.MarshalIndent(allForwards, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmpFile := s.ForwardFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, s.ForwardFile)
+}
+
+//readRewardForwardFile reads the withdrawals not forwarded yet, per payment address.
+func readRewardForwardFile(fileName string) (map[string][]*RewardWithdrawal, error) {
+	res := make(map[string][]*RewardWithdrawal)
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse %v: %v", fileName, err))
+	}
+	return res, nil
+}
+
+//waitForCredit waits until the balance of a token reaches an expected amount, i.e. a withdrawn reward has been received.
+func (s *RewardScheduler) waitForCredit(ctx context.Context, tokenID string, expected uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, rewardConfirmTimeout)
+	defer cancel()
+
+	for {
+		balance, err := GetBalance(s.PrivateKey, tokenID)
+		if err == nil && balance >= expected {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New(fmt.Sprintf("reward of %v not received: %v", tokenID, ctx.Err()))
+		case <-time.After(rewardCreditInterval):
+		}
+	}
+}
+
+//forward sends a withdrawn reward to ForwardAddr. The PRV fee is paid by the scheduler key.
+func (s *RewardScheduler) forward(withdrawal *RewardWithdrawal) (string, error) {
+	if withdrawal.TokenID != common.PRVIDStr {
+		return CreateAndSendRawTokenTransaction(s.PrivateKey, []string{s.ForwardAddr}, []uint64{withdrawal.Amount}, -1, withdrawal.TokenID, false)
+	}
+
+	amount := withdrawal.Amount
+	balance, err := GetBalance(s.PrivateKey, common.PRVIDStr)
+	if err != nil {
+		return "", err
+	}
+	if balance < amount+DefaultPRVFee {
+		if balance <= DefaultPRVFee {
+			return "", errors.New(fmt.Sprintf("balance insufficient: %v", balance))
+		}
+		amount = balance - DefaultPRVFee
+	}
+
+	return CreateAndSendRawTransaction(s.PrivateKey, []string{s.ForwardAddr}, []uint64{amount}, -1, nil)
+}
+
+func (s *RewardScheduler) emit(msg string) {
+	if s.OnEvent != nil {
+		s.OnEvent(msg)
+	}
+}
@@ -1,6 +1,7 @@
 package debugtool
 
 import (
+	"context"
 	"fmt"
 	"github.com/thanhn-inc/debugtool/common"
 	"github.com/thanhn-inc/debugtool/privacy"
@@ -59,38 +60,32 @@ func CreateAndSendRawSecureTransaction(privateKey string, addr string, amount ui
 
 		start := time.Now()
 
+		_, err = WaitForTx(context.Background(), txHash, 1, 600*time.Second, func(update TxStatusUpdate) {
+			fmt.Println(update)
+		})
+		if err != nil {
+			fmt.Println("txList", txList)
+			return txList, err
+		}
+
+		fmt.Printf("tx %v is in block. Start checking balance of %v ...\n", txHash, nextPrivateKey)
 		for {
-			isInBlock, err := CheckTxInBlock(txHash)
+			balance, err := GetBalance(nextPrivateKey, common.PRVIDStr)
 			if err != nil {
-				fmt.Println("txList", txList)
+				fmt.Println("getBalance error. TxList:", txList)
 				return txList, err
 			}
-			if !isInBlock {
-				fmt.Println("sleeping 10 seconds")
-				time.Sleep(10 * time.Second)
-				continue
-			} else {
-				fmt.Printf("tx %v is in block. Start checking balance of %v ...\n", txHash, nextPrivateKey)
-				for {
-					balance, err := GetBalance(nextPrivateKey, common.PRVIDStr)
-					if err != nil {
-						fmt.Println("getBalance error. TxList:", txList)
-						return txList, err
-					}
-					if balance != 0 {
-						fmt.Println("balance updated:", balance)
-						break
-					}
-					elapsed := time.Since(start)
-					if elapsed.Seconds() > 600 {
-						fmt.Printf("Abort because timeOut. NextPrivateKey: %v, txList: %v\n", nextPrivateKey, txList)
-						return txList, nil
-					}
-					fmt.Println("sleeping 10 seconds for balance...")
-					time.Sleep(10 * time.Second)
-				}
+			if balance != 0 {
+				fmt.Println("balance updated:", balance)
 				break
 			}
+			elapsed := time.Since(start)
+			if elapsed.Seconds() > 600 {
+				fmt.Printf("Abort because timeOut. NextPrivateKey: %v, txList: %v\n", nextPrivateKey, txList)
+				return txList, nil
+			}
+			fmt.Println("sleeping 10 seconds for balance...")
+			time.Sleep(10 * time.Second)
 		}
 
 		currentPrivateKey = nextPrivateKey
@@ -1,11 +1,11 @@
 package debugtool
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/thanhn-inc/debugtool/common"
 	"github.com/thanhn-inc/debugtool/common/base58"
-	"github.com/thanhn-inc/debugtool/incognitokey"
 	"github.com/thanhn-inc/debugtool/metadata"
 	"github.com/thanhn-inc/debugtool/rpchandler"
 	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
@@ -13,11 +13,27 @@ import (
 )
 
 func CreateStakingTransaction(privateKey, privateSeed, candidateAddr, rewardReceiverAddr string, autoStack bool) ([]byte, string, error) {
-	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	return CreateStakingTransactionWithType(privateKey, privateSeed, candidateAddr, rewardReceiverAddr, autoStack, metadata.ShardStakingMeta)
+}
+
+//CreateStakingTransactionWithType creates a shard (metadata.ShardStakingMeta) or beacon (metadata.BeaconStakingMeta) staking transaction.
+//The staked amount is read from the network. The transaction is not created if the funder cannot pay it,
+//or if the committee key of the candidate is already staked.
+func CreateStakingTransactionWithType(privateKey, privateSeed, candidateAddr, rewardReceiverAddr string, autoStack bool, stakingType int) ([]byte, string, error) {
+	txParam, err := newStakingTxParam(privateKey, privateSeed, candidateAddr, rewardReceiverAddr, autoStack, stakingType)
 	if err != nil {
 		return nil, "", err
 	}
 
+	return CreateRawTransaction(txParam, -1)
+}
+
+func newStakingTxParam(privateKey, privateSeed, candidateAddr, rewardReceiverAddr string, autoStack bool, stakingType int) (*TxParam, error) {
+	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
 	funderAddr := senderWallet.Base58CheckSerialize(wallet.PaymentAddressType)
 
 	if len(candidateAddr) == 0 {
@@ -27,46 +43,55 @@ func CreateStakingTransaction(privateKey, privateSeed, candidateAddr, rewardRece
 		rewardReceiverAddr = funderAddr
 	}
 
-	candidateWallet, err := wallet.Base58CheckDeserialize(candidateAddr)
+	committeePK, err := GetCommitteeKeyFromMiningSeed(candidateAddr, privateSeed)
 	if err != nil {
-		return nil, "", err
-	}
-	pk := candidateWallet.KeySet.PaymentAddress.Pk
-	if len(pk) == 0 {
-		return nil, "", errors.New(fmt.Sprintf("candidate payment address invalid: %v", candidateAddr))
+		return nil, err
 	}
 
-	seed, _, err := base58.Base58Check{}.Decode(privateSeed)
+	committeePKBytes, err := committeePK.Bytes()
 	if err != nil {
-		return nil, "", errors.New(fmt.Sprintf("cannot decode private seed: %v", privateSeed))
+		return nil, errors.New(fmt.Sprintf("committe to bytes error: %v", err))
 	}
+	committeeKey := base58.Base58Check{}.Encode(committeePKBytes, common.ZeroByte)
 
-	committeePK, err := incognitokey.NewCommitteeKeyFromSeed(seed, pk)
+	canStake, err := CanPubkeyStake(committeeKey)
 	if err != nil {
-		return nil, "", errors.New(fmt.Sprintf("cannot create committee key from pk: %v, seed: %v. Error: %v", pk, seed, err))
+		return nil, err
+	}
+	if !canStake {
+		return nil, errors.New(fmt.Sprintf("the committee key of %v is already staked", candidateAddr))
 	}
 
-	committeePKBytes, err := committeePK.Bytes()
+	stakingAmount, err := GetStakingAmount(stakingType)
 	if err != nil {
-		return nil, "", errors.New(fmt.Sprintf("committe to bytes error: %v", err))
+		return nil, err
 	}
 
-	stakingAmount := uint64(1750000000000)
-
-	stakingMetadata, err := metadata.NewStakingMetadata(metadata.ShardStakingMeta, funderAddr, rewardReceiverAddr, stakingAmount,
-		base58.Base58Check{}.Encode(committeePKBytes, common.ZeroByte), autoStack)
+	balance, err := GetBalance(privateKey, common.PRVIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if balance < stakingAmount+DefaultPRVFee {
+		return nil, errors.New(fmt.Sprintf("balance insufficient: need %v, have %v", stakingAmount+DefaultPRVFee, balance))
+	}
 
-	txParam := NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{stakingAmount}, common.PRVIDStr, 0, stakingMetadata)
+	stakingMetadata, err := metadata.NewStakingMetadata(stakingType, funderAddr, rewardReceiverAddr, stakingAmount, committeeKey, autoStack)
+	if err != nil {
+		return nil, err
+	}
 
-	return CreateRawTransaction(txParam, -1)
+	return NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{stakingAmount}, common.PRVIDStr, 0, stakingMetadata), nil
 }
 func CreateAndSendStakingTransaction(privateKey, privateSeed, candidateAddr, rewardReceiverAddr string, autoStack bool) (string, error) {
-	encodedTx, txHash, err := CreateStakingTransaction(privateKey, privateSeed, candidateAddr, rewardReceiverAddr, autoStack)
+	return CreateAndSendStakingTransactionWithType(privateKey, privateSeed, candidateAddr, rewardReceiverAddr, autoStack, metadata.ShardStakingMeta)
+}
+func CreateAndSendStakingTransactionWithType(privateKey, privateSeed, candidateAddr, rewardReceiverAddr string, autoStack bool, stakingType int) (string, error) {
+	encodedTx, txHash, err := CreateStakingTransactionWithType(privateKey, privateSeed, candidateAddr, rewardReceiverAddr, autoStack, stakingType)
 	if err != nil {
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTx(string(encodedTx))
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
 	if err != nil {
 		return "", err
 	}
@@ -80,46 +105,42 @@ func CreateAndSendStakingTransaction(privateKey, privateSeed, candidateAddr, rew
 }
 
 func CreateUnStakingTransaction(privateKey, privateSeed, candidateAddr string) ([]byte, string, error) {
-	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	txParam, err := newUnStakingTxParam(privateKey, privateSeed, candidateAddr)
 	if err != nil {
 		return nil, "", err
 	}
 
-	funderAddr := senderWallet.Base58CheckSerialize(wallet.PaymentAddressType)
-
-	if len(candidateAddr) == 0 {
-		candidateAddr = funderAddr
-	}
+	return CreateRawTransaction(txParam, -1)
+}
 
-	candidateWallet, err := wallet.Base58CheckDeserialize(candidateAddr)
+func newUnStakingTxParam(privateKey, privateSeed, candidateAddr string) (*TxParam, error) {
+	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
 	if err != nil {
-		return nil, "", err
-	}
-	pk := candidateWallet.KeySet.PaymentAddress.Pk
-	if len(pk) == 0 {
-		return nil, "", errors.New(fmt.Sprintf("candidate payment address invalid: %v", candidateAddr))
+		return nil, err
 	}
 
-	seed, _, err := base58.Base58Check{}.Decode(privateSeed)
-	if err != nil {
-		return nil, "", errors.New(fmt.Sprintf("cannot decode private seed: %v", privateSeed))
+	funderAddr := senderWallet.Base58CheckSerialize(wallet.PaymentAddressType)
+
+	if len(candidateAddr) == 0 {
+		candidateAddr = funderAddr
 	}
 
-	committeePK, err := incognitokey.NewCommitteeKeyFromSeed(seed, pk)
+	committeePK, err := GetCommitteeKeyFromMiningSeed(candidateAddr, privateSeed)
 	if err != nil {
-		return nil, "", errors.New(fmt.Sprintf("cannot create committee key from pk: %v, seed: %v. Error: %v", pk, seed, err))
+		return nil, err
 	}
 
 	committeePKBytes, err := committeePK.Bytes()
 	if err != nil {
-		return nil, "", errors.New(fmt.Sprintf("committe to bytes error: %v", err))
+		return nil, errors.New(fmt.Sprintf("committe to bytes error: %v", err))
 	}
 
 	unStakingMetadata, err := metadata.NewStopAutoStakingMetadata(metadata.StopAutoStakingMeta, base58.Base58Check{}.Encode(committeePKBytes, common.ZeroByte))
+	if err != nil {
+		return nil, err
+	}
 
-	txParam := NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{0}, common.PRVIDStr, 0, unStakingMetadata)
-
-	return CreateRawTransaction(txParam, -1)
+	return NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{0}, common.PRVIDStr, 0, unStakingMetadata), nil
 }
 func CreateAndSendUnStakingTransaction(privateKey, privateSeed, candidateAddr string) (string, error) {
 	encodedTx, txHash, err := CreateUnStakingTransaction(privateKey, privateSeed, candidateAddr)
@@ -127,7 +148,7 @@ func CreateAndSendUnStakingTransaction(privateKey, privateSeed, candidateAddr st
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTx(string(encodedTx))
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
 	if err != nil {
 		return "", err
 	}
@@ -141,30 +162,48 @@ func CreateAndSendUnStakingTransaction(privateKey, privateSeed, candidateAddr st
 }
 
 func CreateWithDrawRewardTransaction(privateKey, addr string) ([]byte, string, error) {
-	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	return CreateWithDrawRewardTransactionWithToken(privateKey, addr, common.PRVIDStr)
+}
+
+//CreateWithDrawRewardTransactionWithToken creates a transaction withdrawing the reward of addr in the given token.
+func CreateWithDrawRewardTransactionWithToken(privateKey, addr, tokenIDStr string) ([]byte, string, error) {
+	txParam, err := newWithdrawRewardTxParam(privateKey, addr, tokenIDStr)
 	if err != nil {
 		return nil, "", err
 	}
 
+	return CreateRawTransaction(txParam, -1)
+}
+
+func newWithdrawRewardTxParam(privateKey, addr, tokenIDStr string) (*TxParam, error) {
+	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
 	funderAddr := senderWallet.Base58CheckSerialize(wallet.PaymentAddressType)
 
 	if len(addr) == 0 {
 		addr = funderAddr
 	}
 
-	withdrawRewardMetadata, err := metadata.NewWithDrawRewardRequest(common.PRVIDStr, addr, 0, metadata.WithDrawRewardRequestMeta)
-
-	txParam := NewTxParam(privateKey, []string{}, []uint64{}, common.PRVIDStr, 0, withdrawRewardMetadata)
+	withdrawRewardMetadata, err := metadata.NewWithDrawRewardRequest(tokenIDStr, addr, 0, metadata.WithDrawRewardRequestMeta)
+	if err != nil {
+		return nil, err
+	}
 
-	return CreateRawTransaction(txParam, -1)
+	return NewTxParam(privateKey, []string{}, []uint64{}, common.PRVIDStr, 0, withdrawRewardMetadata), nil
 }
 func CreateAndSendWithDrawRewardTransaction(privateKey, addr string) (string, error) {
-	encodedTx, txHash, err := CreateWithDrawRewardTransaction(privateKey, addr)
+	return CreateAndSendWithDrawRewardTransactionWithToken(privateKey, addr, common.PRVIDStr)
+}
+func CreateAndSendWithDrawRewardTransactionWithToken(privateKey, addr, tokenIDStr string) (string, error) {
+	encodedTx, txHash, err := CreateWithDrawRewardTransactionWithToken(privateKey, addr, tokenIDStr)
 	if err != nil {
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTx(string(encodedTx))
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
 	if err != nil {
 		return "", err
 	}
@@ -175,4 +214,71 @@ func CreateAndSendWithDrawRewardTransaction(privateKey, addr string) (string, er
 	}
 
 	return txHash, nil
-}
\ No newline at end of file
+}
+//GetStakingAmount returns the amount of PRV to stake for a shard (metadata.ShardStakingMeta) or beacon (metadata.BeaconStakingMeta) candidate.
+func GetStakingAmount(stakingType int) (uint64, error) {
+	rpcStakingType := 0
+	switch stakingType {
+	case metadata.ShardStakingMeta:
+	case metadata.BeaconStakingMeta:
+		rpcStakingType = 1
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid staking type %v", stakingType))
+	}
+
+	responseInBytes, err := rpc.GetStakingAmount(rpcStakingType)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	var amount uint64
+	err = json.Unmarshal(response.Result, &amount)
+	if err != nil {
+		return 0, err
+	}
+	if amount == 0 {
+		return 0, errors.New("the network returned a zero staking amount")
+	}
+
+	return amount, nil
+}
+
+//CanPubkeyStake returns true if a base58-encoded committee public key is neither staked nor waiting in the mempool to be staked.
+func CanPubkeyStake(committeeKey string) (bool, error) {
+	responseInBytes, err := rpc.CanPubkeyStake(committeeKey)
+	if err != nil {
+		return false, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return false, err
+	}
+
+	var res struct {
+		PublicKey string
+		CanStake  bool
+	}
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return false, err
+	}
+
+	return res.CanStake, nil
+}
+
+//ParseStakingType returns the staking metadata type of "shard" or "beacon".
+func ParseStakingType(stakingType string) (int, error) {
+	switch stakingType {
+	case "shard":
+		return metadata.ShardStakingMeta, nil
+	case "beacon":
+		return metadata.BeaconStakingMeta, nil
+	}
+	return 0, errors.New(fmt.Sprintf("invalid staking type %v, expect shard or beacon", stakingType))
+}
@@ -46,13 +46,17 @@ func CreateRawTransactionVer1(param *TxParam) ([]byte, string, error) {
 	}
 
 	//Create list of payment infos
-	paymentInfos, err := CreatePaymentInfos(param.receiverList, param.amountList)
+	paymentInfos, err := CreatePaymentInfosWithMemos(param.receiverList, param.amountList, param.memoList)
 	if err != nil {
 		return nil, "", err
 	}
 
 	//Calculate the total transacted amount
-	totalAmount := DefaultPRVFee
+	fee := DefaultPRVFee
+	if param.fee != 0 {
+		fee = param.fee
+	}
+	totalAmount := fee
 	for _, amount := range param.amountList {
 		totalAmount += amount
 	}
@@ -62,15 +66,19 @@ func CreateRawTransactionVer1(param *TxParam) ([]byte, string, error) {
 		hasPrivacy = false
 	}
 
-	coinsToSpend, kvargs, err := InitParams(privateKey, common.PRVIDStr, totalAmount, hasPrivacy, 1)
+	coinsToSpend, kvargs, err := InitParamsWithKeyImages(privateKey, common.PRVIDStr, totalAmount, hasPrivacy, 1, param.inputKeyImages, param.excludedKeyImages)
+	if err != nil {
+		return nil, "", err
+	}
 
-	txInitParam := tx_generic.NewTxPrivacyInitParams(&(senderWallet.KeySet.PrivateKey), paymentInfos, coinsToSpend, DefaultPRVFee, hasPrivacy, &common.PRVCoinID, param.md, nil, kvargs)
+	txInitParam := tx_generic.NewTxPrivacyInitParams(&(senderWallet.KeySet.PrivateKey), paymentInfos, coinsToSpend, fee, hasPrivacy, &common.PRVCoinID, param.md, nil, kvargs)
 
 	tx := new(tx_ver1.Tx)
 	err = tx.Init(txInitParam)
 	if err != nil {
 		return nil, "", errors.New(fmt.Sprintf("init txver1 error: %v", err))
 	}
+	param.inputKeyImages = GetKeyImagesOfCoins(coinsToSpend)
 
 	txBytes, err := json.Marshal(tx)
 	if err != nil {
@@ -93,13 +101,17 @@ func CreateRawTransactionVer2(param *TxParam) ([]byte, string, error) {
 	}
 
 	//Create list of payment infos
-	paymentInfos, err := CreatePaymentInfos(param.receiverList, param.amountList)
+	paymentInfos, err := CreatePaymentInfosWithMemos(param.receiverList, param.amountList, param.memoList)
 	if err != nil {
 		return nil, "", err
 	}
 
 	//Calculate the total transacted amount
-	totalAmount := DefaultPRVFee
+	fee := DefaultPRVFee
+	if param.fee != 0 {
+		fee = param.fee
+	}
+	totalAmount := fee
 	for _, amount := range param.amountList {
 		totalAmount += amount
 	}
@@ -110,15 +122,19 @@ func CreateRawTransactionVer2(param *TxParam) ([]byte, string, error) {
 		hasPrivacy = false
 	}
 
-	coinsToSpend, kvargs, err := InitParams(privateKey, common.PRVIDStr, totalAmount, hasPrivacy, 2)
+	coinsToSpend, kvargs, err := InitParamsWithKeyImages(privateKey, common.PRVIDStr, totalAmount, hasPrivacy, 2, param.inputKeyImages, param.excludedKeyImages)
+	if err != nil {
+		return nil, "", err
+	}
 
-	txParam := tx_generic.NewTxPrivacyInitParams(&(senderWallet.KeySet.PrivateKey), paymentInfos, coinsToSpend, DefaultPRVFee, hasPrivacy, &common.PRVCoinID, param.md, nil, kvargs)
+	txParam := tx_generic.NewTxPrivacyInitParams(&(senderWallet.KeySet.PrivateKey), paymentInfos, coinsToSpend, fee, hasPrivacy, &common.PRVCoinID, param.md, nil, kvargs)
 
 	tx := new(tx_ver2.Tx)
 	err = tx.Init(txParam)
 	if err != nil {
 		return nil, "", errors.New(fmt.Sprintf("init txver2 error: %v", err))
 	}
+	param.inputKeyImages = GetKeyImagesOfCoins(coinsToSpend)
 
 	txBytes, err := json.Marshal(tx)
 	if err != nil {
@@ -199,15 +215,35 @@ func CreateRawConversionTransaction(privateKey string) ([]byte, string, error) {
 }
 
 func CreateAndSendRawTransaction(privateKey string, addrList []string, amountList []uint64, version int8, md metadata.Metadata) (string, error) {
+	return CreateAndSendRawTransactionWithMemos(privateKey, addrList, amountList, nil, version, md)
+}
+
+//CreateAndSendRawTransactionWithMemos creates and sends a PRV transaction in which memoList[i] is encrypted to addrList[i].
+func CreateAndSendRawTransactionWithMemos(privateKey string, addrList []string, amountList []uint64, memoList []string, version int8, md metadata.Metadata) (string, error) {
 	txParam := NewTxParam(privateKey, addrList, amountList, common.PRVIDStr, 0, md)
+	txParam.SetMemoList(memoList)
+	return createAndSendRawTransactionWithParam(txParam, version)
+}
+
+//createAndSendRawTransactionWithParam creates and sends a PRV transaction. Once sent, txParam holds the key images of the spent coins.
+func createAndSendRawTransactionWithParam(txParam *TxParam, version int8) (string, error) {
 	encodedTx, txHash, err := CreateRawTransaction(txParam, version)
 	if err != nil {
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTx(string(encodedTx))
+	var rebuild *TxRebuildInfo
+	if txParam.md == nil {
+		fee := DefaultPRVFee
+		if txParam.fee != 0 {
+			fee = txParam.fee
+		}
+		rebuild = &TxRebuildInfo{Receivers: txParam.receiverList, Amounts: txParam.amountList, Memos: txParam.memoList, Fee: fee, InputKeyImages: txParam.inputKeyImages}
+	}
+
+	responseInBytes, err := sendAndRecordTx(encodedTx, txHash, false, rebuild, "")
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	fmt.Println("SendRawTx:", string(responseInBytes))
@@ -226,7 +262,7 @@ func CreateAndSendRawConversionTransaction(privateKey string) (string, error) {
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTx(string(encodedTx))
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
 	if err != nil {
 		return "", nil
 	}
@@ -20,19 +20,66 @@ import (
 const DefaultPRVFee = uint64(10)
 
 type TxParam struct {
-	senderPrivateKey string
-	receiverList     []string
-	amountList       []uint64
-	tokenID          string
-	txTokenType      int
-	md               metadata.Metadata
-	kvargs           map[string]interface{}
+	senderPrivateKey  string
+	receiverList      []string
+	amountList        []uint64
+	tokenID           string
+	txTokenType       int
+	md                metadata.Metadata
+	kvargs            map[string]interface{}
+	memoList          []string
+	fee               uint64
+	inputKeyImages    []string
+	excludedKeyImages []string
+	prvReceiverList   []string
+	prvAmountList     []uint64
+
+	//inputTokenKeyImages holds the key images of the token coins spent by a token transaction, once created.
+	inputTokenKeyImages []string
 }
 
 func (txParam *TxParam) SetKvargs(kvargs map[string]interface{}) {
 	txParam.kvargs = kvargs
 }
 
+//SetFee sets the PRV fee of the transaction. If not set, DefaultPRVFee is used.
+func (txParam *TxParam) SetFee(fee uint64) {
+	txParam.fee = fee
+}
+
+//SetInputKeyImages forces a PRV transaction to spend exactly the coins with the given key images.
+//
+//Once a PRV transaction is created, it holds the key images of the spent coins.
+func (txParam *TxParam) SetInputKeyImages(keyImages []string) {
+	txParam.inputKeyImages = keyImages
+}
+
+//SetExcludedKeyImages prevents a PRV transaction from spending the coins with the given key images,
+//e.g. the coins spent by transactions of the same sender which are not confirmed yet.
+func (txParam *TxParam) SetExcludedKeyImages(keyImages []string) {
+	txParam.excludedKeyImages = keyImages
+}
+
+//spentKeyImages returns the key images of the coins spent by the created transaction, per token ID.
+func (txParam *TxParam) spentKeyImages() map[string][]string {
+	res := map[string][]string{common.PRVIDStr: txParam.inputKeyImages}
+	if txParam.tokenID != common.PRVIDStr && len(txParam.inputTokenKeyImages) != 0 {
+		res[txParam.tokenID] = txParam.inputTokenKeyImages
+	}
+	return res
+}
+
+//SetPRVReceivers sets the PRV receivers of a token transaction, besides the token receivers.
+func (txParam *TxParam) SetPRVReceivers(prvReceiverList []string, prvAmountList []uint64) {
+	txParam.prvReceiverList = prvReceiverList
+	txParam.prvAmountList = prvAmountList
+}
+
+//SetMemoList sets the memos attached to the receivers, memoList[i] is for receiverList[i]. Empty memos are allowed.
+func (txParam *TxParam) SetMemoList(memoList []string) {
+	txParam.memoList = memoList
+}
+
 func NewTxParam(senderPrivateKey string,
 	receiverList []string, amountList []uint64, tokenID string, txTokenType int, md metadata.Metadata) *TxParam {
 	return &TxParam{
@@ -47,9 +94,19 @@ func NewTxParam(senderPrivateKey string,
 
 //Create payment info lists based on the provided address list and corresponding amount list.
 func CreatePaymentInfos(addrList []string, amountList []uint64) ([]*privacy.PaymentInfo, error) {
+	return CreatePaymentInfosWithMemos(addrList, amountList, nil)
+}
+
+//Create payment info lists based on the provided address list, corresponding amount list and memo list.
+//
+//Each non-empty memo is encrypted to its receiver and stored in the info field of the output coin.
+func CreatePaymentInfosWithMemos(addrList []string, amountList []uint64, memoList []string) ([]*privacy.PaymentInfo, error) {
 	if len(addrList) != len(amountList) {
 		return nil, errors.New(fmt.Sprintf("length of payment address (%v) and length amount (%v) mismatch.", len(addrList), len(amountList)))
 	}
+	if len(memoList) != 0 && len(memoList) != len(addrList) {
+		return nil, errors.New(fmt.Sprintf("length of payment address (%v) and length memo (%v) mismatch.", len(addrList), len(memoList)))
+	}
 
 	paymentInfos := make([]*privacy.PaymentInfo, 0)
 	for i, addr := range addrList {
@@ -57,13 +114,88 @@ func CreatePaymentInfos(addrList []string, amountList []uint64) ([]*privacy.Paym
 		if err != nil {
 			return nil, errors.New(fmt.Sprintf("cannot deserialize key %v: %v", addr, err))
 		}
-		paymentInfo := privacy.PaymentInfo{PaymentAddress: receiverWallet.KeySet.PaymentAddress, Amount: amountList[i], Message: []byte{}}
+
+		message := []byte{}
+		if len(memoList) != 0 && len(memoList[i]) != 0 {
+			message, err = EncryptMemo([]byte(memoList[i]), receiverWallet.KeySet.PaymentAddress)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("cannot encrypt memo for %v: %v", addr, err))
+			}
+		}
+		paymentInfo := privacy.PaymentInfo{PaymentAddress: receiverWallet.KeySet.PaymentAddress, Amount: amountList[i], Message: message}
 		paymentInfos = append(paymentInfos, &paymentInfo)
 	}
 
 	return paymentInfos, nil
 }
 
+//Choose the UTXOs with the given key images. All of them must be found and their total value must cover the required amount.
+func ChooseCoinsByKeyImages(coinList []privacy.PlainCoin, keyImages []string, requiredAmount uint64) ([]privacy.PlainCoin, []uint64, error) {
+	chosenCoins := make([]privacy.PlainCoin, 0)
+	chosenIndices := make([]uint64, 0)
+	totalAmount := uint64(0)
+	for _, keyImage := range keyImages {
+		found := false
+		for i, c := range coinList {
+			if c.GetKeyImage() == nil {
+				continue
+			}
+			if (base58.Base58Check{}).Encode(c.GetKeyImage().ToBytesS(), common.ZeroByte) == keyImage {
+				chosenCoins = append(chosenCoins, c)
+				chosenIndices = append(chosenIndices, uint64(i))
+				totalAmount += c.GetValue()
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, errors.New(fmt.Sprintf("coin with keyImage %v not found or already spent", keyImage))
+		}
+	}
+
+	if totalAmount < requiredAmount {
+		return nil, nil, errors.New(fmt.Sprintf("total value of chosen coins (%v) is less than the required amount (%v)", totalAmount, requiredAmount))
+	}
+
+	return chosenCoins, chosenIndices, nil
+}
+
+//GetKeyImagesOfCoins returns the base58-encoded key images of a list of coins.
+func GetKeyImagesOfCoins(coinList []privacy.PlainCoin) []string {
+	res := make([]string, 0)
+	for _, c := range coinList {
+		if c.GetKeyImage() != nil {
+			res = append(res, base58.Base58Check{}.Encode(c.GetKeyImage().ToBytesS(), common.ZeroByte))
+		}
+	}
+	return res
+}
+
+//excludeCoinsByKeyImages removes the UTXOs with the given key images, along with their indices if any.
+func excludeCoinsByKeyImages(coinList []privacy.PlainCoin, idxList []*big.Int, keyImages []string) ([]privacy.PlainCoin, []*big.Int) {
+	excluded := make(map[string]bool)
+	for _, keyImage := range keyImages {
+		excluded[keyImage] = true
+	}
+
+	resCoins := make([]privacy.PlainCoin, 0)
+	var resIdx []*big.Int
+	if idxList != nil {
+		resIdx = make([]*big.Int, 0)
+	}
+	for i, c := range coinList {
+		if c.GetKeyImage() != nil && excluded[base58.Base58Check{}.Encode(c.GetKeyImage().ToBytesS(), common.ZeroByte)] {
+			continue
+		}
+		resCoins = append(resCoins, c)
+		if idxList != nil {
+			resIdx = append(resIdx, idxList[i])
+		}
+	}
+
+	return resCoins, resIdx
+}
+
 //Choose best UTXOs to spend depending on the provided amount.
 //
 //Assume that the input coins have be sorted in the descending order.
@@ -299,6 +431,17 @@ func GetRandomCommitmentsAndPublicKeys(shardID byte, tokenID string, lenDecoy in
 
 //Query and choose coins to spend + init random params
 func InitParams(privateKey string, tokenIDStr string, totalAmount uint64, hasPrivacy bool, version int) ([]privacy.PlainCoin, map[string]interface{}, error) {
+	return InitParamsWithInputs(privateKey, tokenIDStr, totalAmount, hasPrivacy, version, nil)
+}
+
+//InitParamsWithInputs is the same as InitParams, except that if keyImages is not empty, the UTXOs with these key images are spent
+//instead of the best ones.
+func InitParamsWithInputs(privateKey string, tokenIDStr string, totalAmount uint64, hasPrivacy bool, version int, keyImages []string) ([]privacy.PlainCoin, map[string]interface{}, error) {
+	return InitParamsWithKeyImages(privateKey, tokenIDStr, totalAmount, hasPrivacy, version, keyImages, nil)
+}
+
+//InitParamsWithKeyImages is the same as InitParamsWithInputs, except that the UTXOs with a key image in excludedKeyImages are never spent.
+func InitParamsWithKeyImages(privateKey string, tokenIDStr string, totalAmount uint64, hasPrivacy bool, version int, keyImages, excludedKeyImages []string) ([]privacy.PlainCoin, map[string]interface{}, error) {
 	_, err := new(common.Hash).NewHashFromStr(tokenIDStr)
 	if err != nil {
 		return nil, nil, err
@@ -319,6 +462,10 @@ func InitParams(privateKey string, tokenIDStr string, totalAmount uint64, hasPri
 		return nil, nil, err
 	}
 
+	if len(excludedKeyImages) != 0 {
+		utxoList, idxList = excludeCoinsByKeyImages(utxoList, idxList, excludedKeyImages)
+	}
+
 	fmt.Printf("Finish getting UTXOs for %v of %v. Length of UTXOs: %v\n", totalAmount, tokenIDStr, len(utxoList))
 	coinV1List, coinV2List, idxV2List, err := DivideCoins(utxoList, idxList, true)
 	if err != nil {
@@ -329,7 +476,11 @@ func InitParams(privateKey string, tokenIDStr string, totalAmount uint64, hasPri
 	var kvargs = make(map[string]interface{})
 	if version == 1 {
 		//Choose best coins for creating transactions
-		coinsToSpend, _, err = ChooseBestCoinsByAmount(coinV1List, totalAmount)
+		if len(keyImages) != 0 {
+			coinsToSpend, _, err = ChooseCoinsByKeyImages(coinV1List, keyImages, totalAmount)
+		} else {
+			coinsToSpend, _, err = ChooseBestCoinsByAmount(coinV1List, totalAmount)
+		}
 		if err != nil {
 			return nil, nil, err
 		}
@@ -347,7 +498,11 @@ func InitParams(privateKey string, tokenIDStr string, totalAmount uint64, hasPri
 		return coinsToSpend, kvargs, nil
 	} else {
 		var chosenIdxList []uint64
-		coinsToSpend, chosenIdxList, err = ChooseBestCoinsByAmount(coinV2List, totalAmount)
+		if len(keyImages) != 0 {
+			coinsToSpend, chosenIdxList, err = ChooseCoinsByKeyImages(coinV2List, keyImages, totalAmount)
+		} else {
+			coinsToSpend, chosenIdxList, err = ChooseBestCoinsByAmount(coinV2List, totalAmount)
+		}
 		if err != nil {
 			return nil, nil, err
 		}
@@ -9,7 +9,6 @@ import (
 	"github.com/thanhn-inc/debugtool/privacy"
 	"github.com/thanhn-inc/debugtool/privacy/coin"
 	"github.com/thanhn-inc/debugtool/rpchandler"
-	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
 	"github.com/thanhn-inc/debugtool/transaction/tx_generic"
 	"github.com/thanhn-inc/debugtool/transaction/tx_ver1"
 	"github.com/thanhn-inc/debugtool/transaction/tx_ver2"
@@ -74,7 +73,7 @@ func CreateRawTokenTransactionVer1(txParam *TxParam) ([]byte, string, error) {
 		uniqueReceiver := privacy.PaymentInfo{PaymentAddress: senderWallet.KeySet.PaymentAddress, Amount: totalAmount, Message: []byte{}}
 		tokenReceivers = []*privacy.PaymentInfo{&uniqueReceiver}
 	} else {
-		tokenReceivers, err = CreatePaymentInfos(txParam.receiverList, txParam.amountList)
+		tokenReceivers, err = CreatePaymentInfosWithMemos(txParam.receiverList, txParam.amountList, txParam.memoList)
 		if err != nil {
 			return nil, "", err
 		}
@@ -91,13 +90,26 @@ func CreateRawTokenTransactionVer1(txParam *TxParam) ([]byte, string, error) {
 		hasPrivacyToken = false
 	}
 
+	//Create list of PRV payment infos
+	prvReceivers, err := CreatePaymentInfos(txParam.prvReceiverList, txParam.prvAmountList)
+	if err != nil {
+		return nil, "", err
+	}
+	totalPRVAmount := uint64(0)
+	for _, amount := range txParam.prvAmountList {
+		totalPRVAmount += amount
+	}
+	if hasTokenFee && len(prvReceivers) > 0 {
+		return nil, "", errors.New("cannot pay transaction fee by token when having PRV receivers")
+	}
+
 	//Init PRV fee param when not paying fee by token
 	var coinsPRVToSpend []coin.PlainCoin
 	var kvargsPRV map[string]interface{}
 	prvFee := DefaultPRVFee
 	tokenFee := uint64(0)
 	if !hasTokenFee {
-		coinsPRVToSpend, kvargsPRV, err = InitParams(privateKey, common.PRVIDStr, prvFee, hasPrivacyPRV, 1)
+		coinsPRVToSpend, kvargsPRV, err = InitParams(privateKey, common.PRVIDStr, prvFee+totalPRVAmount, hasPrivacyPRV, 1)
 		if err != nil {
 			return nil, "", err
 		}
@@ -124,12 +136,14 @@ func CreateRawTokenTransactionVer1(txParam *TxParam) ([]byte, string, error) {
 		}
 	}
 	//End init token param
+	txParam.inputKeyImages = GetKeyImagesOfCoins(coinsPRVToSpend)
+	txParam.inputTokenKeyImages = GetKeyImagesOfCoins(coinsTokenToSpend)
 
 	//Create token param for transactions
 	tokenParam := tx_generic.NewTokenParam(tokenIDStr, "", "",
 			totalAmount, txParam.txTokenType, tokenReceivers, coinsTokenToSpend, false, tokenFee, kvargsToken)
 
-	txTokenParam := tx_generic.NewTxTokenParams(&senderWallet.KeySet.PrivateKey, []*privacy.PaymentInfo{}, coinsPRVToSpend, prvFee,
+	txTokenParam := tx_generic.NewTxTokenParams(&senderWallet.KeySet.PrivateKey, prvReceivers, coinsPRVToSpend, prvFee,
 		tokenParam, txParam.md, hasPrivacyPRV, hasPrivacyToken, shardID, nil, kvargsPRV)
 
 	tx := new(tx_ver1.TxToken)
@@ -179,16 +193,26 @@ func CreateRawTokenTransactionVer2(txParam *TxParam) ([]byte, string, error) {
 		uniqueReceiver := privacy.PaymentInfo{PaymentAddress: senderWallet.KeySet.PaymentAddress, Amount: totalAmount, Message: []byte{}}
 		tokenReceivers = []*privacy.PaymentInfo{&uniqueReceiver}
 	} else {
-		tokenReceivers, err = CreatePaymentInfos(txParam.receiverList, txParam.amountList)
+		tokenReceivers, err = CreatePaymentInfosWithMemos(txParam.receiverList, txParam.amountList, txParam.memoList)
 		if err != nil {
 			return nil, "", err
 		}
 	}
 
+	//Create list of PRV payment infos
+	prvReceivers, err := CreatePaymentInfos(txParam.prvReceiverList, txParam.prvAmountList)
+	if err != nil {
+		return nil, "", err
+	}
+	totalPRVAmount := uint64(0)
+	for _, amount := range txParam.prvAmountList {
+		totalPRVAmount += amount
+	}
+
 	prvFee := DefaultPRVFee
 
 	//Init PRV fee param
-	coinsToSpendPRV, kvargsPRV, err := InitParams(privateKey, common.PRVIDStr, prvFee, true, 2)
+	coinsToSpendPRV, kvargsPRV, err := InitParams(privateKey, common.PRVIDStr, prvFee+totalPRVAmount, true, 2)
 	if err != nil {
 		return nil, "", err
 	}
@@ -204,12 +228,14 @@ func CreateRawTokenTransactionVer2(txParam *TxParam) ([]byte, string, error) {
 		}
 	}
 	//End init token param
+	txParam.inputKeyImages = GetKeyImagesOfCoins(coinsToSpendPRV)
+	txParam.inputTokenKeyImages = GetKeyImagesOfCoins(coinsTokenToSpend)
 
 	//Create token param for transactions
 	tokenParam := tx_generic.NewTokenParam(tokenIDStr, "", "",
 			totalAmount, txParam.txTokenType, tokenReceivers, coinsTokenToSpend, false, 0, kvargsToken)
 
-	txTokenParam := tx_generic.NewTxTokenParams(&senderWallet.KeySet.PrivateKey, []*privacy.PaymentInfo{}, coinsToSpendPRV, prvFee,
+	txTokenParam := tx_generic.NewTxTokenParams(&senderWallet.KeySet.PrivateKey, prvReceivers, coinsToSpendPRV, prvFee,
 		tokenParam, txParam.md, true, true, shardID, nil, kvargsPRV)
 
 	tx := new(tx_ver2.TxToken)
@@ -300,19 +326,34 @@ func CreateRawTokenConversionTransaction(privateKey, tokenIDStr string) ([]byte,
 }
 
 func CreateAndSendRawTokenTransaction(privateKey string, addrList []string, amountList []uint64, version int8, tokenIDStr string, hasTokenFee bool) (string, error) {
+	return CreateAndSendRawTokenTransactionWithMemos(privateKey, addrList, amountList, nil, version, tokenIDStr, hasTokenFee)
+}
+
+//CreateAndSendRawTokenTransactionWithMemos creates and sends a token transaction in which memoList[i] is encrypted to addrList[i].
+func CreateAndSendRawTokenTransactionWithMemos(privateKey string, addrList []string, amountList []uint64, memoList []string, version int8, tokenIDStr string, hasTokenFee bool) (string, error) {
+	txParam := newTokenTransferTxParam(privateKey, addrList, amountList, memoList, tokenIDStr, hasTokenFee)
+	return createAndSendRawTokenTransactionWithParam(txParam, version)
+}
+
+func newTokenTransferTxParam(privateKey string, addrList []string, amountList []uint64, memoList []string, tokenIDStr string, hasTokenFee bool) *TxParam {
 	txParam := NewTxParam(privateKey, addrList, amountList, tokenIDStr, 1, nil)
+	txParam.SetMemoList(memoList)
 	kvargs := make(map[string]interface{})
 	kvargs["hasTokenFee"] = hasTokenFee
 	txParam.SetKvargs(kvargs)
+	return txParam
+}
 
+//createAndSendRawTokenTransactionWithParam creates and sends a token transaction. Once sent, txParam holds the key images of the spent coins.
+func createAndSendRawTokenTransactionWithParam(txParam *TxParam, version int8) (string, error) {
 	encodedTx, txHash, err := CreateRawTokenTransaction(txParam, version)
 	if err != nil {
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTokenTx(string(encodedTx))
+	responseInBytes, err := sendRawTokenTx(encodedTx, txHash)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	fmt.Println("SendRawTokenTx:", string(responseInBytes))
@@ -333,7 +374,7 @@ func CreateAndSendRawTokenInitTransaction(privateKey string, addrList []string,
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTokenTx(string(encodedTx))
+	responseInBytes, err := sendRawTokenTx(encodedTx, txHash)
 	if err != nil {
 		return "", nil
 	}
@@ -354,7 +395,7 @@ func CreateAndSendRawTokenConversionTransaction(privateKey string, tokenID strin
 		return "", err
 	}
 
-	responseInBytes, err := rpc.SendRawTokenTx(string(encodedTx))
+	responseInBytes, err := sendRawTokenTx(encodedTx, txHash)
 	if err != nil {
 		return "", nil
 	}
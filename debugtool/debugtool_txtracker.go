@@ -0,0 +1,409 @@
+package debugtool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+)
+
+const (
+	DefaultTrackerPollInterval    = 10 * time.Second
+	DefaultTrackerNotFoundTimeout = 2 * time.Minute
+	DefaultTrackerConfirmations   = 1
+
+	//txNotExistedErrCode and txNotExistedErrMsg identify the error returned by nodes for an unknown transaction.
+	txNotExistedErrCode = -1012
+	txNotExistedErrMsg  = "tx is not existed in mem and block"
+)
+
+//TxStatus is the state of a transaction being watched by a TxTracker.
+type TxStatus int
+
+const (
+	TxStatusUnknown TxStatus = iota
+	TxStatusPending
+	TxStatusInBlock
+	TxStatusConfirmed
+	TxStatusDropped
+	TxStatusRejected
+)
+
+func (s TxStatus) String() string {
+	switch s {
+	case TxStatusPending:
+		return "pending"
+	case TxStatusInBlock:
+		return "inblock"
+	case TxStatusConfirmed:
+		return "confirmed"
+	case TxStatusDropped:
+		return "dropped"
+	case TxStatusRejected:
+		return "rejected"
+	default:
+		return "unknown"
+	}
+}
+
+//IsFinal returns true if no further transitions are expected for the status.
+func (s TxStatus) IsFinal() bool {
+	return s == TxStatusConfirmed || s == TxStatusDropped || s == TxStatusRejected
+}
+
+//TxStatusUpdate describes a state transition of a tracked transaction.
+type TxStatusUpdate struct {
+	TxHash        string
+	Status        TxStatus
+	ShardID       byte
+	BlockHeight   uint64
+	Confirmations uint64
+	Err           error
+	Time          time.Time
+}
+
+func (u TxStatusUpdate) String() string {
+	switch u.Status {
+	case TxStatusInBlock, TxStatusConfirmed:
+		return fmt.Sprintf("tx %v is %v: shard %v, height %v, confirmations %v", u.TxHash, u.Status, u.ShardID, u.BlockHeight, u.Confirmations)
+	case TxStatusRejected:
+		if u.Err != nil {
+			return fmt.Sprintf("tx %v is %v: %v", u.TxHash, u.Status, u.Err)
+		}
+	}
+	return fmt.Sprintf("tx %v is %v", u.TxHash, u.Status)
+}
+
+type trackedTx struct {
+	lastUpdate TxStatusUpdate
+	addedAt    time.Time
+}
+
+//TxTracker watches a set of transactions and reports their state transitions (pending in mempool, in block,
+//confirmed after a number of blocks, dropped from mempool or rejected) through callbacks and/or a channel.
+type TxTracker struct {
+	PollInterval    time.Duration
+	Confirmations   uint64
+	NotFoundTimeout time.Duration
+
+	mtx       sync.Mutex
+	txs       map[string]*trackedTx
+	callbacks []func(TxStatusUpdate)
+	updates   chan TxStatusUpdate
+}
+
+//NewTxTracker creates a TxTracker which considers a transaction confirmed after the given number of blocks.
+func NewTxTracker(confirmations uint64) *TxTracker {
+	if confirmations == 0 {
+		confirmations = DefaultTrackerConfirmations
+	}
+	return &TxTracker{
+		PollInterval:    DefaultTrackerPollInterval,
+		Confirmations:   confirmations,
+		NotFoundTimeout: DefaultTrackerNotFoundTimeout,
+		txs:             make(map[string]*trackedTx),
+	}
+}
+
+//OnStatusChange registers a callback invoked on every state transition. Callbacks are called from the Run goroutine.
+func (t *TxTracker) OnStatusChange(cb func(TxStatusUpdate)) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.callbacks = append(t.callbacks, cb)
+}
+
+//Updates returns a channel on which every state transition is delivered. The channel is closed when Run returns.
+func (t *TxTracker) Updates() <-chan TxStatusUpdate {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.updates == nil {
+		t.updates = make(chan TxStatusUpdate, 100)
+	}
+	return t.updates
+}
+
+//Add starts watching the given transactions.
+func (t *TxTracker) Add(txHashes ...string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, txHash := range txHashes {
+		if _, ok := t.txs[txHash]; ok {
+			continue
+		}
+		t.txs[txHash] = &trackedTx{
+			lastUpdate: TxStatusUpdate{TxHash: txHash, Status: TxStatusUnknown},
+			addedAt:    time.Now(),
+		}
+	}
+}
+
+//Remove stops watching the given transaction.
+func (t *TxTracker) Remove(txHash string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	delete(t.txs, txHash)
+}
+
+//Len returns the number of transactions currently being watched.
+func (t *TxTracker) Len() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return len(t.txs)
+}
+
+//Run polls the network until the context is done. Transactions reaching a final state are no longer watched.
+func (t *TxTracker) Run(ctx context.Context) error {
+	defer func() {
+		t.mtx.Lock()
+		if t.updates != nil {
+			close(t.updates)
+		}
+		t.mtx.Unlock()
+	}()
+
+	ticker := time.NewTicker(t.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		t.Poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+//Poll checks every watched transaction once and emits the resulting state transitions.
+func (t *TxTracker) Poll(ctx context.Context) {
+	t.mtx.Lock()
+	txHashes := make([]string, 0, len(t.txs))
+	for txHash := range t.txs {
+		txHashes = append(txHashes, txHash)
+	}
+	t.mtx.Unlock()
+
+	if len(txHashes) == 0 {
+		return
+	}
+
+	bestBlocks, err := GetBestBlock()
+	if err != nil {
+		fmt.Println("TxTracker: cannot get best blocks:", err)
+		return
+	}
+
+	for _, txHash := range txHashes {
+		if ctx.Err() != nil {
+			return
+		}
+
+		t.mtx.Lock()
+		tx, ok := t.txs[txHash]
+		var snapshot trackedTx
+		if ok {
+			snapshot = *tx
+		}
+		t.mtx.Unlock()
+		if !ok {
+			continue
+		}
+
+		update, err := t.checkTx(txHash, &snapshot, bestBlocks)
+		if err != nil {
+			fmt.Printf("TxTracker: cannot check tx %v: %v\n", txHash, err)
+			continue
+		}
+
+		last := snapshot.lastUpdate
+		if update.Status == last.Status && update.Confirmations == last.Confirmations {
+			continue
+		}
+
+		t.mtx.Lock()
+		tx.lastUpdate = update
+		if update.Status.IsFinal() {
+			delete(t.txs, txHash)
+		}
+		t.mtx.Unlock()
+
+		t.emit(ctx, update)
+	}
+}
+
+func (t *TxTracker) checkTx(txHash string, tx *trackedTx, bestBlocks map[int]uint64) (TxStatusUpdate, error) {
+	update := TxStatusUpdate{TxHash: txHash, Time: time.Now()}
+
+	txDetail, found, err := getTxDetail(txHash)
+	if err != nil {
+		return update, err
+	}
+
+	if !found {
+		switch tx.lastUpdate.Status {
+		case TxStatusPending:
+			update.Status = TxStatusDropped
+		case TxStatusUnknown:
+			if time.Since(tx.addedAt) < t.NotFoundTimeout {
+				update.Status = TxStatusUnknown
+			} else {
+				update.Status = TxStatusRejected
+				update.Err = errors.New(fmt.Sprintf("tx not found after %v", t.NotFoundTimeout))
+			}
+		default:
+			//the tx was in a block before, it must have been reverted.
+			update.Status = TxStatusDropped
+		}
+		return update, nil
+	}
+
+	update.ShardID = txDetail.ShardID
+	if txDetail.IsInMempool {
+		update.Status = TxStatusPending
+		return update, nil
+	}
+	if !txDetail.IsInBlock {
+		update.Status = TxStatusUnknown
+		return update, nil
+	}
+
+	update.BlockHeight = txDetail.BlockHeight
+	if shardHeight, ok := bestBlocks[int(txDetail.ShardID)]; ok && shardHeight >= txDetail.BlockHeight {
+		update.Confirmations = shardHeight - txDetail.BlockHeight + 1
+	}
+
+	if update.Confirmations >= t.Confirmations {
+		update.Status = TxStatusConfirmed
+	} else {
+		update.Status = TxStatusInBlock
+	}
+
+	return update, nil
+}
+
+func (t *TxTracker) emit(ctx context.Context, update TxStatusUpdate) {
+	t.mtx.Lock()
+	callbacks := make([]func(TxStatusUpdate), len(t.callbacks))
+	copy(callbacks, t.callbacks)
+	updates := t.updates
+	t.mtx.Unlock()
+
+	for _, cb := range callbacks {
+		cb(update)
+	}
+
+	if updates != nil {
+		select {
+		case updates <- update:
+		case <-ctx.Done():
+		}
+	}
+}
+
+//WaitForTx blocks until the transaction reaches a final state or the timeout expires. A zero timeout means no timeout.
+//If onUpdate is not nil, it is called on every state transition.
+func WaitForTx(ctx context.Context, txHash string, confirmations uint64, timeout time.Duration, onUpdate func(TxStatusUpdate)) (TxStatusUpdate, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	tracker := NewTxTracker(confirmations)
+	tracker.Add(txHash)
+
+	var mtx sync.Mutex
+	last := TxStatusUpdate{TxHash: txHash, Status: TxStatusUnknown}
+	done := make(chan struct{})
+	tracker.OnStatusChange(func(update TxStatusUpdate) {
+		mtx.Lock()
+		last = update
+		mtx.Unlock()
+		if onUpdate != nil {
+			onUpdate(update)
+		}
+		if update.Status.IsFinal() {
+			close(done)
+		}
+	})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go tracker.Run(runCtx)
+
+	select {
+	case <-done:
+		cancel()
+		if last.Status != TxStatusConfirmed {
+			return last, errors.New(fmt.Sprintf("tx %v is %v", txHash, last.Status))
+		}
+		return last, nil
+	case <-ctx.Done():
+		mtx.Lock()
+		defer mtx.Unlock()
+		return last, errors.New(fmt.Sprintf("stop waiting for tx %v (last status: %v): %v", txHash, last.Status, ctx.Err()))
+	}
+}
+
+//getTxDetail returns the detail of a transaction. The boolean is false if the node does not know the transaction.
+func getTxDetail(txHash string) (*jsonresult.TransactionDetail, bool, error) {
+	return getTxDetailFromServer(rpchandler.Server, txHash)
+}
+
+func getTxDetailFromServer(server *rpchandler.RPCServer, txHash string) (*jsonresult.TransactionDetail, bool, error) {
+	responseInBytes, err := rpc.GetTransactionByHashFromServer(server, txHash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return parseTxDetailResponse(responseInBytes)
+}
+
+//parseTxDetailResponse parses a gettransactionbyhash response. Only the node's tx-not-found error means the transaction is unknown,
+//any other RPC error is returned so that callers retry instead of considering the transaction dropped.
+func parseTxDetailResponse(responseInBytes []byte) (*jsonresult.TransactionDetail, bool, error) {
+	var response rpchandler.JsonResponse
+	err := json.Unmarshal(responseInBytes, &response)
+	if err != nil {
+		return nil, false, err
+	}
+	if response.Error != nil {
+		if isTxNotFoundError(response.Error) {
+			return nil, false, nil
+		}
+		return nil, false, errors.New(fmt.Sprintf("RPC returns an error: %v", response.Error))
+	}
+	if len(response.Result) == 0 || string(response.Result) == "null" {
+		return nil, false, nil
+	}
+
+	var txDetail jsonresult.TransactionDetail
+	err = json.Unmarshal(response.Result, &txDetail)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &txDetail, true, nil
+}
+
+//isTxNotFoundError checks if an RPC error is the node's error for a transaction neither in the mempool nor in a block.
+func isTxNotFoundError(rpcErr *rpchandler.RPCError) bool {
+	if rpcErr.Code == txNotExistedErrCode {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(rpcErr.Message), txNotExistedErrMsg)
+}
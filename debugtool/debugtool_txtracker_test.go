@@ -0,0 +1,104 @@
+package debugtool
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thanhn-inc/debugtool/rpchandler"
+)
+
+func TestParseTxDetailResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		wantFound bool
+		wantErr   bool
+	}{
+		{
+			name:      "tx in block",
+			response:  `{"Id":1,"Result":{"Hash":"abc","ShardID":1,"BlockHeight":10,"IsInBlock":true},"Error":null}`,
+			wantFound: true,
+		},
+		{
+			name:     "tx not found by code",
+			response: `{"Id":1,"Result":null,"Error":{"Code":-1012,"Message":"Tx is not existed in mem and block"}}`,
+		},
+		{
+			name:     "tx not found by message",
+			response: `{"Id":1,"Result":null,"Error":{"Code":-1,"Message":"Tx is not existed in mem and block"}}`,
+		},
+		{
+			name:     "null result",
+			response: `{"Id":1,"Result":null,"Error":null}`,
+		},
+		{
+			name:     "transient error",
+			response: `{"Id":1,"Result":null,"Error":{"Code":-1,"Message":"Unexpected error"}}`,
+			wantErr:  true,
+		},
+		{
+			name:     "timeout wrapped as an rpc error",
+			response: `{"Id":1,"Result":null,"Error":{"Code":-32000,"Message":"context deadline exceeded"}}`,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid json",
+			response: `<html>502 Bad Gateway</html>`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txDetail, found, err := parseTxDetailResponse([]byte(tt.response))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if found != tt.wantFound {
+				t.Fatalf("found = %v, want %v", found, tt.wantFound)
+			}
+			if found && txDetail.Hash != "abc" {
+				t.Fatalf("txDetail.Hash = %v, want abc", txDetail.Hash)
+			}
+		})
+	}
+}
+
+func TestTxTrackerConcurrentPolls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "getbestblock") {
+			fmt.Fprint(w, `{"Id":1,"Result":{"BestBlocks":{"-1":{"Height":100},"0":{"Height":20}}},"Error":null}`)
+			return
+		}
+		fmt.Fprint(w, `{"Id":1,"Result":{"Hash":"abc","ShardID":0,"BlockHeight":10,"IsInBlock":true},"Error":null}`)
+	}))
+	defer server.Close()
+
+	oldURL := rpchandler.Server.GetURL()
+	rpchandler.Server.InitToURL(server.URL)
+	defer rpchandler.Server.InitToURL(oldURL)
+
+	tracker := NewTxTracker(5)
+	tracker.Add("abc", "def")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.Poll(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	if tracker.Len() != 0 {
+		t.Fatalf("tracker.Len() = %v, want 0", tracker.Len())
+	}
+}
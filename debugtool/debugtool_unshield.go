@@ -0,0 +1,352 @@
+package debugtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	rCommon "github.com/ethereum/go-ethereum/common"
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/metadata"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+const (
+	DefaultBurnProofTimeout = 30 * time.Minute
+
+	burnProofInterval = 20 * time.Second
+
+	//burnInstLength is the length of a burning confirmation instruction:
+	//meta (1) | shard (1) | token (32) | remote address (32) | amount (32) | txID (32) | incTokenID (32) | beacon height (32).
+	burnInstLength = 2 + 6*32
+)
+
+//ETHWithdrawProof is a burn proof decoded into the arguments of the `withdraw` function of the Incognito vault.
+type ETHWithdrawProof struct {
+	Instruction     []byte
+	Height          *big.Int
+	InstPaths       [][32]byte
+	InstPathIsLefts []bool
+	InstRoot        [32]byte
+	BlkData         [32]byte
+	SigIdxs         []*big.Int
+	SigVs           []uint8
+	SigRs           [][32]byte
+	SigSs           [][32]byte
+}
+
+//Token returns the address of the ETH/ERC20 token withdrawn, read from the instruction.
+func (p ETHWithdrawProof) Token() rCommon.Address {
+	return rCommon.BytesToAddress(p.Instruction[2:34])
+}
+
+//Receiver returns the ETH address receiving the withdrawal, read from the instruction.
+func (p ETHWithdrawProof) Receiver() rCommon.Address {
+	return rCommon.BytesToAddress(p.Instruction[34:66])
+}
+
+//Amount returns the withdrawn amount, in the smallest unit of the token, read from the instruction.
+func (p ETHWithdrawProof) Amount() *big.Int {
+	return new(big.Int).SetBytes(p.Instruction[66:98])
+}
+
+func (p ETHWithdrawProof) String() string {
+	return fmt.Sprintf("withdrawal of %v %v to %v, beacon height %v, %v signatures", p.Amount(), p.Token().String(),
+		p.Receiver().String(), p.Height, len(p.SigIdxs))
+}
+
+//Calldata returns the calldata of the `withdraw` function of the Incognito vault for this proof.
+func (p ETHWithdrawProof) Calldata() ([]byte, error) {
+	abiIns, err := abi.JSON(strings.NewReader(common.AbiJson))
+	if err != nil {
+		return nil, err
+	}
+
+	return abiIns.Pack("withdraw", p.Instruction, p.Height, p.InstPaths, p.InstPathIsLefts, p.InstRoot, p.BlkData,
+		p.SigIdxs, p.SigVs, p.SigRs, p.SigSs)
+}
+
+//CreateBurningRequestTransaction creates a request burning amount of a bridged ETH/ERC20 token to unshield it to remoteAddr,
+//an Ethereum address.
+func CreateBurningRequestTransaction(privateKey, tokenIDStr string, amount uint64, remoteAddr string) ([]byte, string, error) {
+	if !rCommon.IsHexAddress(remoteAddr) {
+		return nil, "", errors.New(fmt.Sprintf("invalid eth address %v", remoteAddr))
+	}
+	if amount == 0 {
+		return nil, "", errors.New("burning amount must be positive")
+	}
+
+	tokenID, err := new(common.Hash).NewHashFromStr(tokenIDStr)
+	if err != nil {
+		return nil, "", err
+	}
+	isBridgeToken, err := isETHBridgeToken(tokenIDStr)
+	if err != nil {
+		return nil, "", err
+	}
+	if !isBridgeToken {
+		return nil, "", errors.New(fmt.Sprintf("token %v is not an ETH bridge token", tokenIDStr))
+	}
+
+	senderWallet, err := wallet.Base58CheckDeserialize(privateKey)
+	if err != nil {
+		return nil, "", err
+	}
+	remoteAddrStr := hex.EncodeToString(rCommon.HexToAddress(remoteAddr).Bytes())
+
+	md, err := metadata.NewBurningRequest(senderWallet.KeySet.PaymentAddress, amount, *tokenID, tokenIDStr, remoteAddrStr, metadata.BurningRequestMetaV2)
+	if err != nil {
+		return nil, "", err
+	}
+
+	txParam := NewTxParam(privateKey, []string{common.BurningAddress2}, []uint64{amount}, tokenIDStr, 1, md)
+
+	return CreateRawTokenTransaction(txParam, -1)
+}
+
+func CreateAndSendBurningRequestTransaction(privateKey, tokenIDStr string, amount uint64, remoteAddr string) (string, error) {
+	encodedTx, txHash, err := CreateBurningRequestTransaction(privateKey, tokenIDStr, amount, remoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	responseInBytes, err := sendRawTokenTx(encodedTx, txHash)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return txHash, nil
+}
+
+//GetBurnProof returns the burn proof of a burning request, decoded for the vault. It fails until the beacon chain
+//has included the burning instruction.
+func GetBurnProof(txHash string) (*ETHWithdrawProof, error) {
+	responseInBytes, err := rpc.GetBurnProof(txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var res jsonresult.InstructionProof
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeBurnProof(&res)
+}
+
+//WaitForBurnProof polls the burn proof of a burning request until the beacon chain includes it, ctx is done or timeout elapses.
+func WaitForBurnProof(ctx context.Context, txHash string, timeout time.Duration) (*ETHWithdrawProof, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		proof, err := GetBurnProof(txHash)
+		if err == nil {
+			return proof, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.New(fmt.Sprintf("burn proof of %v not available: %v (last error: %v)", txHash, ctx.Err(), err))
+		case <-time.After(burnProofInterval):
+		}
+	}
+}
+
+//DecodeBurnProof decodes the hex-encoded beacon proof of a burning instruction.
+func DecodeBurnProof(proof *jsonresult.InstructionProof) (*ETHWithdrawProof, error) {
+	inst, err := hex.DecodeString(proof.Instruction)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot decode instruction: %v", err))
+	}
+	if len(inst) < burnInstLength {
+		return nil, errors.New(fmt.Sprintf("invalid burning instruction length %v", len(inst)))
+	}
+
+	height, err := hex.DecodeString(proof.BeaconHeight)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot decode beacon height: %v", err))
+	}
+
+	if len(proof.BeaconInstPath) != len(proof.BeaconInstPathIsLeft) {
+		return nil, errors.New(fmt.Sprintf("length of inst path (%v) and length of inst path direction (%v) mismatch",
+			len(proof.BeaconInstPath), len(proof.BeaconInstPathIsLeft)))
+	}
+	instPaths := make([][32]byte, 0)
+	for _, path := range proof.BeaconInstPath {
+		node, err := decodeHash32(path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot decode inst path: %v", err))
+		}
+		instPaths = append(instPaths, node)
+	}
+
+	instRoot, err := decodeHash32(proof.BeaconInstRoot)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot decode inst root: %v", err))
+	}
+	blkData, err := decodeHash32(proof.BeaconBlkData)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot decode block data: %v", err))
+	}
+
+	if len(proof.BeaconSigs) != len(proof.BeaconSigIdxs) {
+		return nil, errors.New(fmt.Sprintf("length of signatures (%v) and length of signer indices (%v) mismatch",
+			len(proof.BeaconSigs), len(proof.BeaconSigIdxs)))
+	}
+	res := &ETHWithdrawProof{
+		Instruction:     inst,
+		Height:          new(big.Int).SetBytes(height),
+		InstPaths:       instPaths,
+		InstPathIsLefts: proof.BeaconInstPathIsLeft,
+		InstRoot:        instRoot,
+		BlkData:         blkData,
+		SigIdxs:         make([]*big.Int, 0),
+		SigVs:           make([]uint8, 0),
+		SigRs:           make([][32]byte, 0),
+		SigSs:           make([][32]byte, 0),
+	}
+	for i, sigStr := range proof.BeaconSigs {
+		sig, err := hex.DecodeString(sigStr)
+		if err != nil || len(sig) != 65 {
+			return nil, errors.New(fmt.Sprintf("invalid signature %v", sigStr))
+		}
+
+		var r, s [32]byte
+		copy(r[:], sig[:32])
+		copy(s[:], sig[32:64])
+		res.SigRs = append(res.SigRs, r)
+		res.SigSs = append(res.SigSs, s)
+		res.SigVs = append(res.SigVs, sig[64]+27)
+		res.SigIdxs = append(res.SigIdxs, big.NewInt(int64(proof.BeaconSigIdxs[i])))
+	}
+
+	return res, nil
+}
+
+//SendETHWithdrawTransaction sends the `withdraw` call of a proof to the Incognito vault from sender, an account unlocked at
+//the EVM endpoint url. It returns the hash of the ETH transaction.
+func SendETHWithdrawTransaction(url, sender string, proof *ETHWithdrawProof) (string, error) {
+	if !rCommon.IsHexAddress(sender) {
+		return "", errors.New(fmt.Sprintf("invalid eth address %v", sender))
+	}
+
+	calldata, err := proof.Calldata()
+	if err != nil {
+		return "", err
+	}
+
+	responseInBytes, err := rpc.ETHSendTransaction(url, sender, common.EthContractAddressStr, "0x"+hex.EncodeToString(calldata))
+	if err != nil {
+		return "", err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return "", err
+	}
+
+	var res string
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return "", err
+	}
+
+	return res, nil
+}
+
+func isETHBridgeToken(tokenIDStr string) (bool, error) {
+	bridgeTokens, err := GetAllBridgeTokens()
+	if err != nil {
+		return false, err
+	}
+
+	for _, token := range bridgeTokens {
+		if token.TokenID == tokenIDStr {
+			return !token.IsCentralized && len(token.ExternalTokenID) == rCommon.AddressLength, nil
+		}
+	}
+
+	return false, nil
+}
+
+func decodeHash32(hashStr string) ([32]byte, error) {
+	var res [32]byte
+
+	hashBytes, err := hex.DecodeString(hashStr)
+	if err != nil {
+		return res, err
+	}
+	if len(hashBytes) != 32 {
+		return res, errors.New(fmt.Sprintf("expect 32 bytes, got %v", len(hashBytes)))
+	}
+	copy(res[:], hashBytes)
+
+	return res, nil
+}
+
+//checkBurnProofReceiver checks that a proof withdraws to remoteAddr.
+func checkBurnProofReceiver(proof *ETHWithdrawProof, remoteAddr string) error {
+	if !bytes.Equal(proof.Receiver().Bytes(), rCommon.HexToAddress(remoteAddr).Bytes()) {
+		return errors.New(fmt.Sprintf("the proof withdraws to %v, expect %v", proof.Receiver().String(), remoteAddr))
+	}
+
+	return nil
+}
+
+//Unshield burns amount of a bridged ETH/ERC20 token, waits for the burning request to be confirmed and for the beacon chain
+//to include it, and returns the hash of the request together with its burn proof. The steps are reported through onUpdate.
+func Unshield(ctx context.Context, privateKey, tokenIDStr string, amount uint64, remoteAddr string, onUpdate func(msg string)) (string, *ETHWithdrawProof, error) {
+	emit := func(msg string) {
+		if onUpdate != nil {
+			onUpdate(msg)
+		}
+	}
+
+	txHash, err := CreateAndSendBurningRequestTransaction(privateKey, tokenIDStr, amount, remoteAddr)
+	if err != nil {
+		return "", nil, err
+	}
+	emit(fmt.Sprintf("burning request sent: %v", txHash))
+
+	_, err = WaitForTx(ctx, txHash, 1, DefaultBurnProofTimeout, func(update TxStatusUpdate) {
+		emit(update.String())
+	})
+	if err != nil {
+		return txHash, nil, err
+	}
+
+	emit("waiting for the beacon chain to include the burning instruction...")
+	proof, err := WaitForBurnProof(ctx, txHash, DefaultBurnProofTimeout)
+	if err != nil {
+		return txHash, nil, err
+	}
+	err = checkBurnProofReceiver(proof, remoteAddr)
+	if err != nil {
+		return txHash, nil, err
+	}
+	emit(fmt.Sprintf("burn proof received: %v", proof))
+
+	return txHash, proof, nil
+}
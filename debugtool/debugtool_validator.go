@@ -0,0 +1,484 @@
+package debugtool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/common/base58"
+	"github.com/thanhn-inc/debugtool/incognitokey"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/rpchandler/jsonresult"
+	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+const (
+	ValidatorRoleNone      = "none"
+	ValidatorRoleWaiting   = "waiting"
+	ValidatorRolePending   = "pending"
+	ValidatorRoleCommittee = "committee"
+
+	//BeaconChainID is the chain ID of the beacon chain in a ValidatorStatus.
+	BeaconChainID = -1
+
+	DefaultValidatorWatchInterval = 30 * time.Second
+)
+
+//ValidatorStatus is the state of a committee key on the network at a beacon height.
+type ValidatorStatus struct {
+	CommitteeKey string
+	BLSPublicKey string
+	BeaconHeight uint64
+	Epoch        uint64
+
+	Role    string
+	ChainID int //BeaconChainID for the beacon chain, the shard ID otherwise
+	//WaitingForNextRandom is true if a waiting candidate will only be assigned at the random number of the next epoch.
+	WaitingForNextRandom bool
+
+	//Staked is true if the key has an auto-staking flag on the beacon chain, i.e. it is staked and has not been unstaked yet.
+	Staked      bool
+	AutoStaking bool
+
+	RewardPublicKey string
+	Rewards         map[string]uint64
+
+	Blacklisted    bool
+	PunishedEpochs uint8
+}
+
+func (status ValidatorStatus) String() string {
+	s := "========== VALIDATOR STATUS ==========\n"
+	s += fmt.Sprintf("Committee key: %v\n", status.CommitteeKey)
+	s += fmt.Sprintf("BLS public key: %v\n", status.BLSPublicKey)
+	s += fmt.Sprintf("Beacon height: %v, epoch: %v\n", status.BeaconHeight, status.Epoch)
+
+	role := status.Role
+	if status.Role != ValidatorRoleNone {
+		if status.ChainID == BeaconChainID {
+			role += " (beacon)"
+		} else if status.Role == ValidatorRoleWaiting {
+			//Shard candidates are assigned to a shard by the random number.
+			role += " (shard)"
+		} else {
+			role += fmt.Sprintf(" (shard %v)", status.ChainID)
+		}
+	}
+	if status.Role == ValidatorRoleWaiting {
+		if status.WaitingForNextRandom {
+			role += ", waiting for the next random number"
+		} else {
+			role += ", waiting for the current random number"
+		}
+	}
+	s += fmt.Sprintf("Role: %v\n", role)
+	s += fmt.Sprintf("Staked: %v, auto re-staking: %v\n", status.Staked, status.AutoStaking)
+
+	s += fmt.Sprintf("Rewards of %v:\n", status.RewardPublicKey)
+	if len(status.Rewards) == 0 {
+		s += "\tnone\n"
+	}
+	tokenIDs := make([]string, 0)
+	for tokenID := range status.Rewards {
+		tokenIDs = append(tokenIDs, tokenID)
+	}
+	sort.Strings(tokenIDs)
+	for _, tokenID := range tokenIDs {
+		s += fmt.Sprintf("\t%v: %v\n", tokenID, status.Rewards[tokenID])
+	}
+
+	if status.Blacklisted {
+		s += fmt.Sprintf("Slashed: blacklisted for %v more epoch(s)\n", status.PunishedEpochs)
+	} else {
+		s += "Slashed: no\n"
+	}
+	s += "========== END VALIDATOR STATUS =========="
+	return s
+}
+
+//ResolveCommitteeKey returns the committee key of a candidate of the validator keystore, given by its payment address or private key.
+//Any other value must be a base58-encoded committee key, and is returned as is.
+func ResolveCommitteeKey(candidate string) (string, error) {
+	keystore, err := LoadValidatorKeystore(DefaultValidatorKeystoreFile)
+	if err != nil {
+		return "", err
+	}
+	if key, err := keystore.Get(candidate); err == nil {
+		return key.CommitteeKey, nil
+	}
+
+	committeePK := new(incognitokey.CommitteePublicKey)
+	err = committeePK.FromString(candidate)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%v is neither a candidate of the validator keystore nor a committee key", candidate))
+	}
+
+	return candidate, nil
+}
+
+//GetValidatorStatus combines the committee, role, auto-staking, reward and slashing RPCs into the status of a committee key.
+//Rewards are those of rewardReceiverAddr if given, of the candidate otherwise.
+func GetValidatorStatus(committeeKey, rewardReceiverAddr string) (*ValidatorStatus, error) {
+	committeePK := new(incognitokey.CommitteePublicKey)
+	err := committeePK.FromString(committeeKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid committee key %v: %v", committeeKey, err))
+	}
+
+	beaconHeight, epoch, err := getBeaconHeightAndEpoch()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ValidatorStatus{
+		CommitteeKey: committeeKey,
+		BLSPublicKey: committeePK.GetMiningKeyBase58(common.BlsConsensus),
+		BeaconHeight: beaconHeight,
+		Epoch:        epoch,
+		Role:         ValidatorRoleNone,
+	}
+
+	err = status.updateRole(committeePK)
+	if err != nil {
+		return nil, err
+	}
+
+	autoStaking, err := getAutoStakingByHeight(beaconHeight)
+	if err != nil {
+		return nil, err
+	}
+	status.AutoStaking, status.Staked = autoStaking[committeeKey]
+
+	status.RewardPublicKey = committeePK.GetIncKeyBase58()
+	if len(rewardReceiverAddr) != 0 {
+		status.RewardPublicKey, err = getPublicKeyFromAddress(rewardReceiverAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	status.Rewards, err = GetRewardAmountByPublicKey(status.RewardPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	blacklist, err := GetProducersBlackList(beaconHeight)
+	if err != nil {
+		return nil, err
+	}
+	status.PunishedEpochs, status.Blacklisted = blacklist[committeeKey]
+
+	return status, nil
+}
+
+//WatchValidatorStatus calls onUpdate with the status of a committee key now, then at every new epoch, until ctx is done.
+//The beacon epoch is checked every pollInterval; errors are passed to onUpdate and retried at the next check.
+func WatchValidatorStatus(ctx context.Context, committeeKey, rewardReceiverAddr string, pollInterval time.Duration,
+	onUpdate func(*ValidatorStatus, error)) {
+	if pollInterval <= 0 {
+		pollInterval = DefaultValidatorWatchInterval
+	}
+
+	lastEpoch := uint64(0)
+	for {
+		_, epoch, err := getBeaconHeightAndEpoch()
+		if err != nil {
+			onUpdate(nil, err)
+		} else if epoch != lastEpoch {
+			status, err := GetValidatorStatus(committeeKey, rewardReceiverAddr)
+			onUpdate(status, err)
+			if err == nil {
+				lastEpoch = epoch
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+//updateRole finds the key in the candidate and committee lists. Keys missing from the lists are looked up with the role RPCs,
+//which also know keys being swapped in or out.
+func (status *ValidatorStatus) updateRole(committeePK *incognitokey.CommitteePublicKey) error {
+	candidates, err := GetCandidateList()
+	if err != nil {
+		return err
+	}
+	committees, err := GetCommitteeList()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case containsKey(candidates.CandidateBeaconWaitingForCurrentRandom, status.CommitteeKey):
+		status.Role, status.ChainID = ValidatorRoleWaiting, BeaconChainID
+	case containsKey(candidates.CandidateBeaconWaitingForNextRandom, status.CommitteeKey):
+		status.Role, status.ChainID, status.WaitingForNextRandom = ValidatorRoleWaiting, BeaconChainID, true
+	case containsKey(candidates.CandidateShardWaitingForCurrentRandom, status.CommitteeKey):
+		status.Role = ValidatorRoleWaiting
+	case containsKey(candidates.CandidateShardWaitingForNextRandom, status.CommitteeKey):
+		status.Role, status.WaitingForNextRandom = ValidatorRoleWaiting, true
+	case containsKey(committees.BeaconPendingValidator, status.CommitteeKey):
+		status.Role, status.ChainID = ValidatorRolePending, BeaconChainID
+	case containsKey(committees.BeaconCommittee, status.CommitteeKey):
+		status.Role, status.ChainID = ValidatorRoleCommittee, BeaconChainID
+	}
+	for shardID, keys := range committees.ShardPendingValidator {
+		if containsKey(keys, status.CommitteeKey) {
+			status.Role, status.ChainID = ValidatorRolePending, int(shardID)
+		}
+	}
+	for shardID, keys := range committees.ShardCommittee {
+		if containsKey(keys, status.CommitteeKey) {
+			status.Role, status.ChainID = ValidatorRoleCommittee, int(shardID)
+		}
+	}
+	if status.Role != ValidatorRoleNone {
+		return nil
+	}
+
+	role, chainID, err := GetIncognitoPublicKeyRole(committeePK.GetIncKeyBase58())
+	if err != nil {
+		role, chainID, err = GetPublicKeyRole(fmt.Sprintf("%v:%v", common.BlsConsensus, status.BLSPublicKey))
+		if err != nil {
+			return err
+		}
+	}
+	status.Role, status.ChainID = role, chainID
+
+	return nil
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func getBeaconHeightAndEpoch() (uint64, uint64, error) {
+	responseInBytes, err := rpc.GetBestBlock()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var bestBlocksResult jsonresult.GetBestBlockResult
+	err = json.Unmarshal(response.Result, &bestBlocksResult)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	beaconBlock, ok := bestBlocksResult.BestBlocks[-1]
+	if !ok {
+		return 0, 0, errors.New("beacon best block not found")
+	}
+
+	return beaconBlock.Height, beaconBlock.Epoch, nil
+}
+
+func getPublicKeyFromAddress(addr string) (string, error) {
+	keyWallet, err := wallet.Base58CheckDeserialize(addr)
+	if err != nil {
+		return "", err
+	}
+	return base58.Base58Check{}.Encode(keyWallet.KeySet.PaymentAddress.Pk, common.ZeroByte), nil
+}
+
+//GetCandidateList returns the candidates waiting for a random number.
+func GetCandidateList() (*jsonresult.CandidateListsResult, error) {
+	responseInBytes, err := rpc.GetCandidateList()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var res jsonresult.CandidateListsResult
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+//GetCommitteeList returns the committees and pending validators of every chain.
+func GetCommitteeList() (*jsonresult.CommitteeListsResult, error) {
+	responseInBytes, err := rpc.GetCommitteeList()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var res jsonresult.CommitteeListsResult
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+//GetIncognitoPublicKeyRole returns the role and the chain ID of a base58-encoded incognito public key.
+func GetIncognitoPublicKeyRole(incPubKey string) (string, int, error) {
+	responseInBytes, err := rpc.GetIncognitoPublicKeyRole(incPubKey)
+	if err != nil {
+		return "", 0, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var res struct {
+		Role    int
+		ChainID int
+	}
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch res.Role {
+	case 0:
+		return ValidatorRoleWaiting, res.ChainID, nil
+	case 1:
+		return ValidatorRolePending, res.ChainID, nil
+	case 2:
+		return ValidatorRoleCommittee, res.ChainID, nil
+	}
+	return ValidatorRoleNone, res.ChainID, nil
+}
+
+//GetPublicKeyRole returns the role and the chain ID of a mining key, given as "<consensus>:<base58 key>".
+func GetPublicKeyRole(miningKey string) (string, int, error) {
+	responseInBytes, err := rpc.GetPublicKeyRole(miningKey)
+	if err != nil {
+		return "", 0, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var res struct {
+		Role    string
+		ShardID int
+	}
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch res.Role {
+	case ValidatorRoleWaiting, ValidatorRolePending, ValidatorRoleCommittee:
+		return res.Role, res.ShardID, nil
+	}
+	return ValidatorRoleNone, res.ShardID, nil
+}
+
+//getAutoStakingByHeight returns the auto-staking flags of the staked committee keys at a beacon height.
+func getAutoStakingByHeight(beaconHeight uint64) (map[string]bool, error) {
+	responseInBytes, err := rpc.GetAutoStakingByHeight(beaconHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	//Depending on the node version, the flags are the result itself, or one of the items of the result.
+	var res map[string]bool
+	if err = json.Unmarshal(response.Result, &res); err == nil {
+		return res, nil
+	}
+
+	var items []json.RawMessage
+	err = json.Unmarshal(response.Result, &items)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse auto-staking flags: %v", err))
+	}
+	for _, item := range items {
+		if err = json.Unmarshal(item, &res); err == nil {
+			return res, nil
+		}
+	}
+
+	return nil, errors.New("auto-staking flags not found in the response")
+}
+
+//GetRewardAmountByPublicKey returns the rewards, per token ID, of a base58-encoded public key. Tokens without reward may be listed with a zero amount.
+func GetRewardAmountByPublicKey(publicKey string) (map[string]uint64, error) {
+	responseInBytes, err := rpc.GetRewardAmountByPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var rewards map[string]uint64
+	err = json.Unmarshal(response.Result, &rewards)
+	if err != nil {
+		return nil, err
+	}
+
+	//Nodes report the PRV reward as "PRV".
+	res := make(map[string]uint64)
+	for tokenID, amount := range rewards {
+		if tokenID == "PRV" {
+			tokenID = common.PRVIDStr
+		}
+		res[tokenID] += amount
+	}
+
+	return res, nil
+}
+
+//GetProducersBlackList returns the slashed committee keys at a beacon height, with their remaining punished epochs.
+func GetProducersBlackList(beaconHeight uint64) (map[string]uint8, error) {
+	responseInBytes, err := rpc.GetProducersBlackList(beaconHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rpchandler.ParseResponse(responseInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var res map[string]uint8
+	err = json.Unmarshal(response.Result, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
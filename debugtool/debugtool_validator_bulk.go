@@ -0,0 +1,531 @@
+package debugtool
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/metadata"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+	"github.com/thanhn-inc/debugtool/wallet"
+)
+
+const (
+	BulkStake    = "stake"
+	BulkUnstake  = "unstake"
+	BulkWithdraw = "withdraw"
+
+	BulkRowConfirmed = "confirmed"
+	BulkRowSent      = "sent" //sent, but not confirmed before the timeout
+	BulkRowFailed    = "failed"
+	BulkRowCancelled = "cancelled"
+	BulkRowSkipped   = "skipped"
+
+	DefaultBulkConcurrency    = 4
+	DefaultBulkConfirmTimeout = 15 * time.Minute
+)
+
+//ValidatorManifestRow is a line of a validator manifest.
+type ValidatorManifestRow struct {
+	Line           int
+	FunderKey      string
+	Candidate      string
+	MiningSeed     string
+	RewardReceiver string
+	AutoStake      bool
+	StakingType    int
+}
+
+//BulkRowResult is the outcome of a bulk operation for a line of the manifest.
+type BulkRowResult struct {
+	Line      int
+	Funder    string
+	Candidate string
+	Status    string
+	TxHash    string `json:",omitempty"` //comma-separated when the row sent several transactions
+	Error     string `json:",omitempty"`
+}
+
+func (res BulkRowResult) String() string {
+	s := fmt.Sprintf("line %v, candidate %v: %v", res.Line, res.Candidate, res.Status)
+	if len(res.TxHash) != 0 {
+		s += fmt.Sprintf(", tx %v", res.TxHash)
+	}
+	if len(res.Error) != 0 {
+		s += fmt.Sprintf(", %v", res.Error)
+	}
+	return s
+}
+
+//BulkReport is the result of a bulk operation, one entry per line of the manifest.
+type BulkReport struct {
+	Operation  string
+	File       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Results    []*BulkRowResult
+}
+
+func (report BulkReport) String() string {
+	s := fmt.Sprintf("========== %v REPORT ==========\n", strings.ToUpper(report.Operation))
+	s += fmt.Sprintf("Manifest: %v, duration: %v\n", report.File, report.FinishedAt.Sub(report.StartedAt).Round(time.Second))
+	for _, res := range report.Results {
+		s += fmt.Sprintf("\t%v\n", res)
+	}
+	count := report.Summary()
+	s += fmt.Sprintf("Confirmed: %v, sent: %v, failed: %v, skipped: %v, cancelled: %v\n",
+		count[BulkRowConfirmed], count[BulkRowSent], count[BulkRowFailed], count[BulkRowSkipped], count[BulkRowCancelled])
+	s += fmt.Sprintf("========== END %v REPORT ==========", strings.ToUpper(report.Operation))
+	return s
+}
+
+//Summary returns the number of rows for each status.
+func (report BulkReport) Summary() map[string]int {
+	count := make(map[string]int)
+	for _, res := range report.Results {
+		count[res.Status]++
+	}
+	return count
+}
+
+//Save writes the report to a file. The file is replaced atomically.
+func (report BulkReport) Save(fileName string) error {
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, fileName)
+}
+
+//BulkOptions configures a bulk operation.
+type BulkOptions struct {
+	//Concurrency is the maximum number of rows processed at the same time, DefaultBulkConcurrency if 0.
+	Concurrency int
+	//ConfirmTimeout is how long to wait for each transaction to be confirmed, DefaultBulkConfirmTimeout if 0.
+	ConfirmTimeout time.Duration
+	//MiningSeed returns the mining seed of a candidate whose seed is not in the manifest. Seeds are resolved one at a time,
+	//before any transaction is sent.
+	MiningSeed func(row *ValidatorManifestRow) (string, error)
+	//OnResult, if not nil, is called when the result of a row is known. Calls may be concurrent.
+	OnResult func(*BulkRowResult)
+}
+
+//ParseValidatorManifest reads a CSV file in which each line is
+//`FUNDER_PRIVATE_KEY,CANDIDATE,MINING_SEED,REWARD_RECEIVER,AUTO_STAKE[,STAKING_TYPE]`. A header line starting with `funder` is allowed.
+//
+//An empty CANDIDATE or REWARD_RECEIVER is the funder address, an empty MINING_SEED is resolved by BulkOptions.MiningSeed,
+//an empty AUTO_STAKE is true and STAKING_TYPE is `shard` (default) or `beacon`. The function fails if any line is invalid.
+func ParseValidatorManifest(fileName string) ([]*ValidatorManifestRow, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows := make([]*ValidatorManifestRow, 0)
+	invalidLines := make([]string, 0)
+	candidates := make(map[string]int)
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("cannot read line %v of %v: %v", line, fileName, err))
+		}
+		if len(record) == 0 || (len(record) == 1 && len(strings.TrimSpace(record[0])) == 0) {
+			continue
+		}
+		if line == 1 && strings.HasPrefix(strings.ToLower(strings.TrimSpace(record[0])), "funder") {
+			continue
+		}
+
+		row, err := parseValidatorManifestRecord(line, record)
+		if err != nil {
+			invalidLines = append(invalidLines, err.Error())
+			continue
+		}
+		if prevLine, ok := candidates[row.Candidate]; ok {
+			invalidLines = append(invalidLines, fmt.Sprintf("line %v: candidate %v already listed at line %v", line, row.Candidate, prevLine))
+			continue
+		}
+		candidates[row.Candidate] = line
+		rows = append(rows, row)
+	}
+
+	if len(invalidLines) != 0 {
+		return nil, errors.New(fmt.Sprintf("%v invalid lines in %v:\n%v", len(invalidLines), fileName, strings.Join(invalidLines, "\n")))
+	}
+	if len(rows) == 0 {
+		return nil, errors.New(fmt.Sprintf("no validator found in %v", fileName))
+	}
+
+	return rows, nil
+}
+
+func parseValidatorManifestRecord(line int, record []string) (*ValidatorManifestRow, error) {
+	if len(record) < 5 || len(record) > 6 {
+		return nil, errors.New(fmt.Sprintf("line %v: expect FUNDER_PRIVATE_KEY,CANDIDATE,MINING_SEED,REWARD_RECEIVER,AUTO_STAKE[,STAKING_TYPE], got %v fields", line, len(record)))
+	}
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+
+	funderWallet, err := wallet.Base58CheckDeserialize(record[0])
+	if err != nil || len(funderWallet.KeySet.PrivateKey) == 0 {
+		return nil, errors.New(fmt.Sprintf("line %v: invalid funder private key", line))
+	}
+	funderAddr, err := getPaymentAddressFromKey(record[0])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("line %v: %v", line, err))
+	}
+
+	row := &ValidatorManifestRow{
+		Line:           line,
+		FunderKey:      record[0],
+		Candidate:      funderAddr,
+		MiningSeed:     record[2],
+		RewardReceiver: funderAddr,
+		AutoStake:      true,
+		StakingType:    metadata.ShardStakingMeta,
+	}
+	if len(record[1]) != 0 {
+		row.Candidate, err = getPaymentAddressFromKey(record[1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %v: invalid candidate: %v", line, err))
+		}
+	}
+	if len(record[3]) != 0 {
+		row.RewardReceiver, err = getPaymentAddressFromKey(record[3])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %v: invalid reward receiver: %v", line, err))
+		}
+	}
+	if len(record[4]) != 0 {
+		row.AutoStake, err = strconv.ParseBool(record[4])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %v: invalid auto-stake flag %v", line, record[4]))
+		}
+	}
+	if len(record) == 6 && len(record[5]) != 0 {
+		row.StakingType, err = ParseStakingType(record[5])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %v: %v", line, err))
+		}
+	}
+
+	return row, nil
+}
+
+//pendingSpends records the coins spent by the unconfirmed transactions of each funder, so that concurrent transactions
+//of the same funder never choose the same coins.
+type pendingSpends struct {
+	mtx         sync.Mutex
+	funderLocks map[string]*sync.Mutex
+	keyImages   map[string]map[string][]string //funder -> tx hash -> key images
+	inFlight    map[string]map[string]bool     //funder -> tx hashes of the transactions still being waited for
+	released    chan struct{}                  //closed and replaced whenever a transaction is no longer waited for
+}
+
+func newPendingSpends() *pendingSpends {
+	return &pendingSpends{
+		funderLocks: make(map[string]*sync.Mutex),
+		keyImages:   make(map[string]map[string][]string),
+		inFlight:    make(map[string]map[string]bool),
+		released:    make(chan struct{}),
+	}
+}
+
+//funderLock returns the lock to hold while choosing the coins of a funder.
+func (p *pendingSpends) funderLock(funder string) *sync.Mutex {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if _, ok := p.funderLocks[funder]; !ok {
+		p.funderLocks[funder] = new(sync.Mutex)
+	}
+	return p.funderLocks[funder]
+}
+
+//get returns the key images spent by the pending transactions of a funder, whether some of them are still being waited for,
+//and a channel closed at the next release.
+func (p *pendingSpends) get(funder string) ([]string, bool, chan struct{}) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	res := make([]string, 0)
+	for _, keyImages := range p.keyImages[funder] {
+		res = append(res, keyImages...)
+	}
+	return res, len(p.inFlight[funder]) != 0, p.released
+}
+
+func (p *pendingSpends) add(funder, txHash string, keyImages []string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if _, ok := p.keyImages[funder]; !ok {
+		p.keyImages[funder] = make(map[string][]string)
+		p.inFlight[funder] = make(map[string]bool)
+	}
+	p.keyImages[funder][txHash] = keyImages
+	p.inFlight[funder][txHash] = true
+}
+
+//release stops waiting for a transaction. Its coins can be spent again unless keepCoins is true,
+//i.e. the transaction may still be confirmed later.
+func (p *pendingSpends) release(funder, txHash string, keepCoins bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	delete(p.inFlight[funder], txHash)
+	if !keepCoins {
+		delete(p.keyImages[funder], txHash)
+	}
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+//RunValidatorBulk stakes (BulkStake), unstakes (BulkUnstake) or withdraws the rewards (BulkWithdraw) of every row of a manifest.
+//Withdrawals are sent by the funder, for the reward receiver of the row.
+//
+//At most options.Concurrency rows are processed at the same time, each one waiting for its transaction to be confirmed.
+//Transactions of the same funder never spend the same coins: if the free coins of a funder are not enough, the row waits
+//for another transaction of the funder to be confirmed and retries. A row failing does not stop the others.
+func RunValidatorBulk(ctx context.Context, operation, fileName string, rows []*ValidatorManifestRow, options *BulkOptions) (*BulkReport, error) {
+	if operation != BulkStake && operation != BulkUnstake && operation != BulkWithdraw {
+		return nil, errors.New(fmt.Sprintf("invalid bulk operation %v", operation))
+	}
+	if options == nil {
+		options = new(BulkOptions)
+	}
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBulkConcurrency
+	}
+	confirmTimeout := options.ConfirmTimeout
+	if confirmTimeout <= 0 {
+		confirmTimeout = DefaultBulkConfirmTimeout
+	}
+
+	report := &BulkReport{Operation: operation, File: fileName, StartedAt: time.Now(), Results: make([]*BulkRowResult, len(rows))}
+	for i, row := range rows {
+		funderAddr, _ := getPaymentAddressFromKey(row.FunderKey)
+		report.Results[i] = &BulkRowResult{Line: row.Line, Funder: funderAddr, Candidate: row.Candidate, Status: BulkRowCancelled}
+	}
+
+	//Resolve the missing mining seeds first, since it may be interactive.
+	if operation != BulkWithdraw {
+		for _, row := range rows {
+			if len(row.MiningSeed) != 0 {
+				continue
+			}
+			if options.MiningSeed == nil {
+				return nil, errors.New(fmt.Sprintf("line %v: no mining seed for candidate %v", row.Line, row.Candidate))
+			}
+			seed, err := options.MiningSeed(row)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("line %v: %v", row.Line, err))
+			}
+			row.MiningSeed = seed
+		}
+	}
+
+	//A withdrawal request withdraws all the rewards of a receiver, so a receiver shared by several rows is only withdrawn once.
+	todo := make([]int, 0)
+	receivers := make(map[string]int)
+	for i, row := range rows {
+		if operation == BulkWithdraw {
+			if prevLine, ok := receivers[row.RewardReceiver]; ok {
+				report.Results[i].Status = BulkRowSkipped
+				report.Results[i].Error = fmt.Sprintf("reward receiver already withdrawn by line %v", prevLine)
+				continue
+			}
+			receivers[row.RewardReceiver] = row.Line
+		}
+		todo = append(todo, i)
+	}
+
+	spends := newPendingSpends()
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				res := report.Results[index]
+				txHash, status, err := runBulkRow(ctx, operation, rows[index], res.Funder, spends, confirmTimeout)
+				res.TxHash, res.Status = txHash, status
+				if err != nil {
+					res.Error = err.Error()
+				}
+				if options.OnResult != nil {
+					options.OnResult(res)
+				}
+			}
+		}()
+	}
+
+	for _, i := range todo {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+//runBulkRow sends the transactions of a row and waits for them to be confirmed. It returns the tx hashes (comma-separated)
+//and the status of the row.
+func runBulkRow(ctx context.Context, operation string, row *ValidatorManifestRow, funder string, spends *pendingSpends,
+	confirmTimeout time.Duration) (string, string, error) {
+	switch operation {
+	case BulkStake:
+		return runBulkTx(ctx, funder, row.FunderKey, spends, confirmTimeout, func() (*TxParam, error) {
+			return newStakingTxParam(row.FunderKey, row.MiningSeed, row.Candidate, row.RewardReceiver, row.AutoStake, row.StakingType)
+		})
+	case BulkUnstake:
+		return runBulkTx(ctx, funder, row.FunderKey, spends, confirmTimeout, func() (*TxParam, error) {
+			return newUnStakingTxParam(row.FunderKey, row.MiningSeed, row.Candidate)
+		})
+	}
+
+	//A withdrawal request withdraws the reward of a single token, one request is sent per token with a reward.
+	rewardPublicKey, err := getPublicKeyFromAddress(row.RewardReceiver)
+	if err != nil {
+		return "", BulkRowFailed, err
+	}
+	rewards, err := GetRewardAmountByPublicKey(rewardPublicKey)
+	if err != nil {
+		return "", BulkRowFailed, err
+	}
+	tokenIDs := make([]string, 0)
+	for tokenID, amount := range rewards {
+		if amount != 0 {
+			tokenIDs = append(tokenIDs, tokenID)
+		}
+	}
+	if len(tokenIDs) == 0 {
+		return "", BulkRowSkipped, errors.New("no reward to withdraw")
+	}
+	sort.Strings(tokenIDs)
+
+	txHashes := make([]string, 0)
+	for _, tokenID := range tokenIDs {
+		tokenID := tokenID
+		txHash, status, err := runBulkTx(ctx, funder, row.FunderKey, spends, confirmTimeout, func() (*TxParam, error) {
+			return newWithdrawRewardTxParam(row.FunderKey, row.RewardReceiver, tokenID)
+		})
+		if len(txHash) != 0 {
+			txHashes = append(txHashes, txHash)
+		}
+		if status != BulkRowConfirmed {
+			if err != nil {
+				err = errors.New(fmt.Sprintf("withdrawal of %v: %v", tokenID, err))
+			}
+			return strings.Join(txHashes, ","), status, err
+		}
+	}
+
+	return strings.Join(txHashes, ","), BulkRowConfirmed, nil
+}
+
+//runBulkTx creates a transaction of a funder with newTxParam, without spending the coins of its pending transactions,
+//sends it and waits for it to be confirmed. It returns the tx hash and the resulting row status.
+func runBulkTx(ctx context.Context, funder, funderKey string, spends *pendingSpends, confirmTimeout time.Duration,
+	newTxParam func() (*TxParam, error)) (string, string, error) {
+	lock := spends.funderLock(funder)
+	var encodedTx []byte
+	var txHash string
+	var inputs map[string][]string
+	for {
+		lock.Lock()
+		excluded, waiting, released := spends.get(funder)
+
+		txParam, err := newTxParam()
+		if err != nil {
+			lock.Unlock()
+			return "", BulkRowFailed, err
+		}
+
+		txParam.SetExcludedKeyImages(excluded)
+		encodedTx, txHash, err = CreateRawTransaction(txParam, -1)
+		if err == nil {
+			inputs = txParam.spentKeyImages()
+			spends.add(funder, txHash, txParam.inputKeyImages)
+			lock.Unlock()
+			break
+		}
+		lock.Unlock()
+
+		//The free coins of the funder may not be enough until its pending transactions are confirmed.
+		if !waiting {
+			return "", BulkRowFailed, err
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return "", BulkRowCancelled, ctx.Err()
+		}
+	}
+
+	responseInBytes, err := sendRawTx(encodedTx, txHash)
+	if err == nil {
+		_, err = rpchandler.ParseResponse(responseInBytes)
+	}
+	if err != nil {
+		spends.release(funder, txHash, false)
+		return txHash, BulkRowFailed, err
+	}
+
+	res, err := WaitForTx(ctx, txHash, 1, confirmTimeout, nil)
+	if err != nil {
+		if res.Status == TxStatusDropped || res.Status == TxStatusRejected {
+			//The node watched may just have missed the transaction, its coins stay reserved unless it is dropped everywhere.
+			isDropped, checkErr := isTxDropped(funderKey, txHash, inputs)
+			if checkErr == nil && isDropped {
+				spends.release(funder, txHash, false)
+				return txHash, BulkRowFailed, err
+			}
+			if checkErr != nil {
+				err = errors.New(fmt.Sprintf("%v, cannot check whether it was dropped: %v", err, checkErr))
+			}
+		}
+		spends.release(funder, txHash, true)
+		return txHash, BulkRowSent, err
+	}
+
+	spends.release(funder, txHash, false)
+	return txHash, BulkRowConfirmed, nil
+}
@@ -0,0 +1,340 @@
+package debugtool
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/common/base58"
+	"github.com/thanhn-inc/debugtool/incognitokey"
+	"github.com/thanhn-inc/debugtool/privacy"
+	"github.com/thanhn-inc/debugtool/wallet"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	DefaultValidatorKeystoreFile = "validators.keystore"
+
+	//scrypt parameters of the keystore encryption key.
+	keystoreScryptN      = 1 << 15
+	keystoreScryptR      = 8
+	keystoreScryptP      = 1
+	keystoreKeySize      = 32
+	keystoreSaltSize     = 16
+	keystoreCipherSuite  = "aes-256-gcm"
+	keystoreKDF          = "scrypt"
+	keystoreMinPassLen   = 8
+	validatorKeystoreVer = 1
+)
+
+//ValidatorKey is the mining key of a committee candidate. The mining seed is stored encrypted with the keystore passphrase.
+type ValidatorKey struct {
+	//Candidate is the payment address of the committee candidate.
+	Candidate string
+	//CommitteeKey is the base58-encoded committee public key, as used in staking transactions.
+	CommitteeKey string
+	//BLSPublicKey is the base58-encoded BLS mining public key.
+	BLSPublicKey string
+	CreatedAt    time.Time
+	Imported     bool
+
+	Cipher     string
+	KDF        string
+	Salt       string
+	Nonce      string
+	CipherText string
+}
+
+func (key ValidatorKey) String() string {
+	source := "generated"
+	if key.Imported {
+		source = "imported"
+	}
+	return fmt.Sprintf("Candidate: %v\n\tCommittee key: %v\n\tBLS public key: %v\n\t%v at %v",
+		key.Candidate, key.CommitteeKey, key.BLSPublicKey, source, key.CreatedAt.Format(time.RFC3339))
+}
+
+//ValidatorKeystore maps committee candidates to their mining keys, stored in a local file.
+type ValidatorKeystore struct {
+	fileName string
+	Version  int
+	Keys     []*ValidatorKey
+}
+
+//GenerateMiningSeed returns a random base58-encoded mining seed, in the format of the `--miningkeys` flag of a node.
+func GenerateMiningSeed() string {
+	return base58.Base58Check{}.Encode(privacy.RandomScalar().ToBytesS(), common.ZeroByte)
+}
+
+//LegacyMiningSeed returns the mining seed derived from a private key, as the staking commands used to do.
+//It is only meant to import the keys of validators staked this way.
+func LegacyMiningSeed(privateKey string) string {
+	return base58.Base58Check{}.Encode(privacy.HashToScalar([]byte(privateKey)).ToBytesS(), common.ZeroByte)
+}
+
+//GetCommitteeKeyFromMiningSeed derives the committee public key of a candidate from a base58-encoded mining seed.
+func GetCommitteeKeyFromMiningSeed(candidateAddr, miningSeed string) (*incognitokey.CommitteePublicKey, error) {
+	candidateWallet, err := wallet.Base58CheckDeserialize(candidateAddr)
+	if err != nil {
+		return nil, err
+	}
+	pk := candidateWallet.KeySet.PaymentAddress.Pk
+	if len(pk) == 0 {
+		return nil, errors.New(fmt.Sprintf("candidate payment address invalid: %v", candidateAddr))
+	}
+
+	seed, _, err := base58.Base58Check{}.Decode(miningSeed)
+	if err != nil || len(seed) == 0 {
+		return nil, errors.New("cannot decode mining seed")
+	}
+
+	committeePK, err := incognitokey.NewCommitteeKeyFromSeed(seed, pk)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot create committee key of %v: %v", candidateAddr, err))
+	}
+
+	return &committeePK, nil
+}
+
+//LoadValidatorKeystore loads a keystore from a file. An empty keystore is returned if the file does not exist.
+func LoadValidatorKeystore(fileName string) (*ValidatorKeystore, error) {
+	keystore := &ValidatorKeystore{fileName: fileName, Version: validatorKeystoreVer, Keys: make([]*ValidatorKey, 0)}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keystore, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, keystore)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("cannot parse keystore %v: %v", fileName, err))
+	}
+	if keystore.Version != validatorKeystoreVer {
+		return nil, errors.New(fmt.Sprintf("unsupported keystore version %v", keystore.Version))
+	}
+
+	return keystore, nil
+}
+
+//Save writes the keystore to its file, readable by the owner only. The file is replaced atomically.
+func (keystore *ValidatorKeystore) Save() error {
+	data, err := json.MarshalIndent(keystore, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := keystore.fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, keystore.fileName)
+}
+
+//Get returns the key of a candidate, given by its payment address or its private key.
+func (keystore *ValidatorKeystore) Get(candidate string) (*ValidatorKey, error) {
+	candidateAddr, err := getPaymentAddressFromKey(candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keystore.Keys {
+		if isSameAddress(key.Candidate, candidateAddr) {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("no mining key found for candidate %v", candidateAddr))
+}
+
+//Add encrypts a mining seed with the passphrase and maps it to a candidate. A candidate has at most one key.
+func (keystore *ValidatorKeystore) Add(candidate, miningSeed, passphrase string, imported bool) (*ValidatorKey, error) {
+	if len(passphrase) < keystoreMinPassLen {
+		return nil, errors.New(fmt.Sprintf("the passphrase must have at least %v characters", keystoreMinPassLen))
+	}
+
+	candidateAddr, err := getPaymentAddressFromKey(candidate)
+	if err != nil {
+		return nil, err
+	}
+	if existing, err := keystore.Get(candidateAddr); err == nil {
+		return nil, errors.New(fmt.Sprintf("candidate %v already has a mining key: %v", candidateAddr, existing.CommitteeKey))
+	}
+	//All the keys of a keystore share the same passphrase.
+	if len(keystore.Keys) != 0 {
+		_, err = keystore.Keys[0].decrypt(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	committeePK, err := GetCommitteeKeyFromMiningSeed(candidateAddr, miningSeed)
+	if err != nil {
+		return nil, err
+	}
+	committeePKBytes, err := committeePK.Bytes()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("committe to bytes error: %v", err))
+	}
+
+	key := &ValidatorKey{
+		Candidate:    candidateAddr,
+		CommitteeKey: base58.Base58Check{}.Encode(committeePKBytes, common.ZeroByte),
+		BLSPublicKey: base58.Base58Check{}.Encode(committeePK.MiningPubKey[common.BlsConsensus], common.ZeroByte),
+		CreatedAt:    time.Now(),
+		Imported:     imported,
+		Cipher:       keystoreCipherSuite,
+		KDF:          keystoreKDF,
+	}
+	err = key.encrypt([]byte(miningSeed), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	keystore.Keys = append(keystore.Keys, key)
+	return key, keystore.Save()
+}
+
+//Generate creates a random mining key for a candidate.
+func (keystore *ValidatorKeystore) Generate(candidate, passphrase string) (*ValidatorKey, error) {
+	return keystore.Add(candidate, GenerateMiningSeed(), passphrase, false)
+}
+
+//Import adds an existing base58-encoded mining seed for a candidate.
+func (keystore *ValidatorKeystore) Import(candidate, miningSeed, passphrase string) (*ValidatorKey, error) {
+	return keystore.Add(candidate, miningSeed, passphrase, true)
+}
+
+//Remove deletes the key of a candidate.
+func (keystore *ValidatorKeystore) Remove(candidate string) error {
+	key, err := keystore.Get(candidate)
+	if err != nil {
+		return err
+	}
+
+	for i := range keystore.Keys {
+		if keystore.Keys[i] == key {
+			keystore.Keys = append(keystore.Keys[:i], keystore.Keys[i+1:]...)
+			break
+		}
+	}
+
+	return keystore.Save()
+}
+
+//GetMiningSeed decrypts the base58-encoded mining seed of a candidate.
+func (keystore *ValidatorKeystore) GetMiningSeed(candidate, passphrase string) (string, error) {
+	key, err := keystore.Get(candidate)
+	if err != nil {
+		return "", err
+	}
+
+	seed, err := key.decrypt(passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return string(seed), nil
+}
+
+//ExportMiningKeys returns, for each candidate, the `--miningkeys` flag to run its node.
+func (keystore *ValidatorKeystore) ExportMiningKeys(passphrase string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, key := range keystore.Keys {
+		seed, err := key.decrypt(passphrase)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("candidate %v: %v", key.Candidate, err))
+		}
+		res[key.Candidate] = fmt.Sprintf("--miningkeys \"%v\"", string(seed))
+	}
+
+	return res, nil
+}
+
+func deriveKeystoreKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, keystoreScryptN, keystoreScryptR, keystoreScryptP, keystoreKeySize)
+}
+
+func (key *ValidatorKey) encrypt(plaintext []byte, passphrase string) error {
+	salt := make([]byte, keystoreSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	aesKey, err := deriveKeystoreKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	//The candidate is authenticated with the seed, so that an entry cannot be moved to another candidate.
+	key.Salt = hex.EncodeToString(salt)
+	key.Nonce = hex.EncodeToString(nonce)
+	key.CipherText = hex.EncodeToString(gcm.Seal(nil, nonce, plaintext, []byte(key.Candidate)))
+	return nil
+}
+
+func (key *ValidatorKey) decrypt(passphrase string) ([]byte, error) {
+	if key.Cipher != keystoreCipherSuite || key.KDF != keystoreKDF {
+		return nil, errors.New(fmt.Sprintf("unsupported encryption %v/%v", key.Cipher, key.KDF))
+	}
+
+	salt, err := hex.DecodeString(key.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(key.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(key.CipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	aesKey, err := deriveKeystoreKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, cipherText, []byte(key.Candidate))
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted keystore")
+	}
+
+	return plaintext, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/thanhn-inc/debugtool/common"
@@ -11,10 +12,16 @@ import (
 	"github.com/thanhn-inc/debugtool/rpchandler"
 	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
 	"github.com/thanhn-inc/debugtool/wallet"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+//KeystorePassphraseEnv is the environment variable holding the passphrase of the validator keystore.
+const KeystorePassphraseEnv = "DEBUGTOOL_KEYSTORE_PASSPHRASE"
+
 var mainNetTokenIDs = map[string]string {
 	"USDT": "716fd1009e2a1669caacc36891e707bfdf02590f96ebd897548e8963c95ebac0",
 	"BNB": "b2655152784e8639fa19521a7035f331eea1f1e911b2f3200a507ebb4554387b",
@@ -154,6 +161,39 @@ func GetRawMempool() {
 	fmt.Printf("%v Number of txs: %v\n", time.Now().String(), len(txList))
 	fmt.Println("==================================")
 }
+func MonitorMempool(interval, stuckThreshold time.Duration) {
+	monitor := debugtool.NewMempoolMonitor(stuckThreshold)
+	for {
+		snapshot, err := monitor.Update()
+		if err != nil {
+			fmt.Println(err)
+			if snapshot == nil {
+				time.Sleep(interval)
+				continue
+			}
+		}
+
+		fmt.Println("========== MEMPOOL MONITOR ==========")
+		for _, info := range snapshot.Txs {
+			fmt.Println(info)
+		}
+		fmt.Printf("%v Number of txs: %v, new: %v, removed: %v\n", snapshot.Time.String(), len(snapshot.Txs), len(snapshot.NewTxs), len(snapshot.RemovedTxs))
+		for shardID := 0; shardID < len(snapshot.ShardPoolStates); shardID++ {
+			if heights, ok := snapshot.ShardPoolStates[shardID]; ok {
+				fmt.Printf("Shard %v pool: %v blocks %v\n", shardID, len(heights), heights)
+			}
+		}
+		if len(snapshot.StuckTxs) > 0 {
+			fmt.Printf("WARNING: %v txs stuck for more than %v\n", len(snapshot.StuckTxs), stuckThreshold)
+			for _, info := range snapshot.StuckTxs {
+				fmt.Printf("\tSTUCK %v\n", info)
+			}
+		}
+		fmt.Println("========== END MEMPOOL MONITOR ==========")
+
+		time.Sleep(interval)
+	}
+}
 func GetTxByHash(txHash string) {
 	fmt.Println("========== GET TX BY HASH ==========")
 	b, _ := rpc.GetTransactionByHash(txHash)
@@ -176,6 +216,64 @@ func GenerateCommitteeKey(privateKey, seed string) (string, error) {
 
 	return committeeKey.ToBase58()
 }
+
+//ReadKeystorePassphrase returns the passphrase of the validator keystore, read from the terminal without echo if the standard
+//input is a terminal. Otherwise, it is taken from the environment variable KeystorePassphraseEnv if set, or read from the standard input.
+func ReadKeystorePassphrase(reader *bufio.Reader) string {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		fmt.Print("Enter the validator keystore passphrase: ")
+		passphrase, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err == nil {
+			return string(passphrase)
+		}
+	}
+
+	if passphrase := os.Getenv(KeystorePassphraseEnv); len(passphrase) != 0 {
+		return passphrase
+	}
+
+	fmt.Print("Enter the validator keystore passphrase: ")
+	text, _ := reader.ReadString('\n')
+	return strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+}
+
+//GetMiningSeed returns the mining seed of a candidate from the validator keystore. If the candidate has no key and generate is true,
+//a random key is created. The passphrase is asked once per session.
+func GetMiningSeed(reader *bufio.Reader, passphrase *string, candidate string, generate bool) (string, error) {
+	keystore, err := debugtool.LoadValidatorKeystore(debugtool.DefaultValidatorKeystoreFile)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = keystore.Get(candidate)
+	if err != nil && !generate {
+		return "", fmt.Errorf("%v, create one with `valkeygen` or import it with `valkeyimport`", err)
+	}
+
+	currentPassphrase := *passphrase
+	if len(currentPassphrase) == 0 {
+		currentPassphrase = ReadKeystorePassphrase(reader)
+	}
+
+	if err != nil {
+		key, err := keystore.Generate(candidate, currentPassphrase)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("New mining key generated and saved to %v\n%v\n", debugtool.DefaultValidatorKeystoreFile, key)
+	}
+
+	seed, err := keystore.GetMiningSeed(candidate, currentPassphrase)
+	if err != nil {
+		return "", err
+	}
+	*passphrase = currentPassphrase
+
+	return seed, nil
+}
+
 func ParsePrivateKey(arg string, privateKeys []string) (string, error) {
 	var privateKey string
 	if len(arg) < 3 {
@@ -280,6 +378,45 @@ func ListBridgeTokens() {
 	fmt.Println("========== END LIST ALL TOKEN ==========")
 	return
 }
+//ParsePDETradeOptions parses the optional trade arguments `[SLIPPAGE_BPS] [TRADING_FEE] [MIN_RECEIVE]`.
+func ParsePDETradeOptions(args []string) (*debugtool.PDETradeOptions, error) {
+	options := debugtool.DefaultPDETradeOptions()
+
+	var err error
+	if len(args) > 0 {
+		options.SlippageBps, err = strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse slippage %v: %v", args[0], err)
+		}
+	}
+	if len(args) > 1 {
+		options.TradingFee, err = strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse trading fee %v: %v", args[1], err)
+		}
+	}
+	if len(args) > 2 {
+		options.MinAcceptableAmount, err = strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse minimum receiving amount %v: %v", args[2], err)
+		}
+	}
+
+	return options, nil
+}
+
+//ParseMemo looks for an argument starting with "memo=" and returns the remaining arguments together with the memo.
+//Everything after "memo=" (including the following arguments) is considered part of the memo.
+func ParseMemo(args []string) ([]string, string) {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "memo=") {
+			memo := strings.Join(append([]string{strings.TrimPrefix(arg, "memo=")}, args[i+1:]...), " ")
+			return args[:i], memo
+		}
+	}
+
+	return args, ""
+}
 func ParseTokenID(arg string) (string, error) {
 	if len(arg) < 10 {
 		tokenID, ok := common.SupportedTokenID[arg]
@@ -292,6 +429,25 @@ func ParseTokenID(arg string) (string, error) {
 	return arg, nil
 }
 
+//PrintOrSendETHWithdraw prints the vault withdraw calldata of a burn proof, or sends it from sender through the EVM endpoint evmURL if set.
+func PrintOrSendETHWithdraw(proof *debugtool.ETHWithdrawProof, evmURL, sender string) error {
+	if len(evmURL) != 0 {
+		ethTxHash, err := debugtool.SendETHWithdrawTransaction(evmURL, sender, proof)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Withdraw sent to %v. ETH TxHash: %v.\n", common.EthContractAddressStr, ethTxHash)
+		return nil
+	}
+
+	calldata, err := proof.Calldata()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Withdraw calldata (to %v):\n0x%x\n", common.EthContractAddressStr, calldata)
+	return nil
+}
+
 //TXO-related functions
 func GetTXOs(privateKey string, tokenID string, height uint64) {
 	fmt.Println("========== GET PRV OUTPUT COIN ==========")
@@ -309,6 +465,20 @@ func GetTXOs(privateKey string, tokenID string, height uint64) {
 	}
 
 	fmt.Println(string(b))
+
+	outCoins, _, err := debugtool.ParseCoinFromJsonResponse(b)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	decryptedCoins, _, memos, err := debugtool.GetListDecryptedCoinsWithMemos(privateKey, outCoins)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i, decryptedCoin := range decryptedCoins {
+		fmt.Printf("version: %v, pubKey: %v, value: %v, memo: %q\n", decryptedCoin.GetVersion(), decryptedCoin.GetPublicKey(), decryptedCoin.GetValue(), memos[i])
+	}
 	fmt.Println("========== END GET PRV OUTPUT COIN ==========")
 }
 func GetUTXOs(privateKey string, tokenID string, height uint64) {
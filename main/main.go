@@ -2,15 +2,19 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/thanhn-inc/debugtool/common"
 	"github.com/thanhn-inc/debugtool/common/base58"
 	"github.com/thanhn-inc/debugtool/debugtool"
-	"github.com/thanhn-inc/debugtool/privacy"
+	"github.com/thanhn-inc/debugtool/metadata"
 	"github.com/thanhn-inc/debugtool/rpchandler/rpc"
+	"github.com/thanhn-inc/debugtool/wallet"
+	"math"
 	"math/big"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"time"
@@ -41,7 +45,7 @@ func main() {
 		"1111111Cjswfvzmhpge7B73ww1GWfj5CNuHcyBt72qNP1ceoaCQ4uHNhDyUNY3xSUeakovcDKTcwUsVvmuacVMamGVo1zbdB9u57Frcxc4p",
 		"11111117GB8eNDXhVSdh7mqFw9yWcsMrW2B2yTreXxvDiFsg2WTX79UNDJ9ukxsM14jK3vWqbzvZ1B95XKZh6tWePifWkodNCMLXhF5Bwsv",
 	}
-	privateSeeds := make(map[string]string)
+	keystorePassphrase := ""
 
 
 	err := InitTestNet()
@@ -49,15 +53,18 @@ func main() {
 		panic(err)
 	}
 
-	reader := bufio.NewReader(os.Stdin)
+	err = debugtool.EnableTxJournal(debugtool.DefaultTxJournalFile)
+	if err != nil {
+		panic(err)
+	}
 
-	//Generate privateSeeds
-	for _, privateKey := range privateKeys {
-		privateSeedBytes := privacy.HashToScalar([]byte(privateKey)).ToBytesS()
-		privateSeed := base58.Base58Check{}.Encode(privateSeedBytes, common.ZeroByte)
-		privateSeeds[privateKey] = privateSeed
+	err = debugtool.EnableETHReceiptCache(debugtool.DefaultETHReceiptCacheDir)
+	if err != nil {
+		panic(err)
 	}
 
+	reader := bufio.NewReader(os.Stdin)
+
 	for {
 		fmt.Print("Enter your choice (arguments separated by ONLY ONE space) and hit ENTER: ")
 		text, _ := reader.ReadString('\n')
@@ -195,6 +202,7 @@ func main() {
 			fmt.Println("Balance =", balance)
 
 		case "transfer":
+			args, memo := ParseMemo(args)
 			if len(args) < 4 {
 				fmt.Println("need at least 4 arguments.")
 			}
@@ -231,7 +239,7 @@ func main() {
 				txVersion = int8(tmpVersion)
 			}
 
-			txHash, err := debugtool.CreateAndSendRawTransaction(privateKey, []string{paymentAddress}, []uint64{amount}, txVersion, nil)
+			txHash, err := debugtool.CreateAndSendRawTransactionWithMemos(privateKey, []string{paymentAddress}, []uint64{amount}, []string{memo}, txVersion, nil)
 			if err != nil {
 				fmt.Println("CreateAndSendRawTransaction returns an error:", err)
 				continue
@@ -239,6 +247,49 @@ func main() {
 
 			fmt.Printf("CreateAndSendRawTransaction succeeded. TxHash: %v.\n", txHash)
 
+		case "payout":
+			if len(args) < 3 {
+				fmt.Println("not enough param for payout")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fileName := args[2]
+
+			tokenID := common.PRVIDStr
+			if len(args) > 3 {
+				tokenID, err = ParseTokenID(args[3])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			stateFile := fileName + ".state.json"
+			if len(args) > 4 {
+				stateFile = args[4]
+			}
+
+			state, err := debugtool.Payout(privateKey, fileName, tokenID, stateFile)
+			if state != nil {
+				count, total := state.Summary()
+				fmt.Printf("Payout state (%v): paid %v rows (%v), sent %v rows (%v), pending %v rows (%v)\n", stateFile,
+					count[debugtool.PayoutRowPaid], total[debugtool.PayoutRowPaid],
+					count[debugtool.PayoutRowSent], total[debugtool.PayoutRowSent],
+					count[debugtool.PayoutRowPending], total[debugtool.PayoutRowPending])
+			}
+			if err != nil {
+				fmt.Println("Payout returns an error:", err)
+				continue
+			}
+
+			fmt.Println("Payout succeeded.")
+
 		case "uot":
 			if len(args) < 2 {
 				fmt.Println("Not enough param for unspentouttoken")
@@ -352,6 +403,7 @@ func main() {
 			fmt.Printf("CreateAndSendRawTokenInitTransaction succeeded. TxHash: %v.\n", txHash)
 
 		case "transfertoken":
+			args, memo := ParseMemo(args)
 			if len(args) < 5 {
 				fmt.Println("need at least 5 arguments.")
 				continue
@@ -401,7 +453,7 @@ func main() {
 				hasTokenFee = true
 			}
 
-			txHash, err := debugtool.CreateAndSendRawTokenTransaction(privateKey, []string{paymentAddress}, []uint64{uint64(amount)}, txVersion, tokenID, hasTokenFee)
+			txHash, err := debugtool.CreateAndSendRawTokenTransactionWithMemos(privateKey, []string{paymentAddress}, []uint64{uint64(amount)}, []string{memo}, txVersion, tokenID, hasTokenFee)
 			if err != nil {
 				fmt.Println("CreateAndSendRawTokenTransaction returns an error:", err)
 				continue
@@ -555,13 +607,10 @@ func main() {
 				continue
 			}
 
-			var privateSeed string
-			privateSeed, ok := privateSeeds[privateKey]
-			if !ok {
-				privateSeedBytes := privacy.HashToScalar([]byte(privateKey)).ToBytesS()
-				privateSeed = base58.Base58Check{}.Encode(privateSeedBytes, common.ZeroByte)
-				privateSeeds[privateKey] = privateSeed
-				fmt.Println("privateSeed", privateSeed)
+			privateSeed, err := GetMiningSeed(reader, &keystorePassphrase, privateKey, false)
+			if err != nil {
+				fmt.Println(err)
+				continue
 			}
 
 			cmKey, err := GenerateCommitteeKey(privateKey, privateSeed)
@@ -615,9 +664,63 @@ func main() {
 				GetRawMempool()
 			}
 
+		case "mempoolmonitor":
+			interval := int64(10)
+			if len(args) > 1 {
+				interval, err = strconv.ParseInt(args[1], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse interval", err)
+					continue
+				}
+			}
+
+			stuckThreshold := int64(debugtool.DefaultStuckThreshold.Seconds())
+			if len(args) > 2 {
+				stuckThreshold, err = strconv.ParseInt(args[2], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse stuck threshold", err)
+					continue
+				}
+			}
+
+			MonitorMempool(time.Duration(interval)*time.Second, time.Duration(stuckThreshold)*time.Second)
+
 		case "txhash":
 			GetTxByHash(args[1])
 
+		case "wait":
+			if len(args) < 2 {
+				fmt.Println("not enough param for wait")
+				continue
+			}
+
+			confirmations := uint64(debugtool.DefaultTrackerConfirmations)
+			if len(args) > 2 {
+				confirmations, err = strconv.ParseUint(args[2], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse confirmations", err)
+					continue
+				}
+			}
+
+			timeout := int64(600)
+			if len(args) > 3 {
+				timeout, err = strconv.ParseInt(args[3], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse timeout", err)
+					continue
+				}
+			}
+
+			res, err := debugtool.WaitForTx(context.Background(), args[1], confirmations, time.Duration(timeout)*time.Second, func(update debugtool.TxStatusUpdate) {
+				fmt.Println(update)
+			})
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("tx %v confirmed at height %v of shard %v\n", res.TxHash, res.BlockHeight, res.ShardID)
+
 		case "shardstate":
 			if len(args) < 2 {
 				fmt.Println("not enough param for shardstate")
@@ -662,7 +765,20 @@ func main() {
 				continue
 			}
 
-			txHash, err := debugtool.CreateAndSendPDETradeTransaction(privateKey, common.PRVIDStr, tokenID, uint64(amount))
+			options, err := ParsePDETradeOptions(args[4:])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			quote, err := debugtool.GetPDETradeQuote(common.PRVIDStr, tokenID, uint64(amount), options)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(quote)
+
+			txHash, err := debugtool.CreateAndSendPDETradeTransactionWithQuote(privateKey, quote, -1)
 			if err != nil {
 				fmt.Println(err)
 				continue
@@ -700,7 +816,20 @@ func main() {
 				continue
 			}
 
-			txHash, err := debugtool.CreateAndSendPDETradeTransaction(privateKey, tokenIDToSell, tokenIDToBuy, uint64(amount))
+			options, err := ParsePDETradeOptions(args[5:])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			quote, err := debugtool.GetPDETradeQuote(tokenIDToSell, tokenIDToBuy, uint64(amount), options)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(quote)
+
+			txHash, err := debugtool.CreateAndSendPDETradeTransactionWithQuote(privateKey, quote, -1)
 			if err != nil {
 				fmt.Println(err)
 				continue
@@ -743,6 +872,58 @@ func main() {
 
 			fmt.Printf("CreateAndSendPDEContributeTransaction for token %v succeeded. TxHash: %v.\n", tokenID, txHash)
 
+		case "addliquidity":
+			if len(args) < 5 {
+				fmt.Println("Not enough param for addliquidity")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenIDA, err := ParseTokenID(args[2])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenIDB, err := ParseTokenID(args[3])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			amountA, err := strconv.ParseUint(args[4], 10, 64)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			amountB := uint64(0)
+			if len(args) > 5 {
+				amountB, err = strconv.ParseUint(args[5], 10, 64)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), debugtool.DefaultAddLiquidityTimeout)
+			res, err := debugtool.AddLiquidity(ctx, privateKey, tokenIDA, tokenIDB, amountA, amountB, func(msg string) {
+				fmt.Println(msg)
+			})
+			cancel()
+			if res != nil {
+				fmt.Println(res)
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
 		case "pdewithdraw":
 			if len(args) < 5 {
 				fmt.Println("Not enough param for pdewithdraw")
@@ -767,19 +948,142 @@ func main() {
 				continue
 			}
 
-			sharedAmount, err := strconv.ParseInt(args[4], 10, 32)
+			var txHash string
+			if strings.HasSuffix(args[4], "%") {
+				percent, err := strconv.ParseFloat(strings.TrimSuffix(args[4], "%"), 64)
+				if err != nil || percent <= 0 || percent > 100 {
+					fmt.Println("cannot parse percentage", args[4])
+					continue
+				}
+
+				txHash, err = debugtool.CreateAndSendPDEWithdrawalTransactionByPercent(privateKey, tokenID1, tokenID2, uint64(math.Round(percent*100)))
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			} else {
+				sharedAmount, err := strconv.ParseUint(args[4], 10, 64)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+
+				txHash, err = debugtool.CreateAndSendPDEWithdrawalTransaction(privateKey, tokenID1, tokenID2, sharedAmount)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			fmt.Printf("CreateAndSendPDEWithdrawalTransaction succeeded. TxHash: %v.\n", txHash)
+
+		case "pdewithdrawfee":
+			if len(args) < 4 {
+				fmt.Println("Not enough param for pdewithdrawfee")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
 
-			txHash, err := debugtool.CreateAndSendPDEWithdrawalTransaction(privateKey, tokenID1, tokenID2, uint64(sharedAmount))
+			tokenID1, err := ParseTokenID(args[2])
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
 
-			fmt.Printf("CreateAndSendPDEWithdrawalTransaction succeeded. TxHash: %v.\n", txHash)
+			tokenID2, err := ParseTokenID(args[3])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			feeAmount := uint64(0)
+			if len(args) > 4 {
+				feeAmount, err = strconv.ParseUint(args[4], 10, 64)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			txHash, err := debugtool.CreateAndSendPDEFeeWithdrawalTransaction(privateKey, tokenID1, tokenID2, feeAmount)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("CreateAndSendPDEFeeWithdrawalTransaction succeeded. TxHash: %v.\n", txHash)
+
+		case "pdefeestatus":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for pdefeestatus")
+				continue
+			}
+
+			status, err := debugtool.GetPDEFeeWithdrawalStatus(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			switch status {
+			case common.PDEFeeWithdrawalAcceptedStatus:
+				fmt.Println("accepted")
+			case common.PDEFeeWithdrawalRejectedStatus:
+				fmt.Println("rejected")
+			default:
+				fmt.Println("not found (the request may not have been processed yet)")
+			}
+
+		case "lpposition":
+			if len(args) < 2 {
+				fmt.Println("need at least 1 argument")
+				continue
+			}
+
+			addr, err := ParsePaymentAddress(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			beaconHeight := uint64(0)
+			if len(args) > 2 {
+				beaconHeight, err = strconv.ParseUint(args[2], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse beacon height", args[2])
+					continue
+				}
+			}
+
+			position, err := debugtool.GetLPPosition(addr, beaconHeight)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(position)
+
+			if len(args) > 3 {
+				fromHeight, err := strconv.ParseUint(args[3], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse from height", args[3])
+					continue
+				}
+
+				changes, err := debugtool.GetLPPositionChanges(addr, fromHeight, position.BeaconHeight)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				fmt.Printf("Changes from beacon height %v to %v:\n", fromHeight, position.BeaconHeight)
+				for _, change := range changes {
+					fmt.Printf("\t%v\n", change)
+				}
+			}
 
 		case "pdestate":
 			var bHeight uint64
@@ -981,45 +1285,648 @@ func main() {
 			rate := float64(expectedTradeValue) / float64(amount)
 			fmt.Printf("Sell %v of token %v, get %v of token %v, rate %v, %v\n", amount, tokenID1, expectedTradeValue, tokenID2, rate, 1/rate)
 
-		case "beststable":
-			if len(args) < 2 {
-				fmt.Println("not enough param for beststable")
-			}
-
-			amount, err := strconv.ParseInt(args[1], 10, 64)
-			if err != nil {
-				fmt.Println(err)
+		case "poolhistory":
+			if len(args) < 4 {
+				fmt.Println("need at least 3 arguments")
 				continue
 			}
 
-			tokenID := common.PRVIDStr
-			if len(args) > 2 {
-				tokenID, err = ParseTokenID(args[2])
+			//`all` samples every pool, otherwise the first two arguments are the tokens of a pool.
+			pairs := make([]debugtool.PDEPoolPair, 0)
+			heightIndex := 2
+			if args[1] != "all" {
+				tokenID1, err := ParseTokenID(args[1])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+
+				tokenID2, err := ParseTokenID(args[2])
 				if err != nil {
 					fmt.Println(err)
+					continue
 				}
+				pairs = append(pairs, debugtool.PDEPoolPair{Token1ID: tokenID1, Token2ID: tokenID2})
+				heightIndex = 3
+			}
+			if len(args) < heightIndex+2 {
+				fmt.Println("need FROM_HEIGHT and TO_HEIGHT")
+				continue
 			}
 
-			token, value, err := debugtool.ChooseBestStableCoinPool(tokenID, uint64(amount))
+			fromHeight, err := strconv.ParseUint(args[heightIndex], 10, 64)
 			if err != nil {
-				fmt.Println(err)
+				fmt.Println("cannot parse from height", args[heightIndex])
 				continue
 			}
-			fmt.Println(token, value)
 
-		case "tradestatus":
-			if len(args) < 2 {
-				fmt.Println("not enough param for beststable")
+			toHeight, err := strconv.ParseUint(args[heightIndex+1], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse to height", args[heightIndex+1])
+				continue
+			}
+
+			step := uint64(1)
+			if len(args) > heightIndex+2 {
+				step, err = strconv.ParseUint(args[heightIndex+2], 10, 64)
+				if err != nil || step == 0 {
+					fmt.Println("cannot parse step", args[heightIndex+2])
+					continue
+				}
 			}
 
-			b, err := rpc.CheckTradeStatus(args[1])
+			samples, err := debugtool.SamplePDEPoolHistory(pairs, fromHeight, toHeight, step, func(height uint64) {
+				fmt.Printf("Sampling beacon height %v/%v\n", height, toHeight)
+			})
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
-			fmt.Println(string(b))
 
-		//STAKING
+			if len(args) > heightIndex+3 {
+				err = debugtool.ExportPDEPoolHistory(samples, args[heightIndex+3])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				fmt.Printf("Exported %v samples to %v\n", len(samples), args[heightIndex+3])
+			} else {
+				err = debugtool.WritePDEPoolHistoryCSV(samples, os.Stdout)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+			fmt.Println(debugtool.SummarizePDEPoolHistory(samples))
+
+		case "pdesnapshot":
+			if len(args) < 3 {
+				fmt.Println("need at least 2 arguments")
+				continue
+			}
+
+			beaconHeight, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse beacon height", args[1])
+				continue
+			}
+
+			sim, err := debugtool.NewPDESimulatorFromRPC(beaconHeight)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			err = sim.Save(args[2])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("pDEX state at beacon height %v saved to %v\n", sim.BeaconHeight, args[2])
+
+		case "simtrade":
+			if len(args) < 5 {
+				fmt.Println("need at least 4 arguments")
+				continue
+			}
+
+			sim, err := debugtool.NewPDESimulatorFromSource(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenToSell, err := ParseTokenID(args[2])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenToBuy, err := ParseTokenID(args[3])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("Simulating on the pDEX state at beacon height %v\n", sim.BeaconHeight)
+			for _, amountStr := range args[4:] {
+				amount, err := strconv.ParseUint(amountStr, 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse amount", amountStr)
+					break
+				}
+
+				res, err := sim.Trade(tokenToSell, tokenToBuy, amount, 0)
+				if err != nil {
+					fmt.Println(err)
+					break
+				}
+				price, _ := sim.GetPrice(tokenToSell, tokenToBuy)
+				fmt.Printf("%v, new price %v\n", res, price)
+			}
+
+		case "backtest":
+			if len(args) < 7 {
+				fmt.Println("need at least 6 arguments")
+				continue
+			}
+
+			tokenToSell, err := ParseTokenID(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenToBuy, err := ParseTokenID(args[2])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			amount, err := strconv.ParseUint(args[3], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse amount", args[3])
+				continue
+			}
+
+			expectedRate, err := strconv.ParseFloat(args[4], 64)
+			if err != nil {
+				fmt.Println("cannot parse rate", args[4])
+				continue
+			}
+
+			fromHeight, err := strconv.ParseUint(args[5], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse from height", args[5])
+				continue
+			}
+
+			toHeight, err := strconv.ParseUint(args[6], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse to height", args[6])
+				continue
+			}
+
+			step := uint64(1)
+			if len(args) > 7 {
+				step, err = strconv.ParseUint(args[7], 10, 64)
+				if err != nil || step == 0 {
+					fmt.Println("cannot parse step", args[7])
+					continue
+				}
+			}
+
+			balance := amount
+			if len(args) > 8 {
+				balance, err = strconv.ParseUint(args[8], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse balance", args[8])
+					continue
+				}
+			}
+
+			snapshots, err := debugtool.LoadPDESimulators(fromHeight, toHeight, step, func(height uint64) {
+				fmt.Printf("Loading beacon height %v...\n", height)
+			})
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			strategy := &debugtool.AutoTradeStrategy{
+				TokenToSell:  tokenToSell,
+				TokenToBuy:   tokenToBuy,
+				Amount:       amount,
+				ExpectedRate: expectedRate,
+				SlippageBps:  debugtool.DefaultPDETradeSlippage,
+				Repeat:       balance > amount,
+			}
+			res, err := debugtool.BacktestPDEStrategy(strategy, snapshots, map[string]uint64{tokenToSell: balance}, common.PRVIDStr)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(res)
+
+		case "route":
+			if len(args) < 4 {
+				fmt.Println("need at least 4 arguments")
+				continue
+			}
+
+			tokenID1, err := ParseTokenID(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenID2, err := ParseTokenID(args[2])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			amount, err := strconv.ParseUint(args[3], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse amount", args[3])
+				continue
+			}
+
+			maxHops := debugtool.DefaultMaxRouteHops
+			if len(args) > 4 {
+				maxHops, err = strconv.Atoi(args[4])
+				if err != nil || maxHops <= 0 {
+					fmt.Println("cannot parse max hops", args[4])
+					continue
+				}
+			}
+
+			tradingFee := uint64(0)
+			if len(args) > 5 {
+				tradingFee, err = strconv.ParseUint(args[5], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse trading fee", args[5])
+					continue
+				}
+			}
+
+			routes, err := debugtool.FindPDERoutes(tokenID1, tokenID2, amount, maxHops, tradingFee)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("Found %v routes, best ones first\n", len(routes))
+			for i, route := range routes {
+				if i >= 5 {
+					break
+				}
+				fmt.Println(route)
+			}
+
+		case "arbscan":
+			startTokenIDs := make([]string, 0)
+			if len(args) > 1 && args[1] != "all" {
+				tokenID, err := ParseTokenID(args[1])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				startTokenIDs = append(startTokenIDs, tokenID)
+			}
+
+			maxHops := debugtool.DefaultMaxArbitrageHops
+			if len(args) > 2 {
+				maxHops, err = strconv.Atoi(args[2])
+				if err != nil || maxHops <= 0 {
+					fmt.Println("cannot parse max hops", args[2])
+					continue
+				}
+			}
+
+			maxAmount := uint64(0)
+			if len(args) > 3 {
+				maxAmount, err = strconv.ParseUint(args[3], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse max amount", args[3])
+					continue
+				}
+			}
+
+			tradingFee := uint64(0)
+			if len(args) > 4 {
+				tradingFee, err = strconv.ParseUint(args[4], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse trading fee", args[4])
+					continue
+				}
+			}
+
+			opportunities, err := debugtool.ScanPDEArbitrage(startTokenIDs, maxHops, maxAmount, tradingFee)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("Found %v profitable cycles, best ones first\n", len(opportunities))
+			for i, arb := range opportunities {
+				if i >= 10 {
+					break
+				}
+				fmt.Printf("%v. %v\n", i+1, arb)
+			}
+
+		case "arbexec":
+			if len(args) < 3 {
+				fmt.Println("need at least 2 arguments")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenID, err := ParseTokenID(args[2])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			dryRun := false
+			if args[len(args)-1] == "dryrun" {
+				dryRun = true
+				args = args[:len(args)-1]
+			}
+
+			maxAmount, err := debugtool.GetBalance(privateKey, tokenID)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if len(args) > 3 {
+				amount, err := strconv.ParseUint(args[3], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse max amount", args[3])
+					continue
+				}
+				if amount < maxAmount {
+					maxAmount = amount
+				}
+			}
+			if maxAmount == 0 {
+				fmt.Println("no balance to trade")
+				continue
+			}
+
+			maxHops := debugtool.DefaultMaxArbitrageHops
+			if len(args) > 4 {
+				maxHops, err = strconv.Atoi(args[4])
+				if err != nil || maxHops <= 0 {
+					fmt.Println("cannot parse max hops", args[4])
+					continue
+				}
+			}
+
+			opportunities, err := debugtool.ScanPDEArbitrage([]string{tokenID}, maxHops, maxAmount, 0)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if len(opportunities) == 0 {
+				fmt.Println("No profitable cycle found")
+				continue
+			}
+
+			arb := opportunities[0]
+			fmt.Println(arb)
+			if dryRun {
+				for i, quote := range arb.Plan(nil) {
+					fmt.Printf("Tx %v:\n%v\n", i+1, quote)
+				}
+				continue
+			}
+
+			results, err := debugtool.ExecutePDEArbitrage(context.Background(), privateKey, arb, nil, func(msg string) {
+				fmt.Println(msg)
+			})
+			for _, res := range results {
+				fmt.Println(res)
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+		case "beststable":
+			if len(args) < 2 {
+				fmt.Println("not enough param for beststable")
+			}
+
+			amount, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenID := common.PRVIDStr
+			if len(args) > 2 {
+				tokenID, err = ParseTokenID(args[2])
+				if err != nil {
+					fmt.Println(err)
+				}
+			}
+
+			token, value, err := debugtool.ChooseBestStableCoinPool(tokenID, uint64(amount))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(token, value)
+
+		case "tradestatus":
+			if len(args) < 2 {
+				fmt.Println("need at least 1 argument")
+				continue
+			}
+
+			privateKey := ""
+			if len(args) > 2 {
+				privateKey, err = ParsePrivateKey(args[2], privateKeys)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			timeout := debugtool.DefaultPDETradeTrackTimeout
+			if len(args) > 3 {
+				timeout, err = time.ParseDuration(args[3])
+				if err != nil {
+					fmt.Println("cannot parse timeout", args[3])
+					continue
+				}
+			}
+
+			res, err := debugtool.TrackPDETrade(context.Background(), privateKey, args[1], nil, timeout, func(msg string) {
+				fmt.Println(msg)
+			})
+			if res != nil {
+				fmt.Println(res)
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+		//ORDERS
+		case "orderadd":
+			if len(args) < 7 {
+				fmt.Println("Not enough param for orderadd")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			orderType := strings.ToLower(args[2])
+
+			tokenToSell, err := ParseTokenID(args[3])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			tokenToBuy, err := ParseTokenID(args[4])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			amount, err := strconv.ParseUint(args[5], 10, 64)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			targetRate := float64(0)
+			interval := time.Duration(0)
+			count := 0
+			if orderType == debugtool.OrderDCA {
+				if len(args) < 8 {
+					fmt.Println("dca order needs an interval and a count")
+					continue
+				}
+
+				interval, err = time.ParseDuration(args[6])
+				if err != nil {
+					fmt.Println("cannot parse interval", args[6])
+					continue
+				}
+
+				count, err = strconv.Atoi(args[7])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+
+				if len(args) > 8 {
+					targetRate, err = strconv.ParseFloat(args[8], 64)
+					if err != nil {
+						fmt.Println(err)
+						continue
+					}
+				}
+			} else {
+				targetRate, err = strconv.ParseFloat(args[6], 64)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			order, err := debugtool.NewOrder(privateKey, orderType, tokenToSell, tokenToBuy, amount, targetRate, interval, count)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			err = debugtool.AddOrder(debugtool.DefaultOrderBookFile, order)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Println("Order added:", order)
+
+		case "orders":
+			orders, err := debugtool.ListOrders(debugtool.DefaultOrderBookFile)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			if len(orders) == 0 {
+				fmt.Println("No order found")
+				continue
+			}
+			for _, order := range orders {
+				if len(args) > 1 && args[1] != "all" && order.Status != debugtool.OrderOpen {
+					continue
+				}
+				fmt.Println(order)
+			}
+
+		case "ordercancel":
+			if len(args) < 2 {
+				fmt.Println("need at least 1 argument")
+				continue
+			}
+
+			order, err := debugtool.CancelOrder(debugtool.DefaultOrderBookFile, args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Println("Order cancelled:", order)
+
+		case "orderdaemon":
+			if len(args) < 2 {
+				fmt.Println("need at least 1 argument")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			engine, err := debugtool.NewOrderEngine(privateKey, debugtool.DefaultOrderBookFile)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			engine.OnEvent = func(order *debugtool.Order, msg string) {
+				fmt.Printf("%v order %v: %v\n", time.Now().Format(time.RFC3339), order.ID, msg)
+			}
+
+			if len(args) > 2 {
+				engine.PollInterval, err = time.ParseDuration(args[2])
+				if err != nil {
+					fmt.Println("cannot parse interval", args[2])
+					continue
+				}
+			}
+
+			//Run until interrupted; the orders are saved after every change so the daemon can be restarted at any time.
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				select {
+				case <-interrupt:
+					fmt.Println("Stopping order daemon...")
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			fmt.Printf("Order daemon started, polling every %v, press Ctrl+C to stop\n", engine.PollInterval)
+			err = engine.Run(ctx)
+			signal.Stop(interrupt)
+			cancel()
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+		//STAKING
 		case "staking":
 			if len(args) < 2 {
 				fmt.Println("Not enough param for staking")
@@ -1031,94 +1938,512 @@ func main() {
 				continue
 			}
 
-			autoStaking := true
-			if len(args) > 2 {
-				autoStaking, err = strconv.ParseBool(args[2])
-				if err != nil {
-					autoStaking = false
-				}
+			autoStaking := true
+			if len(args) > 2 {
+				autoStaking, err = strconv.ParseBool(args[2])
+				if err != nil {
+					autoStaking = false
+				}
+			}
+
+			stakingType := metadata.ShardStakingMeta
+			if len(args) > 3 {
+				stakingType, err = debugtool.ParseStakingType(args[3])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			candidateAddr := ""
+			candidate := privateKey
+			if len(args) > 4 {
+				candidateAddr = args[4]
+				candidate = candidateAddr
+			}
+
+			privateSeed, err := GetMiningSeed(reader, &keystorePassphrase, candidate, true)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			txHash, err := debugtool.CreateAndSendStakingTransactionWithType(privateKey, privateSeed, candidateAddr, "", autoStaking, stakingType)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("CreateAndSendStakingTransaction succeeded. TxHash: %v.\n", txHash)
+
+		case "stakingamount":
+			stakingType := metadata.ShardStakingMeta
+			if len(args) > 1 {
+				stakingType, err = debugtool.ParseStakingType(args[1])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			amount, err := debugtool.GetStakingAmount(stakingType)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("Staking amount: %v\n", amount)
+
+		case "canpubkeystake":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for canpubkeystake")
+				continue
+			}
+
+			committeeKey, err := debugtool.ResolveCommitteeKey(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			canStake, err := debugtool.CanPubkeyStake(committeeKey)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("CanStake: %v\n", canStake)
+
+		case "stake-all", "unstake-all", "withdraw-all":
+			if len(args) < 2 {
+				fmt.Printf("Not enough param for %v\n", args[0])
+				continue
+			}
+
+			operation := strings.TrimSuffix(args[0], "-all")
+			fileName := args[1]
+
+			options := &debugtool.BulkOptions{Concurrency: debugtool.DefaultBulkConcurrency}
+			if len(args) > 2 {
+				concurrency, err := strconv.ParseInt(args[2], 10, 32)
+				if err != nil || concurrency <= 0 {
+					fmt.Println("invalid concurrency", args[2])
+					continue
+				}
+				options.Concurrency = int(concurrency)
+			}
+			options.MiningSeed = func(row *debugtool.ValidatorManifestRow) (string, error) {
+				return GetMiningSeed(reader, &keystorePassphrase, row.Candidate, operation == debugtool.BulkStake)
+			}
+			options.OnResult = func(res *debugtool.BulkRowResult) {
+				fmt.Printf("%v %v\n", time.Now().Format(time.RFC3339), res)
+			}
+
+			rows, err := debugtool.ParseValidatorManifest(fileName)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			//Rows already started are waited for when interrupted; the others are reported as cancelled.
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				select {
+				case <-interrupt:
+					fmt.Printf("Stopping %v...\n", args[0])
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			fmt.Printf("Running %v on %v rows, %v at a time, press Ctrl+C to stop\n", args[0], len(rows), options.Concurrency)
+			report, err := debugtool.RunValidatorBulk(ctx, operation, fileName, rows, options)
+			signal.Stop(interrupt)
+			cancel()
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Println(report)
+			reportFile := fmt.Sprintf("%v.%v.report.json", fileName, operation)
+			err = report.Save(reportFile)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("Report saved to %v\n", reportFile)
+
+		case "validator":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for validator")
+				continue
+			}
+
+			committeeKey, err := debugtool.ResolveCommitteeKey(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			rewardReceiver := ""
+			watch := false
+			watchInterval := debugtool.DefaultValidatorWatchInterval
+			for i := 2; i < len(args); i++ {
+				if args[i] != "--watch" {
+					rewardReceiver = args[i]
+					continue
+				}
+				watch = true
+				if i+1 < len(args) {
+					if interval, err := time.ParseDuration(args[i+1]); err == nil {
+						watchInterval = interval
+						i++
+					}
+				}
+			}
+
+			if !watch {
+				status, err := debugtool.GetValidatorStatus(committeeKey, rewardReceiver)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				fmt.Println(status)
+				continue
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				select {
+				case <-interrupt:
+					fmt.Println("Stopping validator watch...")
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			fmt.Printf("Watching validator, refreshed every epoch (checked every %v), press Ctrl+C to stop\n", watchInterval)
+			debugtool.WatchValidatorStatus(ctx, committeeKey, rewardReceiver, watchInterval, func(status *debugtool.ValidatorStatus, err error) {
+				if err != nil {
+					fmt.Printf("%v: %v\n", time.Now().Format(time.RFC3339), err)
+					return
+				}
+				fmt.Println(status)
+			})
+			signal.Stop(interrupt)
+			cancel()
+
+		case "unstaking":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for staking")
+				continue
+			}
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			candidateAddr := ""
+			if len(args) > 2 {
+				candidateAddr = args[2]
+			}
+
+			candidate := privateKey
+			if len(candidateAddr) != 0 {
+				candidate = candidateAddr
+			}
+			privateSeed, err := GetMiningSeed(reader, &keystorePassphrase, candidate, false)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			txHash, err := debugtool.CreateAndSendUnStakingTransaction(privateKey, privateSeed, candidateAddr)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("CreateAndSendUnStakingTransaction succeeded. TxHash: %v.\n", txHash)
+
+		case "reward":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for staking")
+				continue
+			}
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			candidateAddr := ""
+			if len(args) > 2 {
+				candidateAddr = args[2]
+			}
+
+			txHash, err := debugtool.CreateAndSendWithDrawRewardTransaction(privateKey, candidateAddr)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("CreateAndSendWithDrawRewardTransaction succeeded. TxHash: %v.\n", txHash)
+
+		case "rewards":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for rewards")
+				continue
+			}
+			key := args[1]
+			if len(key) < 3 {
+				key, err = ParsePrivateKey(args[1], privateKeys)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			rewards, err := debugtool.GetRewardAmounts(key)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if len(rewards) == 0 {
+				fmt.Println("No reward")
+				continue
+			}
+			for tokenID, amount := range rewards {
+				fmt.Printf("%v: %v\n", tokenID, amount)
+			}
+
+		case "withdrawall":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for withdrawall")
+				continue
+			}
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			minAmount := uint64(0)
+			if len(args) > 2 {
+				minAmount, err = strconv.ParseUint(args[2], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse minimum amount", args[2])
+					continue
+				}
+			}
+
+			withdrawals, err := debugtool.WithdrawAllRewards(privateKey, "", nil, minAmount)
+			for _, withdrawal := range withdrawals {
+				fmt.Printf("Withdrawal of %v %v sent. TxHash: %v.\n", withdrawal.Amount, withdrawal.TokenID, withdrawal.TxHash)
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if len(withdrawals) == 0 {
+				fmt.Println("No reward to withdraw")
+			}
+
+		case "rewarddaemon":
+			if len(args) < 3 {
+				fmt.Println("Not enough param for rewarddaemon")
+				continue
+			}
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			threshold, err := strconv.ParseUint(args[2], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse threshold", args[2])
+				continue
 			}
 
-			var privateSeed string
-			privateSeed, ok := privateSeeds[privateKey]
-			if !ok {
-				privateSeedBytes := privacy.HashToScalar([]byte(privateKey)).ToBytesS()
-				privateSeed = base58.Base58Check{}.Encode(privateSeedBytes, common.ZeroByte)
-				privateSeeds[privateKey] = privateSeed
-				fmt.Println("privateSeed", privateSeed)
+			forwardAddr := ""
+			if len(args) > 3 {
+				forwardAddr = args[3]
 			}
 
-			txHash, err := debugtool.CreateAndSendStakingTransaction(privateKey, privateSeed, "", "", autoStaking)
+			scheduler, err := debugtool.NewRewardScheduler(privateKey, threshold, forwardAddr)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
+			if len(args) > 4 {
+				scheduler.PollInterval, err = time.ParseDuration(args[4])
+				if err != nil {
+					fmt.Println("cannot parse interval", args[4])
+					continue
+				}
+			}
+			scheduler.OnEvent = func(msg string) {
+				fmt.Printf("%v %v\n", time.Now().Format(time.RFC3339), msg)
+			}
 
-			fmt.Printf("CreateAndSendStakingTransaction succeeded. TxHash: %v.\n", txHash)
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				select {
+				case <-interrupt:
+					fmt.Println("Stopping reward daemon...")
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
 
-		case "unstaking":
+			fmt.Printf("Reward daemon started, checking every %v, press Ctrl+C to stop\n", scheduler.PollInterval)
+			scheduler.Run(ctx)
+			signal.Stop(interrupt)
+			cancel()
+
+		case "listreward":
+			b, err := rpc.GetListRewardAmount()
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(string(b))
+
+		//VALIDATOR KEYS
+		case "valkeygen":
 			if len(args) < 2 {
-				fmt.Println("Not enough param for staking")
+				fmt.Println("need at least 1 argument")
 				continue
 			}
-			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+
+			candidate, err := ParsePrivateKey(args[1], privateKeys)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
 
-			var privateSeed string
-			privateSeed, ok := privateSeeds[privateKey]
-			if !ok {
-				privateSeedBytes := privacy.HashToScalar([]byte(privateKey)).ToBytesS()
-				privateSeed = base58.Base58Check{}.Encode(privateSeedBytes, common.ZeroByte)
-				privateSeeds[privateKey] = privateSeed
+			keystore, err := debugtool.LoadValidatorKeystore(debugtool.DefaultValidatorKeystoreFile)
+			if err != nil {
+				fmt.Println(err)
+				continue
 			}
 
-			candidateAddr := ""
-			if len(args) > 2 {
-				candidateAddr = args[2]
+			passphrase := keystorePassphrase
+			if len(passphrase) == 0 {
+				passphrase = ReadKeystorePassphrase(reader)
 			}
 
-			txHash, err := debugtool.CreateAndSendUnStakingTransaction(privateKey, privateSeed, candidateAddr)
+			key, err := keystore.Generate(candidate, passphrase)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
+			keystorePassphrase = passphrase
+			fmt.Println(key)
 
-			fmt.Printf("CreateAndSendUnStakingTransaction succeeded. TxHash: %v.\n", txHash)
+		case "valkeyimport":
+			if len(args) < 3 {
+				fmt.Println("need at least 2 arguments")
+				continue
+			}
 
-		case "reward":
-			if len(args) < 2 {
-				fmt.Println("Not enough param for staking")
+			candidate, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
 				continue
 			}
-			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+
+			miningSeed := args[2]
+			if miningSeed == "legacy" {
+				keyWallet, err := wallet.Base58CheckDeserialize(candidate)
+				if err != nil || len(keyWallet.KeySet.PrivateKey) == 0 {
+					fmt.Println("the legacy mining key can only be derived from the private key of the candidate")
+					continue
+				}
+				miningSeed = debugtool.LegacyMiningSeed(candidate)
+			}
+
+			keystore, err := debugtool.LoadValidatorKeystore(debugtool.DefaultValidatorKeystoreFile)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
 
-			candidateAddr := ""
-			if len(args) > 2 {
-				candidateAddr = args[2]
+			passphrase := keystorePassphrase
+			if len(passphrase) == 0 {
+				passphrase = ReadKeystorePassphrase(reader)
 			}
 
-			txHash, err := debugtool.CreateAndSendWithDrawRewardTransaction(privateKey, candidateAddr)
+			key, err := keystore.Import(candidate, miningSeed, passphrase)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
+			keystorePassphrase = passphrase
+			fmt.Println(key)
 
-			fmt.Printf("CreateAndSendWithDrawRewardTransaction succeeded. TxHash: %v.\n", txHash)
+		case "valkeys":
+			keystore, err := debugtool.LoadValidatorKeystore(debugtool.DefaultValidatorKeystoreFile)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
 
-		case "listreward":
-			b, err := rpc.GetListRewardAmount()
+			if len(keystore.Keys) == 0 {
+				fmt.Printf("No mining key in %v\n", debugtool.DefaultValidatorKeystoreFile)
+				continue
+			}
+			for _, key := range keystore.Keys {
+				fmt.Println(key)
+			}
+
+		case "valkeyexport":
+			keystore, err := debugtool.LoadValidatorKeystore(debugtool.DefaultValidatorKeystoreFile)
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
-			fmt.Println(string(b))
+
+			passphrase := keystorePassphrase
+			if len(passphrase) == 0 {
+				passphrase = ReadKeystorePassphrase(reader)
+			}
+
+			if len(args) > 1 {
+				candidate, err := ParsePrivateKey(args[1], privateKeys)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+
+				miningSeed, err := keystore.GetMiningSeed(candidate, passphrase)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				keystorePassphrase = passphrase
+				fmt.Printf("--miningkeys \"%v\"\n", miningSeed)
+				continue
+			}
+
+			miningKeys, err := keystore.ExportMiningKeys(passphrase)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			keystorePassphrase = passphrase
+			for _, key := range keystore.Keys {
+				fmt.Printf("%v: %v\n", key.Candidate, miningKeys[key.Candidate])
+			}
 
 		//BRIDGE
 		case "ethhash":
@@ -1176,7 +2501,7 @@ func main() {
 			fmt.Println(b.BlockHash.String(), b.BlockNumber, b.TxHash.String())
 
 		case "shield":
-			if len(args) < 4 {
+			if len(args) < 3 {
 				fmt.Println("Not enough param for shield")
 				continue
 			}
@@ -1187,21 +2512,157 @@ func main() {
 				continue
 			}
 
+			//The tokenID is optional, it is looked up from the deposited token if omitted.
+			tokenID := ""
+			ethTxHash := args[2]
+			if len(args) > 3 {
+				tokenID, err = ParseTokenID(args[2])
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				ethTxHash = args[3]
+			}
+
+			txHash, err := debugtool.CreateAndSendIssuingETHRequestTransaction(privateKey, ethTxHash, tokenID)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("CreateAndSendIssuingETHRequestTransaction succeeded. TxHash: %v.\n", txHash)
+
+		case "shieldinfo":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for shieldinfo")
+				continue
+			}
+			var url = ""
+			if len(args) > 2 {
+				url = args[2]
+			}
+
+			depositInfo, err := debugtool.GetETHDepositInfo(url, args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Println(depositInfo)
+
+		case "verifyshield":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for verifyshield")
+				continue
+			}
+			minConfirmations := uint64(debugtool.DefaultETHConfirmations)
+			if len(args) > 2 {
+				minConfirmations, err = strconv.ParseUint(args[2], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse confirmations", args[2], err)
+					continue
+				}
+			}
+
+			proof, depositInfo, err := debugtool.GetETHDepositProof("", args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(depositInfo)
+
+			confirmations, err := debugtool.VerifyETHDepositProof("", args[1], proof, minConfirmations)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fmt.Printf("Proof of %v verified, %v confirmations.\n", args[1], confirmations)
+
+		case "unshield":
+			if len(args) < 5 {
+				fmt.Println("Not enough param for unshield")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
 			tokenID, err := ParseTokenID(args[2])
 			if err != nil {
 				fmt.Println(err)
 				continue
 			}
 
-			ethTxHash := args[3]
+			amount, err := strconv.ParseUint(args[3], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse amount", args[3], err)
+				continue
+			}
 
-			txHash, err := debugtool.CreateAndSendIssuingETHRequestTransaction(privateKey, ethTxHash, tokenID)
+			ethAddr := args[4]
+			evmURL, sender := "", ""
+			if len(args) > 6 {
+				evmURL, sender = args[5], args[6]
+			}
+
+			//Waiting for the beacon chain can take a while, stop on Ctrl+C.
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			go func() {
+				select {
+				case <-interrupt:
+					fmt.Println("Stopping unshield...")
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			txHash, proof, err := debugtool.Unshield(ctx, privateKey, tokenID, amount, ethAddr, func(msg string) {
+				fmt.Printf("%v %v\n", time.Now().Format(time.RFC3339), msg)
+			})
+			signal.Stop(interrupt)
+			cancel()
 			if err != nil {
 				fmt.Println(err)
+				if len(txHash) != 0 {
+					fmt.Printf("Resume with: burnproof %v\n", txHash)
+				}
 				continue
 			}
 
-			fmt.Printf("CreateAndSendIssuingETHRequestTransaction succeeded. TxHash: %v.\n", txHash)
+			err = PrintOrSendETHWithdraw(proof, evmURL, sender)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+		case "burnproof":
+			if len(args) < 2 {
+				fmt.Println("Not enough param for burnproof")
+				continue
+			}
+			evmURL, sender := "", ""
+			if len(args) > 3 {
+				evmURL, sender = args[2], args[3]
+			}
+
+			proof, err := debugtool.GetBurnProof(args[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(proof)
+
+			err = PrintOrSendETHWithdraw(proof, evmURL, sender)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
 
 		//GENERAL
 		case "shard":
@@ -1222,23 +2683,130 @@ func main() {
 			}
 			fmt.Println(b, string(b))
 
-		case "gencan":
-			for i := 0; i < 10; i++ {
-				privateKey, _, _ := GenKeySet([]byte(fmt.Sprintf("can%v", i)))
-				privateSeedBytes := privacy.HashToScalar([]byte(privateKey)).ToBytesS()
-				privateSeed := base58.Base58Check{}.Encode(privateSeedBytes, common.ZeroByte)
+		case "genlocalnet":
+			if len(args) < 5 {
+				fmt.Println("Not enough param for genlocalnet")
+				continue
+			}
+
+			sizes := make([]int, 4)
+			for i := range sizes {
+				var size int64
+				size, err = strconv.ParseInt(args[i+1], 10, 32)
+				if err != nil {
+					break
+				}
+				sizes[i] = int(size)
+			}
+			if err != nil {
+				fmt.Println("cannot parse the network sizes:", err)
+				continue
+			}
+			config := debugtool.NewLocalNetConfig(sizes[0], sizes[1], sizes[2], sizes[3])
+
+			outputDir := "localnet"
+			if len(args) > 5 {
+				outputDir = args[5]
+			}
+			if len(args) > 6 {
+				config.Seed = args[6]
+			}
+			if len(args) > 7 {
+				numAccounts, err := strconv.ParseInt(args[7], 10, 32)
+				if err != nil {
+					fmt.Println("cannot parse number of accounts", args[7])
+					continue
+				}
+				config.AccountsPerShard = int(numAccounts)
+			}
 
-				fmt.Printf("if [ \"$1\" == \"can%v\" ]; then\n", i)
-				toBePrinted2 := fmt.Sprintf("./incognito --datadir \"data/staker%v\" --rpclisten \"0.0.0.0:%v\" --listen \"0.0.0.0:%v\" --miningkeys \"%v\" --discoverpeersaddress \"0.0.0.0:9330\" --externaladdress \"0.0.0.0:%v\" --norpcauth",
-					i, 10335+i, 10452+i, privateSeed, 10452+i)
-				fmt.Println(toBePrinted2)
-				fmt.Println("fi")
+			localNet, err := debugtool.GenerateLocalNet(config)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			err = localNet.Write(outputDir)
+			if err != nil {
+				fmt.Println(err)
+				continue
 			}
 
+			fmt.Println(localNet)
+			fmt.Printf("Local network written to %v, start it with %v/run_all.sh\n", outputDir, outputDir)
+
 		case "changeb58":
 			common.AddressVersion = 1 - common.AddressVersion
 			fmt.Printf("Address version changed to: %v\n", common.AddressVersion)
 
+		//JOURNAL
+		case "journal":
+			journal := debugtool.GetTxJournal()
+			if journal == nil {
+				fmt.Println("tx journal is not enabled")
+				continue
+			}
+			for _, entry := range journal.Entries() {
+				fmt.Println(entry)
+			}
+
+		case "rebroadcast":
+			timeout := int64(debugtool.DefaultStuckTxTimeout.Seconds())
+			if len(args) > 1 {
+				if len(args[1]) == 64 {
+					err = debugtool.RebroadcastTx(args[1])
+					if err != nil {
+						fmt.Println(err)
+						continue
+					}
+					fmt.Printf("Rebroadcast tx %v succeeded.\n", args[1])
+					continue
+				}
+
+				timeout, err = strconv.ParseInt(args[1], 10, 64)
+				if err != nil {
+					fmt.Println("cannot parse timeout", err)
+					continue
+				}
+			}
+
+			txList, err := debugtool.RebroadcastStuckTxs(time.Duration(timeout) * time.Second)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("Rebroadcast %v stuck txs: %v\n", len(txList), txList)
+
+		case "rebroadcastnodes":
+			if len(args) > 1 {
+				debugtool.SetRebroadcastNodes(args[1:])
+			}
+			fmt.Println("Rebroadcast nodes:", debugtool.GetRebroadcastNodes())
+
+		case "bumpfee":
+			if len(args) < 4 {
+				fmt.Println("not enough param for bumpfee")
+				continue
+			}
+
+			privateKey, err := ParsePrivateKey(args[1], privateKeys)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fee, err := strconv.ParseUint(args[3], 10, 64)
+			if err != nil {
+				fmt.Println("cannot parse fee", err)
+				continue
+			}
+
+			txHash, err := debugtool.BumpTxFee(privateKey, args[2], fee)
+			if err != nil {
+				fmt.Println("BumpTxFee returns an error:", err)
+				continue
+			}
+			fmt.Printf("BumpTxFee succeeded. TxHash: %v.\n", txHash)
+
 		//SECURE
 		case "stransfer":
 			if len(args) < 4 {
@@ -0,0 +1,74 @@
+package metadata
+
+import (
+	"github.com/thanhn-inc/debugtool/common"
+	"github.com/thanhn-inc/debugtool/privacy"
+)
+
+// BurningRequest - burn a bridged token to unshield it to another chain
+type BurningRequest struct {
+	BurnerAddress privacy.PaymentAddress
+	BurningAmount uint64 // must be equal to vout value
+	TokenID       common.Hash
+	TokenName     string
+	RemoteAddress string
+	MetadataBase
+}
+
+func NewBurningRequest(
+	burnerAddress privacy.PaymentAddress,
+	burningAmount uint64,
+	tokenID common.Hash,
+	tokenName string,
+	remoteAddress string,
+	metaType int,
+) (*BurningRequest, error) {
+	metadataBase := MetadataBase{
+		Type: metaType, Sig: []byte{},
+	}
+	burningReq := &BurningRequest{
+		BurnerAddress: burnerAddress,
+		BurningAmount: burningAmount,
+		TokenID:       tokenID,
+		TokenName:     tokenName,
+		RemoteAddress: remoteAddress,
+	}
+	burningReq.MetadataBase = metadataBase
+	return burningReq, nil
+}
+
+func (*BurningRequest) ShouldSignMetaData() bool { return true }
+
+func (bReq BurningRequest) Hash() *common.Hash {
+	record := bReq.hashRecord()
+	if bReq.Sig != nil && len(bReq.Sig) != 0 {
+		record += string(bReq.Sig)
+	}
+
+	// final hash
+	hash := common.HashH([]byte(record))
+	return &hash
+}
+
+func (bReq BurningRequest) HashWithoutSig() *common.Hash {
+	record := bReq.hashRecord()
+
+	// final hash
+	hash := common.HashH([]byte(record))
+	return &hash
+}
+
+func (bReq BurningRequest) hashRecord() string {
+	record := bReq.MetadataBase.Hash().String()
+	record += bReq.BurnerAddress.String()
+	record += bReq.TokenID.String()
+	// TODO: @hung change to record += fmt.Sprint(bReq.BurningAmount)
+	record += string(bReq.BurningAmount)
+	record += bReq.TokenName
+	record += bReq.RemoteAddress
+	return record
+}
+
+func (bReq *BurningRequest) CalculateSize() uint64 {
+	return calculateSize(bReq)
+}
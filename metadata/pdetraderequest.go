@@ -41,6 +41,29 @@ type PDETradeAcceptedContent struct {
 	RequestedTxID            common.Hash
 }
 
+type PDECrossPoolTradeAcceptedContent struct {
+	TraderAddressStr         string
+	TxRandomStr              string
+	TokenIDToBuyStr          string
+	ReceiveAmount            uint64
+	Token1IDStr              string
+	Token2IDStr              string
+	Token1PoolValueOperation TokenPoolValueOperation
+	Token2PoolValueOperation TokenPoolValueOperation
+	ShardID                  byte
+	RequestedTxID            common.Hash
+	AddingFee                uint64
+}
+
+type PDERefundCrossPoolTrade struct {
+	TraderAddressStr string
+	TxRandomStr      string
+	TokenIDStr       string
+	Amount           uint64
+	ShardID          byte
+	TxReqID          common.Hash
+}
+
 func NewPDETradeRequest(
 	tokenIDToBuyStr string,
 	tokenIDToSellStr string,
@@ -0,0 +1,29 @@
+package jsonresult
+
+type BridgeTokenInfo struct {
+	TokenID         string `json:"tokenId"`
+	Amount          uint64 `json:"amount"`
+	ExternalTokenID []byte `json:"externalTokenId"`
+	Network         string `json:"network"`
+	IsCentralized   bool   `json:"isCentralized"`
+}
+
+type InstructionProof struct {
+	Instruction  string
+	BeaconHeight string
+	BridgeHeight string
+
+	BeaconInstPath       []string
+	BeaconInstPathIsLeft []bool
+	BeaconInstRoot       string
+	BeaconBlkData        string
+	BeaconSigs           []string
+	BeaconSigIdxs        []int
+
+	BridgeInstPath       []string
+	BridgeInstPathIsLeft []bool
+	BridgeInstRoot       string
+	BridgeBlkData        string
+	BridgeSigs           []string
+	BridgeSigIdxs        []int
+}
@@ -0,0 +1,17 @@
+package jsonresult
+
+type CandidateListsResult struct {
+	Epoch                                  uint64   `json:"Epoch"`
+	CandidateShardWaitingForCurrentRandom  []string `json:"CandidateShardWaitingForCurrentRandom"`
+	CandidateBeaconWaitingForCurrentRandom []string `json:"CandidateBeaconWaitingForCurrentRandom"`
+	CandidateShardWaitingForNextRandom     []string `json:"CandidateShardWaitingForNextRandom"`
+	CandidateBeaconWaitingForNextRandom    []string `json:"CandidateBeaconWaitingForNextRandom"`
+}
+
+type CommitteeListsResult struct {
+	Epoch                  uint64            `json:"Epoch"`
+	BeaconCommittee        []string          `json:"BeaconCommittee"`
+	BeaconPendingValidator []string          `json:"BeaconPendingValidator"`
+	ShardCommittee         map[byte][]string `json:"ShardCommittee"`
+	ShardPendingValidator  map[byte][]string `json:"ShardPendingValidator"`
+}
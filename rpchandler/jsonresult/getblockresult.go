@@ -0,0 +1,41 @@
+package jsonresult
+
+type GetShardBlockResult struct {
+	Hash              string     `json:"Hash"`
+	ShardID           byte       `json:"ShardID"`
+	Height            uint64     `json:"Height"`
+	Confirmations     int64      `json:"Confirmations"`
+	Version           int        `json:"Version"`
+	TxRoot            string     `json:"TxRoot"`
+	Time              int64      `json:"Time"`
+	PreviousBlockHash string     `json:"PreviousBlockHash"`
+	NextBlockHash     string     `json:"NextBlockHash"`
+	TxHashes          []string   `json:"TxHashes"`
+	BlockProducer     string     `json:"BlockProducer"`
+	Epoch             uint64     `json:"Epoch"`
+	Round             int        `json:"Round"`
+	BeaconHeight      uint64     `json:"BeaconHeight"`
+	BeaconBlockHash   string     `json:"BeaconBlockHash"`
+	Instructions      [][]string `json:"Instructions"`
+}
+
+type GetBeaconBlockResult struct {
+	Hash              string                `json:"Hash"`
+	Height            uint64                `json:"Height"`
+	Confirmations     int64                 `json:"Confirmations"`
+	Version           int                   `json:"Version"`
+	Time              int64                 `json:"Time"`
+	PreviousBlockHash string                `json:"PreviousBlockHash"`
+	NextBlockHash     string                `json:"NextBlockHash"`
+	BlockProducer     string                `json:"BlockProducer"`
+	Epoch             uint64                `json:"Epoch"`
+	Round             int                   `json:"Round"`
+	Instructions      [][]string            `json:"Instructions"`
+	ShardStates       map[byte][]ShardState `json:"ShardStates"`
+}
+
+type ShardState struct {
+	Height     uint64 `json:"Height"`
+	Hash       string `json:"Hash"`
+	CrossShard []byte `json:"CrossShard"`
+}
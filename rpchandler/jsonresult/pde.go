@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/thanhn-inc/debugtool/common"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 // key prefix
@@ -42,6 +44,16 @@ type PDEContribution struct {
 	TxReqID               common.Hash
 }
 
+type PDEContributionStatus struct {
+	Status             byte
+	TokenID1Str        string
+	Contributed1Amount uint64
+	Returned1Amount    uint64
+	TokenID2Str        string
+	Contributed2Amount uint64
+	Returned2Amount    uint64
+}
+
 func BuildPDEPoolForPairKey(
 	beaconHeight uint64,
 	token1IDStr string,
@@ -53,3 +65,48 @@ func BuildPDEPoolForPairKey(
 	sort.Strings(tokenIDStrs)
 	return append(pdePoolForPairByBCHeightPrefix, []byte(tokenIDStrs[0]+"-"+tokenIDStrs[1])...)
 }
+
+func BuildPDESharesKeyV2(
+	beaconHeight uint64,
+	token1IDStr string,
+	token2IDStr string,
+	contributorAddressStr string,
+) []byte {
+	beaconHeightBytes := []byte(fmt.Sprintf("%d-", beaconHeight))
+	pdeSharesByBCHeightPrefix := append(PDESharePrefix, beaconHeightBytes...)
+	tokenIDStrs := []string{token1IDStr, token2IDStr}
+	sort.Strings(tokenIDStrs)
+	return append(pdeSharesByBCHeightPrefix, []byte(tokenIDStrs[0]+"-"+tokenIDStrs[1]+"-"+contributorAddressStr)...)
+}
+
+func BuildPDETradingFeeKey(
+	beaconHeight uint64,
+	token1IDStr string,
+	token2IDStr string,
+	contributorAddressStr string,
+) []byte {
+	beaconHeightBytes := []byte(fmt.Sprintf("%d-", beaconHeight))
+	pdeTradingFeeByBCHeightPrefix := append(PDETradingFeePrefix, beaconHeightBytes...)
+	tokenIDStrs := []string{token1IDStr, token2IDStr}
+	sort.Strings(tokenIDStrs)
+	return append(pdeTradingFeeByBCHeightPrefix, []byte(tokenIDStrs[0]+"-"+tokenIDStrs[1]+"-"+contributorAddressStr)...)
+}
+
+//ParsePDEContributorKey splits a share key or a trading fee key into its beacon height, tokens and contributor address.
+func ParsePDEContributorKey(prefix []byte, key string) (uint64, string, string, string, error) {
+	if !strings.HasPrefix(key, string(prefix)) {
+		return 0, "", "", "", fmt.Errorf("key %v does not start with %v", key, string(prefix))
+	}
+
+	parts := strings.SplitN(key[len(prefix):], "-", 4)
+	if len(parts) != 4 {
+		return 0, "", "", "", fmt.Errorf("invalid key %v", key)
+	}
+
+	beaconHeight, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", "", "", fmt.Errorf("invalid beacon height in key %v", key)
+	}
+
+	return beaconHeight, parts[1], parts[2], parts[3], nil
+}
@@ -71,6 +71,7 @@ const (
 	getCandidateList                           = "getcandidatelist"
 	getCommitteeList                           = "getcommitteelist"
 	canPubkeyStake                             = "canpubkeystake"
+	getStakingAmount                           = "getstakingamount"
 	getTotalTransaction                        = "gettotaltransaction"
 	listUnspentCustomToken                     = "listunspentcustomtoken"
 	getBalanceCustomToken                      = "getbalancecustomtoken"
@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/thanhn-inc/debugtool/rpchandler"
 )
@@ -69,4 +70,58 @@ func GetRawMempool() ([]byte, error) {
 		"id": 1
 	}`
 	return rpchandler.Server.SendPostRequestWithQuery(query)
-}
\ No newline at end of file
+}
+
+func GetShardPoolState(shardID byte) ([]byte, error) {
+	if len(rpchandler.Server.GetURL()) == 0 {
+		return []byte{}, errors.New("Server has not set mainnet or testnet")
+	}
+	method := getShardPoolState
+	params := make([]interface{}, 0)
+	params = append(params, shardID)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+func RetrieveBlockByHeight(shardID byte, height uint64) ([]byte, error) {
+	if len(rpchandler.Server.GetURL()) == 0 {
+		return []byte{}, errors.New("Server has not set mainnet or testnet")
+	}
+	method := retrieveBlockByHeight
+	params := make([]interface{}, 0)
+	params = append(params, height)
+	params = append(params, shardID)
+	params = append(params, "1")
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+func RetrieveBeaconBlockByHeight(height uint64) ([]byte, error) {
+	if len(rpchandler.Server.GetURL()) == 0 {
+		return []byte{}, errors.New("Server has not set mainnet or testnet")
+	}
+	method := retrieveBeaconBlockByHeight
+	params := make([]interface{}, 0)
+	params = append(params, height)
+	params = append(params, "1")
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"encoding/json"
+	"github.com/thanhn-inc/debugtool/rpchandler"
+)
+
+//CheckETHHashIssued checks if the ETH deposit at txIdx of block blockHash has already been shielded.
+func CheckETHHashIssued(blockHash string, txIdx uint) ([]byte, error) {
+	method := checkETHHashIssued
+
+	mapParams := make(map[string]interface{})
+	mapParams["BlockHash"] = blockHash
+	mapParams["TxIndex"] = txIdx
+
+	params := make([]interface{}, 0)
+	params = append(params, mapParams)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetBurnProof returns the beacon proof of the burning instruction of a burning request.
+func GetBurnProof(txHash string) ([]byte, error) {
+	method := getBurnProof
+
+	params := make([]interface{}, 0)
+	params = append(params, txHash)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
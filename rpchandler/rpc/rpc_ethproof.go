@@ -61,3 +61,77 @@ func GetETHTransactionReceipt(url string, txHash string) ([]byte, error) {
 
 	return rpchandler.EthServer.SendPostRequestWithQuery(string(query))
 }
+
+func ETHCall(url string, to string, data string) ([]byte, error) {
+	if len(url) != 0 {
+		rpchandler.EthServer.InitToURL(url)
+	}
+
+	method := "eth_call"
+	callMsg := map[string]interface{}{
+		"to":   to,
+		"data": data,
+	}
+	params := []interface{}{callMsg, "latest"}
+
+	request := rpchandler.CreateJsonRequest("2.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.EthServer.SendPostRequestWithQuery(string(query))
+}
+
+func GetETHBlockReceipts(url string, blockHash string) ([]byte, error) {
+	if len(url) != 0 {
+		rpchandler.EthServer.InitToURL(url)
+	}
+
+	method := "eth_getBlockReceipts"
+	params := []interface{}{blockHash}
+
+	request := rpchandler.CreateJsonRequest("2.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.EthServer.SendPostRequestWithQuery(string(query))
+}
+
+func GetETHBlockNumber(url string) ([]byte, error) {
+	if len(url) != 0 {
+		rpchandler.EthServer.InitToURL(url)
+	}
+
+	method := "eth_blockNumber"
+	params := make([]interface{}, 0)
+
+	request := rpchandler.CreateJsonRequest("2.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.EthServer.SendPostRequestWithQuery(string(query))
+}
+
+//ETHSendTransaction sends a transaction from an account unlocked at the EVM endpoint url. The gas is estimated by the endpoint.
+func ETHSendTransaction(url string, from string, to string, data string) ([]byte, error) {
+	method := "eth_sendTransaction"
+	txMsg := map[string]interface{}{
+		"from": from,
+		"to":   to,
+		"data": data,
+	}
+	params := []interface{}{txMsg}
+
+	request := rpchandler.CreateJsonRequest("2.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(rpchandler.RPCServer).InitToURL(url).SendPostRequestWithQuery(string(query))
+}
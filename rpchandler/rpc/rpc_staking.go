@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/thanhn-inc/debugtool/rpchandler"
 	"github.com/thanhn-inc/debugtool/wallet"
@@ -96,3 +97,136 @@ func WithdrawReward(privKey string, tokenID string) ([]byte, error) {
 	}`, privKey, paymentAddStr, tokenID)
 	return rpchandler.Server.SendPostRequestWithQuery(query)
 }
+
+//GetStakingAmount returns the amount to stake: stakingType is 0 for shard staking, 1 for beacon staking.
+func GetStakingAmount(stakingType int) ([]byte, error) {
+	method := getStakingAmount
+	params := make([]interface{}, 0)
+	params = append(params, stakingType)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//CanPubkeyStake checks that a base58-encoded committee public key is neither staked nor being staked.
+func CanPubkeyStake(committeeKey string) ([]byte, error) {
+	method := canPubkeyStake
+	params := make([]interface{}, 0)
+	params = append(params, committeeKey)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetCandidateList returns the shard and beacon candidates waiting for a random number, as base58-encoded committee keys.
+func GetCandidateList() ([]byte, error) {
+	method := getCandidateList
+	params := make([]interface{}, 0)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetCommitteeList returns the committees and pending validators of the beacon chain and the shards.
+func GetCommitteeList() ([]byte, error) {
+	method := getCommitteeList
+	params := make([]interface{}, 0)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetIncognitoPublicKeyRole returns the role of a base58-encoded incognito public key.
+func GetIncognitoPublicKeyRole(incPubKey string) ([]byte, error) {
+	method := getIncognitoPublicKeyRole
+	params := make([]interface{}, 0)
+	params = append(params, incPubKey)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetPublicKeyRole returns the role of a mining public key, given as "<consensus>:<base58 key>" (e.g. "bls:...").
+func GetPublicKeyRole(miningKey string) ([]byte, error) {
+	method := getPublicKeyRole
+	params := make([]interface{}, 0)
+	params = append(params, miningKey)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetAutoStakingByHeight returns the auto-staking flags of the staked committee keys at a beacon height.
+func GetAutoStakingByHeight(beaconHeight uint64) ([]byte, error) {
+	method := getAutoStakingByHeight
+	params := make([]interface{}, 0)
+	params = append(params, beaconHeight)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetRewardAmountByPublicKey returns the rewards, per token, of a base58-encoded public key.
+func GetRewardAmountByPublicKey(publicKey string) ([]byte, error) {
+	method := getRewardAmountByPublicKey
+	params := make([]interface{}, 0)
+	params = append(params, publicKey)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
+
+//GetProducersBlackList returns the slashed block producers at a beacon height, with their remaining punished epochs.
+func GetProducersBlackList(beaconHeight uint64) ([]byte, error) {
+	method := getProducersBlackList
+	params := make([]interface{}, 0)
+	params = append(params, beaconHeight)
+
+	request := rpchandler.CreateJsonRequest("1.0", method, params, 1)
+	query, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+}
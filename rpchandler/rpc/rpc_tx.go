@@ -69,7 +69,12 @@ func GetBlockHeightTransactionByHash(txHash string) (int, error) {
 
 // Get the whole result of rpc call 'gettransactionbyhash'
 func GetTransactionByHash(txHash string) ([]byte, error) {
-	if len(rpchandler.Server.GetURL()) == 0 {
+	return GetTransactionByHashFromServer(rpchandler.Server, txHash)
+}
+
+// Get the whole result of rpc call 'gettransactionbyhash' from the given server
+func GetTransactionByHashFromServer(server *rpchandler.RPCServer, txHash string) ([]byte, error) {
+	if len(server.GetURL()) == 0 {
 		return []byte{}, errors.New("Server has not set mainnet or testnet")
 	}
 	query := fmt.Sprintf(`{
@@ -78,7 +83,7 @@ func GetTransactionByHash(txHash string) ([]byte, error) {
 		"params":["%s"],
 		"id":1
 	}`, txHash)
-	return rpchandler.Server.SendPostRequestWithQuery(query)
+	return server.SendPostRequestWithQuery(query)
 }
 
 //========== END GET RPCs ==========
@@ -235,6 +240,11 @@ func SwitchCoinVersion(privKey string) ([]byte, error) {
 //========== END CREATE TX RPCs ==========
 
 func SendRawTx(encodedTx string) ([]byte, error) {
+	return SendRawTxToServer(rpchandler.Server, encodedTx)
+}
+
+//SendRawTxToServer sends a raw PRV transaction to the given server instead of the default one.
+func SendRawTxToServer(server *rpchandler.RPCServer, encodedTx string) ([]byte, error) {
 	method := sendRawTransaction
 	params := make([]interface{}, 0)
 	params = append(params, encodedTx)
@@ -245,10 +255,15 @@ func SendRawTx(encodedTx string) ([]byte, error) {
 		return nil, err
 	}
 
-	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+	return server.SendPostRequestWithQuery(string(query))
 }
 
 func SendRawTokenTx(encodedTx string) ([]byte, error) {
+	return SendRawTokenTxToServer(rpchandler.Server, encodedTx)
+}
+
+//SendRawTokenTxToServer sends a raw token transaction to the given server instead of the default one.
+func SendRawTokenTxToServer(server *rpchandler.RPCServer, encodedTx string) ([]byte, error) {
 	method := sendRawPrivacyCustomTokenTransaction
 	params := make([]interface{}, 0)
 	params = append(params, encodedTx)
@@ -259,7 +274,7 @@ func SendRawTokenTx(encodedTx string) ([]byte, error) {
 		return nil, err
 	}
 
-	return rpchandler.Server.SendPostRequestWithQuery(string(query))
+	return server.SendPostRequestWithQuery(string(query))
 }
 
 func GetTxHashBySerialNumber(snList []string, tokenID string, shardID byte) ([]byte, error) {